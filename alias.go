@@ -0,0 +1,144 @@
+package vcsstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// aliasesFile is the name of the file in StorageDir that holds the
+// alias table.
+const aliasesFile = ".vcsstore-aliases.json"
+
+// A RepositoryAlias makes a repository available at another path, such
+// as its path before it was renamed upstream. Requests for the alias
+// are redirected to the repository's canonical path.
+type RepositoryAlias struct {
+	// From is the alias.
+	From string
+
+	// To is the canonical path of the repository.
+	To string
+}
+
+// CanonicalRepoPath returns the canonical path of the repository at
+// repoPath, which is its normalized path (see PathRules), or the
+// repository that the normalized path is an alias of.
+func (s *service) CanonicalRepoPath(repoPath string) string {
+	repoPath = s.PathRules.Normalize(repoPath)
+	s.aliasesMu.RLock()
+	defer s.aliasesMu.RUnlock()
+	if to, ok := s.aliases[repoPath]; ok {
+		return to
+	}
+	return repoPath
+}
+
+// Aliases returns the alias table, sorted by alias.
+func (s *service) Aliases() []*RepositoryAlias {
+	s.aliasesMu.RLock()
+	defer s.aliasesMu.RUnlock()
+	aliases := make([]*RepositoryAlias, 0, len(s.aliases))
+	for from, to := range s.aliases {
+		aliases = append(aliases, &RepositoryAlias{From: from, To: to})
+	}
+	sort.Sort(repositoryAliasesByFrom(aliases))
+	return aliases
+}
+
+type repositoryAliasesByFrom []*RepositoryAlias
+
+func (v repositoryAliasesByFrom) Len() int           { return len(v) }
+func (v repositoryAliasesByFrom) Less(i, j int) bool { return v[i].From < v[j].From }
+func (v repositoryAliasesByFrom) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+// SetAlias adds alias.From to the alias table as an alias of the
+// repository alias.To. If alias.To is empty, alias.From is removed from
+// the alias table instead. Both paths are normalized first.
+//
+// Aliases never refer to other aliases: if alias.To is an alias, the
+// new alias refers to its repository instead, and existing aliases of
+// alias.From are changed to refer to alias.To.
+func (s *service) SetAlias(alias *RepositoryAlias) error {
+	from := s.PathRules.Normalize(alias.From)
+	if from == "" {
+		return fmt.Errorf("empty alias")
+	}
+
+	s.aliasesMu.Lock()
+	defer s.aliasesMu.Unlock()
+
+	aliases := make(map[string]string, len(s.aliases)+1)
+	for k, v := range s.aliases {
+		aliases[k] = v
+	}
+	if alias.To == "" {
+		if _, ok := aliases[from]; !ok {
+			return &os.PathError{Op: "remove alias", Path: from, Err: os.ErrNotExist}
+		}
+		delete(aliases, from)
+	} else {
+		to := s.PathRules.Normalize(alias.To)
+		if t, ok := aliases[to]; ok {
+			to = t
+		}
+		if to == from {
+			return fmt.Errorf("alias %s would refer to itself", from)
+		}
+		aliases[from] = to
+		for k, v := range aliases {
+			if v == from {
+				aliases[k] = to
+			}
+		}
+	}
+
+	if err := writeAliases(s.StorageDir, aliases); err != nil {
+		return err
+	}
+	s.aliases = aliases
+	return nil
+}
+
+// loadAliases reads the alias table from StorageDir.
+func (s *service) loadAliases() {
+	aliases := map[string]string{}
+	data, err := ioutil.ReadFile(filepath.Join(s.StorageDir, aliasesFile))
+	if err == nil {
+		err = json.Unmarshal(data, &aliases)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		s.Log.Printf("Reading the alias table failed: %s.", err)
+	}
+
+	s.aliasesMu.Lock()
+	s.aliases = aliases
+	s.aliasesMu.Unlock()
+}
+
+// writeAliases "atomically" writes the alias table to storageDir.
+func writeAliases(storageDir string, aliases map[string]string) error {
+	data, err := json.MarshalIndent(aliases, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(storageDir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(storageDir, aliasesFile+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(storageDir, aliasesFile))
+}
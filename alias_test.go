@@ -0,0 +1,86 @@
+package vcsstore
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPathRules_Normalize(t *testing.T) {
+	rules := &PathRules{
+		LowercaseHosts:       true,
+		StripGitSuffix:       true,
+		CaseInsensitiveHosts: []string{"github.com"},
+	}
+	tests := map[string]string{
+		"github.com/foo/bar":     "github.com/foo/bar",
+		"github.com/Foo/Bar":     "github.com/foo/bar",
+		"GitHub.com/foo/bar.git": "github.com/foo/bar",
+		"Example.com/Foo/Bar":    "example.com/Foo/Bar",
+		"example.com/foo/.git":   "example.com/foo/.git",
+		"example.com":            "example.com",
+	}
+	for repoPath, want := range tests {
+		if got := rules.Normalize(repoPath); got != want {
+			t.Errorf("%q: got %q, want %q", repoPath, got, want)
+		}
+	}
+
+	if got := (&PathRules{}).Normalize("GitHub.com/Foo/bar.git"); got != "GitHub.com/Foo/bar.git" {
+		t.Errorf("zero PathRules changed path to %q", got)
+	}
+}
+
+func TestService_SetAlias(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+	s.PathRules = PathRules{CaseInsensitiveHosts: []string{"a.com"}}
+
+	if err := s.SetAlias(&RepositoryAlias{From: "a.com/Old", To: "a.com/mid"}); err != nil {
+		t.Fatal(err)
+	}
+	// Aliases of aliases refer to the repository, and existing aliases
+	// of a new alias refer to its repository.
+	if err := s.SetAlias(&RepositoryAlias{From: "a.com/mid", To: "a.com/new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetAlias(&RepositoryAlias{From: "a.com/older", To: "a.com/old"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []*RepositoryAlias{
+		{From: "a.com/mid", To: "a.com/new"},
+		{From: "a.com/old", To: "a.com/new"},
+		{From: "a.com/older", To: "a.com/new"},
+	}
+	if got := s.Aliases(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got aliases %+v, want %+v", got, want)
+	}
+
+	if err := s.SetAlias(&RepositoryAlias{From: "a.com/new", To: "a.com/old"}); err == nil {
+		t.Error("got no error for alias that refers to itself")
+	}
+
+	for repoPath, want := range map[string]string{"a.com/OLD": "a.com/new", "a.com/x": "a.com/x", "b.com/X": "b.com/X"} {
+		if got := s.CanonicalRepoPath(repoPath); got != want {
+			t.Errorf("%q: got canonical repo path %q, want %q", repoPath, got, want)
+		}
+	}
+
+	// The alias table is persisted.
+	s = restartService(s, "", 0)
+	defer s.Close()
+	if got := s.Aliases(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after restart, got aliases %+v, want %+v", got, want)
+	}
+
+	if err := s.SetAlias(&RepositoryAlias{From: "a.com/mid"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetAlias(&RepositoryAlias{From: "a.com/mid"}); !os.IsNotExist(err) {
+		t.Errorf("got error %v removing nonexistent alias, want not exist", err)
+	}
+	if got := s.CanonicalRepoPath("a.com/mid"); got != "a.com/mid" {
+		t.Errorf("got canonical repo path %q for removed alias", got)
+	}
+}
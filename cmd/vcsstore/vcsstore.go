@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"crypto/subtle"
 	"encoding/base64"
+	"encoding/json"
 	_ "expvar"
 	"flag"
 	"fmt"
@@ -85,6 +87,9 @@ var subcommands = []subcommand{
 	{"repo", "display information about a repository", repoCmd},
 	{"clone", "clones a repository on the server", cloneCmd},
 	{"get", "gets a path from the server", getCmd},
+	{"alias", "makes a repository available at another path", aliasCmd},
+	{"migrate-layout", "moves stored repositories to the current on-disk layout", migrateLayoutCmd},
+	{"gc", "runs maintenance (gc, repack, commit-graph) on stored git repositories", gcCmd},
 }
 
 func serveCmd(args []string) {
@@ -95,6 +100,25 @@ func serveCmd(args []string) {
 	tlsKey := fs.String("tls.key", "", "TLS key file (if set, server uses TLS)")
 	basicAuth := fs.String("http.basicauth", "", "if set to 'user:passwd', require HTTP Basic Auth")
 	cache := fs.String("cache", "", "HTTP cache (either 'mem' or 'disk:/path/to/cache/dir')")
+	updateInterval := fs.Duration("update-interval", 0, "if nonzero, update each stored repository from its remote this often")
+	updateMaxBackoff := fs.Duration("update-max-backoff", 0, "maximum delay before retrying a failing background update (default 16x -update-interval)")
+	updateConcurrency := fs.Int("update-concurrency", 4, "maximum number of concurrent background updates")
+	var maxStorage byteSize
+	fs.Var(&maxStorage, "max-storage", "if set, evict least recently used repositories when the storage dir exceeds this size (e.g., 500G)")
+	evictionInterval := fs.Duration("eviction-interval", 0, "how often to check the storage dir size against -max-storage (default 5m)")
+	cloneTimeout := fs.Duration("clone-timeout", 0, "if nonzero, kill clones that take longer than this")
+	updateTimeout := fs.Duration("update-timeout", 0, "if nonzero, kill updates that take longer than this")
+	openRepoIdleTTL := fs.Duration("open-repo-idle-ttl", 0, "how long to keep repositories open after their last use (default 1m; negative to close immediately)")
+	maxOpenRepos := fs.Int("max-open-repos", 0, "if nonzero, maximum number of unused repositories to keep open")
+	maintenanceInterval := fs.Duration("maintenance-interval", 0, "if nonzero, check stored git repositories for needed maintenance (gc, commit-graph) this often")
+	maintenanceMaxLoose := fs.Int("maintenance-max-loose-objects", 0, "number of loose objects at which a repository is gc'd (default 6700)")
+	maintenanceMaxPacks := fs.Int("maintenance-max-packs", 0, "number of packs at which a repository is gc'd (default 50)")
+	orphanedTempDirAge := fs.Duration("orphaned-temp-dir-age", 0, "if nonzero, remove temporary dirs of interrupted clones older than this at startup (must exceed the longest clone if other processes share the storage dir)")
+	quarantineDir := fs.String("quarantine-dir", "", "if set, move orphaned temporary dirs (see -orphaned-temp-dir-age) here instead of removing them")
+	lowercaseHosts := fs.Bool("lowercase-hosts", false, "treat repository paths whose hosts differ only in case as the same repository")
+	stripGitSuffix := fs.Bool("strip-git-suffix", false, "treat repository paths with and without a trailing .git as the same repository")
+	caseInsensitiveHosts := fs.String("case-insensitive-hosts", "", "comma-separated list of hosts (e.g., github.com) whose repository paths are case-insensitive")
+	skipRecovery := fs.Bool("skip-recovery", false, "don't clean up interrupted clones or check repositories at startup")
 	fs.Usage = func() {
 		fmt.Fprintln(os.Stderr, `usage: vcsstore serve [options]
 
@@ -124,8 +148,32 @@ The options are:
 	}
 
 	conf := &vcsstore.Config{
-		StorageDir: *storageDir,
-		Log:        log.New(logw, "vcsstore: ", log.LstdFlags),
+		StorageDir:        *storageDir,
+		Log:               log.New(logw, "vcsstore: ", log.LstdFlags),
+		CloneTimeout:      *cloneTimeout,
+		UpdateTimeout:     *updateTimeout,
+		UpdateInterval:    *updateInterval,
+		UpdateMaxBackoff:  *updateMaxBackoff,
+		UpdateConcurrency: *updateConcurrency,
+		MaxStorageBytes:   int64(maxStorage),
+		EvictionInterval:  *evictionInterval,
+		OpenRepoIdleTTL:   *openRepoIdleTTL,
+		MaxOpenRepos:      *maxOpenRepos,
+
+		MaintenanceInterval:        *maintenanceInterval,
+		MaintenanceMaxLooseObjects: *maintenanceMaxLoose,
+		MaintenanceMaxPacks:        *maintenanceMaxPacks,
+
+		OrphanedTempDirAge:  *orphanedTempDirAge,
+		QuarantineDir:       *quarantineDir,
+		SkipStartupRecovery: *skipRecovery,
+		PathRules: vcsstore.PathRules{
+			LowercaseHosts: *lowercaseHosts,
+			StripGitSuffix: *stripGitSuffix,
+		},
+	}
+	if *caseInsensitiveHosts != "" {
+		conf.PathRules.CaseInsensitiveHosts = strings.Split(*caseInsensitiveHosts, ",")
 	}
 	if *debug {
 		conf.DebugLog = log.New(logw, "vcsstore DEBUG: ", log.LstdFlags)
@@ -162,6 +210,37 @@ The options are:
 	}
 }
 
+// byteSize is a flag.Value for a number of bytes, optionally with a
+// K, M, G, or T (binary) suffix.
+type byteSize int64
+
+func (b *byteSize) String() string { return strconv.FormatInt(int64(*b), 10) }
+
+func (b *byteSize) Set(s string) error {
+	mult := int64(1)
+	if n := len(s); n > 0 {
+		switch strings.ToUpper(s[n-1:]) {
+		case "K":
+			mult = 1 << 10
+		case "M":
+			mult = 1 << 20
+		case "G":
+			mult = 1 << 30
+		case "T":
+			mult = 1 << 40
+		}
+		if mult != 1 {
+			s = s[:n-1]
+		}
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(v * mult)
+	return nil
+}
+
 func cacheHandler(cacheOpt string, h http.Handler) http.Handler {
 	if cacheOpt == "" {
 		return h
@@ -227,7 +306,12 @@ The options are:
 
 	repoPath := fs.Arg(0)
 
-	fmt.Println("RepositoryPath:      ", filepath.Join(*storageDir, vcsstore.EncodeRepositoryPath(repoPath)))
+	cloneDir, err := (&vcsstore.Config{StorageDir: *storageDir}).CloneDir(repoPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("RepositoryPath:      ", cloneDir)
 	fmt.Println("URL:                 ", vcsclient.NewRouter(nil).URLToRepo(repoPath))
 }
 
@@ -235,6 +319,9 @@ func cloneCmd(args []string) {
 	fs := flag.NewFlagSet("clone", flag.ExitOnError)
 	urlStr := fs.String("url", "http://localhost:"+defaultPort, "base URL to a running vcsstore API server")
 	sshKeyFile := fs.String("i", "", "ssh private key file for clone remote")
+	timeout := fs.Duration("timeout", 0, "if nonzero, override the server's clone or update timeout")
+	freshWithin := fs.Duration("fresh-within", 0, "if nonzero, don't update the repository if it was updated this recently")
+	forkParent := fs.String("fork-parent", "", "repo-id of a git repository on the server that this one is a fork of (to share its objects)")
 	fs.Usage = func() {
 		fmt.Fprintln(os.Stderr, `usage: vcsstore clone [options] repo-id vcs-type clone-url
 
@@ -279,9 +366,15 @@ The options are:
 		opt.SSH = &vcs.SSHConfig{PrivateKey: key}
 	}
 
+	var result *vcs.UpdateResult
 	if repo, ok := repo.(vcsclient.RepositoryCloneUpdater); ok {
-		err := repo.CloneOrUpdate(&vcsclient.CloneInfo{
-			VCS: vcsType, CloneURL: cloneURL.String(), RemoteOpts: opt,
+		result, err = repo.CloneOrUpdate(&vcsclient.CloneInfo{
+			VCS:                vcsType,
+			CloneURL:           cloneURL.String(),
+			RemoteOpts:         opt,
+			Timeout:            *timeout,
+			FreshWithinSeconds: int(freshWithin.Seconds()),
+			ForkParent:         *forkParent,
 		})
 		if err != nil {
 			log.Fatal("Clone: ", err)
@@ -291,6 +384,18 @@ The options are:
 	}
 
 	fmt.Printf("%-5s cloned OK\n", repoPath)
+	if result != nil {
+		for _, c := range result.Changes {
+			fmt.Printf("  %-14s %s\n", updateOpNames[c.Op], c.Branch)
+		}
+	}
+}
+
+var updateOpNames = map[vcs.Operation]string{
+	vcs.NewOp:          "new",
+	vcs.FFUpdatedOp:    "fast-forwarded",
+	vcs.ForceUpdatedOp: "force-updated",
+	vcs.DeletedOp:      "deleted",
 }
 
 func getCmd(args []string) {
@@ -332,6 +437,167 @@ The options are:
 	normalGet(*method, nil, url)
 }
 
+func aliasCmd(args []string) {
+	fs := flag.NewFlagSet("alias", flag.ExitOnError)
+	urlStr := fs.String("url", "http://localhost:"+defaultPort, "base URL to a running vcsstore API server")
+	remove := fs.Bool("d", false, "remove the alias instead of adding it")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: vcsstore alias [options] alias-repo-id [repo-id]
+
+Makes the repository repo-id available on the server at alias-repo-id
+(e.g., its path before it was renamed upstream). Requests for the alias
+are redirected to repo-id. With no arguments, lists the aliases.
+
+The options are:
+`)
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	fs.Parse(args)
+
+	baseURL, err := url.Parse(*urlStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	router := vcsclient.NewRouter(nil)
+
+	var alias vcsstore.RepositoryAlias
+	switch {
+	case fs.NArg() == 0 && !*remove:
+		u := router.URLTo(vcsclient.RouteAdminAliases)
+		u.Path = strings.TrimPrefix(u.Path, "/")
+		normalGet("GET", nil, baseURL.ResolveReference(u))
+		return
+	case fs.NArg() == 1 && *remove:
+		alias.From = fs.Arg(0)
+	case fs.NArg() == 2 && !*remove:
+		alias.From, alias.To = fs.Arg(0), fs.Arg(1)
+	default:
+		fs.Usage()
+	}
+
+	body, err := json.Marshal(alias)
+	if err != nil {
+		log.Fatal(err)
+	}
+	u := router.URLTo(vcsclient.RouteAdminSetAlias)
+	u.Path = strings.TrimPrefix(u.Path, "/")
+	resp, err := http.Post(baseURL.ResolveReference(u).String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if err := vcsclient.CheckResponse(resp, false); err != nil {
+		log.Fatal("Alias: ", err)
+	}
+}
+
+func migrateLayoutCmd(args []string) {
+	fs := flag.NewFlagSet("migrate-layout", flag.ExitOnError)
+	urlStr := fs.String("url", "http://localhost:"+defaultPort, "base URL to a running vcsstore API server")
+	fanOut := fs.Int("fan-out", vcsstore.DefaultLayout.FanOut, "number of levels of hash-prefix dirs")
+	offline := fs.Bool("offline", false, "migrate the storage dir (-s) directly instead of on the server (only if no server is using it)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: vcsstore migrate-layout [options]
+
+Moves the repositories in the server's storage dir from the legacy
+layout (nested, unescaped repository paths) to the current layout
+(escaped repository paths beneath hash-prefix dirs). The server keeps
+serving all repositories while they are moved. If the migration is
+interrupted, running the command again resumes it.
+
+The options are:
+`)
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+	}
+
+	to := vcsstore.Layout{Version: vcsstore.DefaultLayout.Version, FanOut: *fanOut}
+
+	if *offline {
+		type layoutMigrater interface {
+			MigrateLayout(to vcsstore.Layout) (*vcsstore.LayoutMigration, error)
+		}
+		svc := vcsstore.NewService(&vcsstore.Config{
+			StorageDir:          *storageDir,
+			Log:                 log.New(os.Stderr, "vcsstore: ", log.LstdFlags),
+			SkipStartupRecovery: true,
+		})
+		m, err := svc.(layoutMigrater).MigrateLayout(to)
+		if err != nil {
+			log.Fatal("Migrate layout: ", err)
+		}
+		fmt.Printf("Migrated %s from layout %s to %s (moved %d repositories).\n", *storageDir, m.From, m.To, m.Moved)
+		return
+	}
+
+	baseURL, err := url.Parse(*urlStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	u := vcsclient.NewRouter(nil).URLTo(vcsclient.RouteAdminMigrateLayout)
+	u.Path = strings.TrimPrefix(u.Path, "/")
+	u = baseURL.ResolveReference(u)
+	u.RawQuery = url.Values{"Version": {strconv.Itoa(to.Version)}, "FanOut": {strconv.Itoa(to.FanOut)}}.Encode()
+
+	normalGet("POST", nil, u)
+}
+
+func gcCmd(args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	urlStr := fs.String("url", "http://localhost:"+defaultPort, "base URL to a running vcsstore API server")
+	force := fs.Bool("force", false, "run all maintenance tasks, even on repositories that don't need them")
+	status := fs.Bool("status", false, "show the object counts of the repositories instead of running maintenance")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, `usage: vcsstore gc [options] [repo-id]
+
+Runs maintenance tasks (packing refs, repacking, pruning, and writing the
+commit-graph) on a git repository on the server, or on all of them (and
+on the object pools of fork networks) if no repo-id is given. Unless
+-force is set, only repositories with too many loose objects or packs
+are gc'd, and only out-of-date commit-graphs are written.
+
+The options are:
+`)
+		fs.PrintDefaults()
+		os.Exit(1)
+	}
+	fs.Parse(args)
+
+	if fs.NArg() > 1 || (*status && fs.NArg() != 0) {
+		fs.Usage()
+	}
+
+	baseURL, err := url.Parse(*urlStr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	route, method := vcsclient.RouteAdminGC, "POST"
+	if *status {
+		route, method = vcsclient.RouteAdminGCStatus, "GET"
+	}
+	u := vcsclient.NewRouter(nil).URLTo(route)
+	u.Path = strings.TrimPrefix(u.Path, "/")
+	u = baseURL.ResolveReference(u)
+	if !*status {
+		q := url.Values{}
+		if fs.NArg() == 1 {
+			q.Set("RepoPath", fs.Arg(0))
+		}
+		if *force {
+			q.Set("Force", "true")
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	normalGet(method, nil, u)
+}
+
 func normalGet(method string, c *http.Client, url *url.URL) {
 	if c == nil {
 		c = http.DefaultClient
@@ -0,0 +1,132 @@
+package vcsstore
+
+import (
+	"expvar"
+	"sort"
+	"time"
+)
+
+var (
+	evictions     = expvar.NewInt("vcsstore.evictions")
+	evictedBytes  = expvar.NewInt("vcsstore.evicted-bytes")
+	evictionFails = expvar.NewInt("vcsstore.eviction-failures")
+)
+
+// defaultEvictionInterval is used if Config.EvictionInterval is
+// unset.
+const defaultEvictionInterval = 5 * time.Minute
+
+// runEvictor evicts repos whenever StorageDir exceeds
+// MaxStorageBytes, checking periodically and after each clone.
+func (s *service) runEvictor() {
+	interval := s.EvictionInterval
+	if interval == 0 {
+		interval = defaultEvictionInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.evictNeeded:
+		case <-s.closed:
+			return
+		}
+		if err := s.evictLRU(); err != nil {
+			s.Log.Printf("Eviction failed: %s.", err)
+		}
+	}
+}
+
+// scheduleEviction tells the evictor to check StorageDir's size soon.
+// It does nothing if there is no MaxStorageBytes limit.
+func (s *service) scheduleEviction() {
+	if s.evictNeeded == nil {
+		return
+	}
+	select {
+	case s.evictNeeded <- struct{}{}:
+	default:
+		// A check is already pending.
+	}
+}
+
+type evictionCandidate struct {
+	repoPath   string
+	size       int64
+	lastAccess time.Time
+}
+
+// evictLRU removes the least recently used repos that have no current
+// users until the total size of the repos in StorageDir is no more
+// than MaxStorageBytes. Repos that haven't been accessed since the
+// service started are ordered by the last access time persisted in
+// their metadata, or else by the last time they were fetched.
+func (s *service) evictLRU() error {
+	var (
+		candidates []*evictionCandidate
+		total      int64
+	)
+	err := walkRepositories(s.StorageDir, "", func(repoPath, cloneDir, vcsType string) error {
+		size, err := dirSize(cloneDir)
+		if err != nil {
+			return err
+		}
+		total += size
+
+		s.repoMuMu.RLock()
+		lastAccess, accessed := s.lastAccess[repoKey{cloneDir}]
+		s.repoMuMu.RUnlock()
+		if !accessed {
+			if md, err := readMetadata(cloneDir); err == nil && !md.LastAccessed.IsZero() {
+				lastAccess = md.LastAccessed
+			} else {
+				lastAccess = lastFetchTime(cloneDir, vcsType)
+			}
+		}
+
+		candidates = append(candidates, &evictionCandidate{repoPath: repoPath, size: size, lastAccess: lastAccess})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if total <= s.MaxStorageBytes {
+		return nil
+	}
+
+	s.Log.Printf("Repositories in %s use %d bytes, which exceeds the limit of %d bytes; evicting least recently used repositories.", s.StorageDir, total, s.MaxStorageBytes)
+	sort.Sort(evictionCandidatesByLastAccess(candidates))
+	for _, c := range candidates {
+		if total <= s.MaxStorageBytes {
+			break
+		}
+		removed, err := s.remove(c.repoPath, false)
+		if err != nil {
+			evictionFails.Add(1)
+			s.Log.Printf("Evicting %s failed: %s.", c.repoPath, err)
+			continue
+		}
+		if !removed {
+			s.debugLogf("evictLRU: not evicting %s because it is in use", c.repoPath)
+			continue
+		}
+		total -= c.size
+		evictions.Add(1)
+		evictedBytes.Add(c.size)
+		s.Log.Printf("Evicted %s (%d bytes, last accessed %s).", c.repoPath, c.size, c.lastAccess)
+	}
+	if total > s.MaxStorageBytes {
+		s.Log.Printf("Repositories in %s still use %d bytes after eviction (limit %d bytes); remaining repositories are in use.", s.StorageDir, total, s.MaxStorageBytes)
+	}
+	return nil
+}
+
+type evictionCandidatesByLastAccess []*evictionCandidate
+
+func (v evictionCandidatesByLastAccess) Len() int      { return len(v) }
+func (v evictionCandidatesByLastAccess) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v evictionCandidatesByLastAccess) Less(i, j int) bool {
+	return v[i].lastAccess.Before(v[j].lastAccess)
+}
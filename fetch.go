@@ -0,0 +1,124 @@
+package vcsstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+var (
+	coalescedFetches = expvar.NewInt("vcsstore.coalesced-fetches")
+	skippedFetches   = expvar.NewInt("vcsstore.skipped-fresh-fetches")
+)
+
+// fetchCall is an in-progress fetch of a repo, whose result is shared
+// by all callers that request it while it is running.
+type fetchCall struct {
+	wg     sync.WaitGroup
+	result *vcs.UpdateResult
+	err    error
+}
+
+// fetchKey identifies the fetches that can be shared: those of the
+// same repo with the same remote options and timeout. opts is a hash
+// of the options (so that credentials aren't kept in memory longer
+// than the fetch).
+type fetchKey struct {
+	repoKey
+	opts string
+}
+
+func newFetchKey(key repoKey, cloneInfo *vcsclient.CloneInfo) (fetchKey, error) {
+	data, err := json.Marshal(struct {
+		vcs.RemoteOpts
+		Timeout time.Duration
+	}{cloneInfo.RemoteOpts, cloneInfo.Timeout})
+	if err != nil {
+		return fetchKey{}, err
+	}
+	sum := sha256.Sum256(data)
+	return fetchKey{key, hex.EncodeToString(sum[:])}, nil
+}
+
+// errFetchAborted is returned to the callers that share a fetch that
+// panicked.
+var errFetchAborted = errors.New("fetch aborted")
+
+// sharedFetch is like fetch, except that if the repo is already being
+// fetched with the same remote options and timeout, it waits for that
+// fetch and returns its result instead of starting another one. (The
+// shared fetch only reports progress to the progress func of the
+// caller that started it.) If cloneInfo.FreshWithinSeconds is set and
+// the repo was successfully cloned or updated within that time, it
+// returns an empty result without fetching.
+func (s *service) sharedFetch(repoPath string, cloneInfo *vcsclient.CloneInfo, progress func(cloneProgress)) (*vcs.UpdateResult, error) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newFetchKey(repoKey{cloneDir}, cloneInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	s.fetchesMu.Lock()
+	if fresh := time.Duration(cloneInfo.FreshWithinSeconds) * time.Second; fresh > 0 {
+		if t, present := s.lastFetched[key.repoKey]; present && time.Since(t) < fresh {
+			s.fetchesMu.Unlock()
+			skippedFetches.Add(1)
+			s.debugLogf("Update(%s): skipping fetch because last fetch was at %s", repoPath, t)
+			return &vcs.UpdateResult{}, nil
+		}
+	}
+	if c, present := s.fetches[key]; present {
+		s.fetchesMu.Unlock()
+		coalescedFetches.Add(1)
+		c.wg.Wait()
+		return c.result, c.err
+	}
+	c := &fetchCall{err: errFetchAborted}
+	c.wg.Add(1)
+	s.fetches[key] = c
+	s.fetchesMu.Unlock()
+
+	// Release the callers waiting for the fetch even if it panics.
+	defer func() {
+		s.fetchesMu.Lock()
+		delete(s.fetches, key)
+		if c.err == nil {
+			s.lastFetched[key.repoKey] = time.Now()
+		}
+		s.fetchesMu.Unlock()
+		c.wg.Done()
+	}()
+
+	result, err := s.fetch(repoPath, cloneInfo, progress)
+	if err == nil {
+		s.refreshPoolMember(repoPath)
+	}
+	c.result, c.err = result, err
+	return result, err
+}
+
+// recordFetch records that the repo was just successfully cloned or
+// updated.
+func (s *service) recordFetch(key repoKey) {
+	s.fetchesMu.Lock()
+	defer s.fetchesMu.Unlock()
+	s.lastFetched[key] = time.Now()
+}
+
+// forgetFetch forgets when the repo was last fetched (because it was
+// removed).
+func (s *service) forgetFetch(key repoKey) {
+	s.fetchesMu.Lock()
+	defer s.fetchesMu.Unlock()
+	delete(s.lastFetched, key)
+}
@@ -0,0 +1,102 @@
+package vcsstore
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// waitFor polls cond until it returns true.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestService_Update_coalesced(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+	remoteDir := initRemoteGitRepo(t)
+	defer os.RemoveAll(remoteDir)
+
+	// Hold a read handle so that the first update waits for the write
+	// lock while the second one is requested.
+	h, err := s.Clone("a.com/x", &vcsclient.CloneInfo{VCS: "git", CloneURL: remoteDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := make(chan *vcs.UpdateResult, 3)
+	update := func(cloneInfo *vcsclient.CloneInfo) {
+		result, err := s.Update("a.com/x", cloneInfo)
+		if err != nil {
+			t.Error(err)
+		}
+		results <- result
+	}
+	numFetches := func() int {
+		s.fetchesMu.Lock()
+		defer s.fetchesMu.Unlock()
+		return len(s.fetches)
+	}
+
+	go update(&vcsclient.CloneInfo{})
+	waitFor(t, "first update to start", func() bool { return numFetches() == 1 })
+	before := coalescedFetches.Value()
+	go update(&vcsclient.CloneInfo{})
+	waitFor(t, "second update to join the first", func() bool {
+		return coalescedFetches.Value() > before
+	})
+
+	// An update with different remote options doesn't share the fetch.
+	go update(&vcsclient.CloneInfo{RemoteOpts: vcs.RemoteOpts{HTTPS: &vcs.HTTPSConfig{Pass: "p"}}})
+	waitFor(t, "third update to start its own fetch", func() bool { return numFetches() == 2 })
+	if got := coalescedFetches.Value() - before; got != 1 {
+		t.Errorf("got %d coalesced fetches, want 1", got)
+	}
+
+	h.Release()
+	r1, r2, r3 := <-results, <-results, <-results
+	if r1 != r2 && r1 != r3 && r2 != r3 {
+		t.Errorf("got results %+v, %+v, and %+v, want two of them to be the same shared result", r1, r2, r3)
+	}
+}
+
+func TestService_Update_freshWithin(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+	remoteDir := initRemoteGitRepo(t)
+	defer os.RemoveAll(remoteDir)
+
+	h, err := s.Clone("a.com/x", &vcsclient.CloneInfo{VCS: "git", CloneURL: remoteDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+
+	// The repo was just cloned, so it's fresh.
+	before := skippedFetches.Value()
+	if _, err := s.Update("a.com/x", &vcsclient.CloneInfo{FreshWithinSeconds: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if got := skippedFetches.Value() - before; got != 1 {
+		t.Errorf("got %d skipped fetches, want 1", got)
+	}
+
+	// Without FreshWithinSeconds, it's always fetched.
+	if _, err := s.Update("a.com/x", &vcsclient.CloneInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := skippedFetches.Value() - before; got != 1 {
+		t.Errorf("got %d skipped fetches, want 1", got)
+	}
+}
@@ -0,0 +1,181 @@
+package vcsstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// errStopListing is returned by the callback of listGitFiles to stop
+// listing files.
+var errStopListing = errors.New("stop listing files")
+
+// ListFiles calls fn for each file in the tree at the commit in the
+// repository at repoPath (in path order) that matches opt. Files are
+// listed as they are read from the VCS, so fn can stream them. It
+// holds the repository's read lock while listing (and while fn runs),
+// so fn must not block indefinitely.
+//
+// If the commit doesn't exist, vcs.ErrCommitNotFound is returned
+// before fn is called. Only git repositories can be listed; for
+// others, a *vcsclient.UnsupportedError is returned.
+func (s *service) ListFiles(repoPath string, at vcs.CommitID, opt vcsclient.FileListOptions, fn func(*vcsclient.FileListEntry) error) error {
+	if _, err := path.Match(opt.Glob, ""); err != nil {
+		return fmt.Errorf("bad glob %q: %s", opt.Glob, err)
+	}
+	if at == "" || strings.HasPrefix(string(at), "-") {
+		return vcs.ErrCommitNotFound
+	}
+
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return err
+	}
+
+	key := repoKey{cloneDir}
+	persist := s.recordAccess(key)
+	mu := s.Mutex(key)
+	mu.RLock()
+	defer mu.RUnlock()
+	if persist {
+		s.recordAccessMetadata(cloneDir)
+	}
+
+	vcsType, err := vcsTypeFromDir(cloneDir)
+	if err != nil {
+		return err
+	}
+	if vcsType != "git" {
+		return &vcsclient.UnsupportedError{Capability: vcsclient.CapFileLister, ImplementationType: vcsType}
+	}
+
+	cmd := exec.Command("git", "cat-file", "-e", string(at)+"^{commit}")
+	cmd.Dir = cloneDir
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return vcs.ErrCommitNotFound
+		}
+		return err
+	}
+
+	var skipped, listed uint
+	return listGitFiles(cloneDir, at, opt.Pathspecs, func(e *vcsclient.FileListEntry) error {
+		if !matchFileGlob(opt.Glob, e.Path) {
+			return nil
+		}
+		if skipped < opt.Skip {
+			skipped++
+			return nil
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+		if listed++; opt.N != 0 && listed == opt.N {
+			return errStopListing
+		}
+		return nil
+	})
+}
+
+// matchFileGlob returns whether the file at p matches glob (see
+// vcsclient.FileListOptions.Glob).
+func matchFileGlob(glob, p string) bool {
+	if glob == "" {
+		return true
+	}
+	if !strings.Contains(glob, "/") {
+		p = path.Base(p)
+	}
+	ok, _ := path.Match(glob, p)
+	return ok
+}
+
+// listGitFiles calls fn for each file in the tree at the commit in the
+// git repository in dir (limited to pathspecs, if any), as git
+// ls-tree lists them. If fn returns errStopListing, listing stops and
+// nil is returned.
+func listGitFiles(dir string, at vcs.CommitID, pathspecs []string, fn func(*vcsclient.FileListEntry) error) error {
+	args := append([]string{"ls-tree", "-r", "-l", "-z", "--full-tree", string(at), "--"}, pathspecs...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	stop := func(err error) error {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if err == errStopListing {
+			return nil
+		}
+		return err
+	}
+
+	r := bufio.NewReader(stdout)
+	for {
+		rec, err := r.ReadString(0)
+		if err == io.EOF && rec == "" {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return stop(err)
+		}
+		e, err := parseGitLsTreeEntry(strings.TrimSuffix(rec, "\x00"))
+		if err != nil {
+			return stop(err)
+		}
+		if err := fn(e); err != nil {
+			return stop(err)
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git ls-tree failed: %s", err)
+	}
+	return nil
+}
+
+// parseGitLsTreeEntry parses a record output by git ls-tree -l (e.g.,
+// "100644 blob <object>      123\tpath/to/file").
+func parseGitLsTreeEntry(rec string) (*vcsclient.FileListEntry, error) {
+	tab := strings.IndexByte(rec, '\t')
+	if tab == -1 {
+		return nil, fmt.Errorf("git ls-tree: bad entry %q", rec)
+	}
+	fields := strings.Fields(rec[:tab])
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("git ls-tree: bad entry %q", rec)
+	}
+
+	e := &vcsclient.FileListEntry{Path: rec[tab+1:]}
+	switch fields[0] {
+	case "100755":
+		e.Mode = 0755
+	case "120000":
+		e.Mode = os.ModeSymlink
+	case "160000":
+		e.Mode = vcs.ModeSubmodule
+	default:
+		e.Mode = 0644
+	}
+	if fields[3] != "-" {
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("git ls-tree: bad size in entry %q", rec)
+		}
+		e.Size = size
+	}
+	return e, nil
+}
@@ -0,0 +1,106 @@
+package vcsstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func TestService_ListFiles_hg(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	// Only the VCS type is needed, and hg isn't, since listing fails
+	// before hg would be run.
+	if err := os.MkdirAll(filepath.Join(mustCloneDir(t, s, "a.com/h"), ".hg"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	err := s.ListFiles("a.com/h", vcs.CommitID(strings.Repeat("a", 40)), vcsclient.FileListOptions{}, func(*vcsclient.FileListEntry) error {
+		t.Error("listed a file")
+		return nil
+	})
+	if _, ok := err.(*vcsclient.UnsupportedError); !ok {
+		t.Errorf("got error %v, want *vcsclient.UnsupportedError", err)
+	}
+}
+
+func TestService_ListFiles(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	remote := initRemoteGitRepo(t)
+	defer os.RemoveAll(remote)
+	if err := os.Mkdir(filepath.Join(remote, "d"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, mode := range map[string]os.FileMode{"a.go": 0644, "d/b.go": 0644, "d/c.sh": 0755} {
+		if err := ioutil.WriteFile(filepath.Join(remote, name), []byte("xyz"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a.go", filepath.Join(remote, "l")); err != nil {
+		t.Fatal(err)
+	}
+	gitOutput(t, remote, "add", ".")
+	gitOutput(t, remote, "-c", "user.name=a", "-c", "user.email=a@a.com", "commit", "-m", "y")
+	commitID := vcs.CommitID(gitOutput(t, remote, "rev-parse", "HEAD"))
+
+	h, err := s.Clone("a.com/r", &vcsclient.CloneInfo{VCS: "git", CloneURL: remote})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+
+	list := func(opt vcsclient.FileListOptions) []*vcsclient.FileListEntry {
+		files := []*vcsclient.FileListEntry{}
+		if err := s.ListFiles("a.com/r", commitID, opt, func(e *vcsclient.FileListEntry) error {
+			files = append(files, e)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	want := []*vcsclient.FileListEntry{
+		{Path: "a.go", Mode: 0644, Size: 3},
+		{Path: "d/b.go", Mode: 0644, Size: 3},
+		{Path: "d/c.sh", Mode: 0755, Size: 3},
+		{Path: "l", Mode: os.ModeSymlink, Size: 4},
+	}
+	if files := list(vcsclient.FileListOptions{}); !reflect.DeepEqual(files, want) {
+		t.Errorf("got files %s, want %s", asJSON(files), asJSON(want))
+	}
+
+	tests := map[string]struct {
+		opt  vcsclient.FileListOptions
+		want []*vcsclient.FileListEntry
+	}{
+		"glob":          {vcsclient.FileListOptions{Glob: "*.go"}, want[:2]},
+		"glob with dir": {vcsclient.FileListOptions{Glob: "d/*"}, want[1:3]},
+		"pathspecs":     {vcsclient.FileListOptions{Pathspecs: []string{"d", "l"}}, want[1:]},
+		"paginated":     {vcsclient.FileListOptions{Skip: 1, N: 2}, want[1:3]},
+		"past the end":  {vcsclient.FileListOptions{Skip: 4}, want[:0]},
+	}
+	for label, test := range tests {
+		if files := list(test.opt); !reflect.DeepEqual(files, test.want) {
+			t.Errorf("%s: got files %s, want %s", label, asJSON(files), asJSON(test.want))
+		}
+	}
+
+	err = s.ListFiles("a.com/r", "0123456789012345678901234567890123456789", vcsclient.FileListOptions{}, func(*vcsclient.FileListEntry) error {
+		t.Error("called fn for nonexistent commit")
+		return nil
+	})
+	if err != vcs.ErrCommitNotFound {
+		t.Errorf("got err %v, want vcs.ErrCommitNotFound", err)
+	}
+}
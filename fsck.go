@@ -0,0 +1,284 @@
+package vcsstore
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// Fsck checks the integrity of the repository at repoPath, with git
+// fsck (checking connectivity only) or hg verify, and returns the
+// problems it found. It holds the repository's read lock while
+// checking.
+//
+// If opt.MarkForReclone is set and problems are found, the repository
+// is marked (in its metadata) to be cloned again by its next update.
+func (s *service) Fsck(repoPath string, opt vcsclient.FsckOptions) (*vcsclient.FsckResult, error) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	mu := s.Mutex(repoKey{cloneDir})
+	mu.RLock()
+	vcsType, err := vcsTypeFromDir(cloneDir)
+	if err != nil {
+		mu.RUnlock()
+		return nil, err
+	}
+	start := time.Now()
+	findings, err := fsckRepo(cloneDir, vcsType)
+	mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &vcsclient.FsckResult{
+		OK:       len(findings) == 0,
+		Findings: findings,
+		Duration: time.Since(start),
+	}
+	if result.OK {
+		return result, nil
+	}
+	s.Log.Printf("Integrity check of %s found %d problems.", repoPath, len(findings))
+
+	if opt.MarkForReclone {
+		err := s.updateMetadata(cloneDir, func(md *vcsclient.RepositoryMetadata) {
+			md.NeedsReclone = true
+		})
+		if err != nil {
+			return nil, err
+		}
+		result.MarkedForReclone = true
+		s.Log.Printf("Marked %s to be re-cloned by its next update.", repoPath)
+	}
+	return result, nil
+}
+
+// fsckRepo checks the integrity of the repository in cloneDir. Problems
+// are returned as findings; err is only non-nil if the check couldn't
+// be run.
+func fsckRepo(cloneDir, vcsType string) (findings []*vcsclient.FsckFinding, err error) {
+	var cmd *exec.Cmd
+	var parse func(out []byte) []*vcsclient.FsckFinding
+	switch vcsType {
+	case "git":
+		cmd = exec.Command("git", "fsck", "--connectivity-only", "--no-dangling", "--no-progress")
+		parse = parseGitFsck
+	case "hg":
+		cmd = exec.Command("hg", "verify")
+		parse = parseHgVerify
+	default:
+		return nil, fmt.Errorf("integrity checks not yet implemented for VCS %q", vcsType)
+	}
+	cmd.Dir = cloneDir
+	out, err := cmd.CombinedOutput()
+	if _, exited := err.(*exec.ExitError); err != nil && !exited {
+		return nil, err
+	}
+
+	findings = parse(out)
+	if err != nil && len(findings) == 0 {
+		// The VCS failed without saying why in a way we understand.
+		findings = append(findings, &vcsclient.FsckFinding{Kind: "error", Message: fmt.Sprintf("%s: %s", err, bytes.TrimSpace(out))})
+	}
+	if findings == nil {
+		findings = []*vcsclient.FsckFinding{}
+	}
+	return findings, nil
+}
+
+var (
+	gitFsckMissing    = regexp.MustCompile(`^missing (\w+) ([0-9a-f]{40,64})`)
+	gitFsckBrokenFrom = regexp.MustCompile(`^broken link from\s+(\w+) ([0-9a-f]{40,64})`)
+	gitFsckBrokenTo   = regexp.MustCompile(`^\s+to\s+(\w+) ([0-9a-f]{40,64})`)
+)
+
+// parseGitFsck parses the output of git fsck.
+func parseGitFsck(out []byte) []*vcsclient.FsckFinding {
+	var findings []*vcsclient.FsckFinding
+	var brokenFrom string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if brokenFrom != "" {
+			if m := gitFsckBrokenTo.FindStringSubmatch(line); m != nil {
+				findings = append(findings, &vcsclient.FsckFinding{
+					Kind:       "broken-link",
+					ObjectType: m[1],
+					Object:     m[2],
+					Message:    brokenFrom + " " + strings.Join(strings.Fields(line), " "),
+				})
+				brokenFrom = ""
+				continue
+			}
+			findings = append(findings, &vcsclient.FsckFinding{Kind: "broken-link", Message: brokenFrom})
+			brokenFrom = ""
+		}
+
+		switch {
+		case strings.TrimSpace(line) == "", strings.HasPrefix(line, "notice: "), strings.HasPrefix(line, "Checking "):
+		case gitFsckBrokenFrom.MatchString(line):
+			brokenFrom = strings.Join(strings.Fields(line), " ")
+		default:
+			if m := gitFsckMissing.FindStringSubmatch(line); m != nil {
+				findings = append(findings, &vcsclient.FsckFinding{Kind: "missing", ObjectType: m[1], Object: m[2], Message: line})
+				continue
+			}
+			f := &vcsclient.FsckFinding{Kind: "error", Message: line}
+			for _, prefix := range []string{"error: ", "fatal: "} {
+				f.Message = strings.TrimPrefix(f.Message, prefix)
+			}
+			if strings.HasPrefix(line, "warning: ") {
+				f.Kind, f.Message = "warning", strings.TrimPrefix(line, "warning: ")
+			}
+			findings = append(findings, f)
+		}
+	}
+	if brokenFrom != "" {
+		findings = append(findings, &vcsclient.FsckFinding{Kind: "broken-link", Message: brokenFrom})
+	}
+	return findings
+}
+
+// parseHgVerify parses the output of hg verify.
+func parseHgVerify(out []byte) []*vcsclient.FsckFinding {
+	var findings []*vcsclient.FsckFinding
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "",
+			strings.HasPrefix(line, "checking "),
+			strings.HasPrefix(line, "crosschecking "),
+			strings.HasPrefix(line, "checked "),
+			strings.HasPrefix(line, "repository uses revlog format"),
+			strings.HasPrefix(line, "("),
+			strings.HasSuffix(line, " integrity errors encountered!"),
+			strings.HasSuffix(line, " warnings encountered!"):
+			// Progress and summary lines.
+		case strings.HasPrefix(line, "abort: "):
+			findings = append(findings, &vcsclient.FsckFinding{Kind: "error", Message: strings.TrimPrefix(line, "abort: ")})
+		case strings.HasPrefix(line, "warning: "):
+			findings = append(findings, &vcsclient.FsckFinding{Kind: "warning", Message: strings.TrimPrefix(line, "warning: ")})
+		default:
+			findings = append(findings, &vcsclient.FsckFinding{Kind: "damaged", Message: line})
+		}
+	}
+	return findings
+}
+
+// reclone replaces the repository in cloneDir, which Fsck marked to be
+// cloned again, with a new clone from cloneInfo.CloneURL (or from its
+// recorded clone URL, if cloneInfo has none). If it borrows objects
+// from an object pool, the new clone does too. The caller must hold
+// the repository's write lock.
+//
+// The new clone's refs are fetched into the pool by the caller (see
+// sharedFetch) after it releases the write lock, since poolMu must not
+// be acquired while holding it (see addPoolMember). Meanwhile, the
+// objects that the new clone borrows stay reachable in the pool from
+// the old clone's refs there.
+func (s *service) reclone(repoPath, cloneDir string, md *vcsclient.RepositoryMetadata, cloneInfo *vcsclient.CloneInfo, progress func(cloneProgress)) (result *vcs.UpdateResult, err error) {
+	ci := *cloneInfo
+	if ci.VCS == "" {
+		ci.VCS = md.VCS
+	}
+	if ci.CloneURL == "" {
+		ci.CloneURL = md.CloneURL
+	}
+	if ci.CloneURL == "" {
+		return nil, fmt.Errorf("can't re-clone %s: no clone URL recorded", repoPath)
+	}
+
+	start := time.Now()
+	s.Log.Printf("Re-cloning %s, which was marked for re-cloning by an integrity check...", repoPath)
+	s.progress.publishPhase(repoPath, "recloning")
+	defer func() { s.progress.publishDone(repoPath, result, err) }()
+
+	var poolDir string
+	if ci.VCS == "git" {
+		if poolDir, err = s.repoPool(cloneDir); err != nil {
+			return nil, err
+		}
+	}
+
+	cloneTmpDir, err := ioutil.TempDir(filepath.Dir(cloneDir), "_tmp_"+filepath.Base(cloneDir)+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(cloneTmpDir)
+
+	timeout := ci.Timeout
+	if timeout == 0 {
+		timeout = s.CloneTimeout
+	}
+	cancel, stopTimer := timeoutCanceler(timeout)
+	defer stopTimer()
+	if err := cloneRepo(&ci, cloneTmpDir, poolDir, s.progress.reporter(repoPath, progress), cancel); err != nil {
+		if err == errCanceled {
+			err = &TimeoutError{Op: "clone", RepoPath: repoPath, Timeout: timeout}
+		}
+		return nil, err
+	}
+	now := time.Now()
+	md2 := *md
+	md2.VCS = ci.VCS
+	md2.CloneURL = redactCloneURL(ci.CloneURL)
+	md2.Created, md2.LastFetched, md2.FetchDuration = now, now, now.Sub(start)
+	md2.LastError, md2.LastErrorTime = "", time.Time{}
+	md2.NeedsReclone = false
+	if err := writeMetadata(cloneTmpDir, &md2); err != nil {
+		s.Log.Printf("Writing metadata of %s failed: %s.", repoPath, err)
+	}
+
+	// Swap the new clone in. Nobody has a handle to the repository
+	// (since we hold its write lock), but it may still be open.
+	rmTmpDir, err := ioutil.TempDir(filepath.Dir(cloneDir), "_tmp_rm_"+filepath.Base(cloneDir)+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(rmTmpDir)
+	key := repoKey{cloneDir}
+	s.repoMuMu.Lock()
+	closed := s.repos[key]
+	delete(s.repos, key)
+	oldDir := filepath.Join(rmTmpDir, filepath.Base(cloneDir))
+	err = os.Rename(cloneDir, oldDir)
+	if err == nil {
+		if err = os.Rename(cloneTmpDir, cloneDir); err != nil {
+			os.Rename(oldDir, cloneDir)
+		}
+	}
+	s.repoMuMu.Unlock()
+	if closed != nil {
+		closeRepo(closed.repo)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.Log.Printf("Re-cloned %s in %s.", repoPath, time.Since(start))
+
+	h, err := s.acquire(cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Release()
+	result, err = ClonedUpdateResult(h.Repo)
+	if err != nil {
+		// The clone itself succeeded, so don't fail the update.
+		s.Log.Printf("Listing branches of re-cloned repository %s failed: %s.", repoPath, err)
+	}
+	return result, nil
+}
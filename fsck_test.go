@@ -0,0 +1,100 @@
+package vcsstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func asJSON(v interface{}) string {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	return string(b)
+}
+
+func TestParseGitFsck(t *testing.T) {
+	out := `broken link from    tree df55a7dce59d040dc7819c1e241082965a80ebd9
+              to    blob 45b983be36b73c0788dc9cbcb76cbb80fc7bb057
+missing blob 45b983be36b73c0788dc9cbcb76cbb80fc7bb057
+error: HEAD: invalid sha1 pointer f77abefebc106cdf3268277c29e07773c65664ab
+notice: No default references
+`
+	want := []*vcsclient.FsckFinding{
+		{Kind: "broken-link", ObjectType: "blob", Object: "45b983be36b73c0788dc9cbcb76cbb80fc7bb057", Message: "broken link from tree df55a7dce59d040dc7819c1e241082965a80ebd9 to blob 45b983be36b73c0788dc9cbcb76cbb80fc7bb057"},
+		{Kind: "missing", ObjectType: "blob", Object: "45b983be36b73c0788dc9cbcb76cbb80fc7bb057", Message: "missing blob 45b983be36b73c0788dc9cbcb76cbb80fc7bb057"},
+		{Kind: "error", Message: "HEAD: invalid sha1 pointer f77abefebc106cdf3268277c29e07773c65664ab"},
+	}
+	if got := parseGitFsck([]byte(out)); !reflect.DeepEqual(got, want) {
+		t.Errorf("got findings %s, want %s", asJSON(got), asJSON(want))
+	}
+}
+
+func TestService_Fsck_reclone(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	remote := initRemoteGitRepo(t)
+	defer os.RemoveAll(remote)
+	if err := ioutil.WriteFile(filepath.Join(remote, "f"), []byte("hi\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	gitOutput(t, remote, "add", "f")
+	gitOutput(t, remote, "-c", "user.name=a", "-c", "user.email=a@a.com", "commit", "-m", "y")
+	blob := gitOutput(t, remote, "rev-parse", "HEAD:f")
+
+	// Cloning from a path copies the remote's loose objects, so the
+	// blob can be removed from the clone.
+	h, err := s.Clone("a.com/r", &vcsclient.CloneInfo{VCS: "git", CloneURL: remote})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+	cloneDir := mustCloneDir(t, s, "a.com/r")
+
+	result, err := s.Fsck("a.com/r", vcsclient.FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK || len(result.Findings) != 0 {
+		t.Fatalf("got result %s for intact clone, want OK", asJSON(result))
+	}
+
+	if err := os.Remove(filepath.Join(cloneDir, "objects", blob[:2], blob[2:])); err != nil {
+		t.Fatal(err)
+	}
+	result, err = s.Fsck("a.com/r", vcsclient.FsckOptions{MarkForReclone: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var missing bool
+	for _, f := range result.Findings {
+		missing = missing || (f.Kind == "missing" && f.Object == blob)
+	}
+	if result.OK || !missing || !result.MarkedForReclone {
+		t.Fatalf("got result %s, want missing blob %s and marked for re-clone", asJSON(result), blob)
+	}
+	if md, err := readMetadata(cloneDir); err != nil || !md.NeedsReclone {
+		t.Fatalf("got metadata %+v (err %v), want NeedsReclone", md, err)
+	}
+
+	// The next update re-clones the repository from its recorded
+	// clone URL.
+	if _, err := s.Update("a.com/r", &vcsclient.CloneInfo{}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = s.Fsck("a.com/r", vcsclient.FsckOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.OK {
+		t.Errorf("got result %s after re-clone, want OK", asJSON(result))
+	}
+	if md, err := readMetadata(cloneDir); err != nil || md.NeedsReclone {
+		t.Errorf("got metadata %+v (err %v) after re-clone, want !NeedsReclone", md, err)
+	}
+}
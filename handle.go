@@ -0,0 +1,262 @@
+package vcsstore
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+)
+
+var (
+	openRepoHits      = expvar.NewInt("vcsstore.open-repo-hits")
+	openRepoMisses    = expvar.NewInt("vcsstore.open-repo-misses")
+	openRepoEvictions = expvar.NewInt("vcsstore.open-repo-evictions")
+)
+
+// defaultOpenRepoIdleTTL is used if Config.OpenRepoIdleTTL is unset.
+const defaultOpenRepoIdleTTL = time.Minute
+
+// A RepoHandle is a reference to an open repository, acquired by
+// Service.Acquire or Service.Clone. The repository stays open (and is
+// not removed) until the handle is released.
+type RepoHandle struct {
+	// Repo is the repository (usually a vcs.Repository).
+	Repo interface{}
+
+	release func()
+	once    sync.Once
+}
+
+// NewRepoHandle returns a handle to repo that calls release (if
+// non-nil) when it is released. It is for implementations of Service
+// other than the one returned by NewService.
+func NewRepoHandle(repo interface{}, release func()) *RepoHandle {
+	return &RepoHandle{Repo: repo, release: release}
+}
+
+// Release releases the handle. The handle's repository must not be
+// used after it is released. Only the first call to Release has any
+// effect.
+func (h *RepoHandle) Release() {
+	h.once.Do(func() {
+		if h.release != nil {
+			h.release()
+		}
+	})
+}
+
+// openRepo is an open repository.
+type openRepo struct {
+	repo interface{}
+
+	// users is the number of unreleased handles to the repo.
+	users int
+
+	// lastUsed is when the last handle to the repo was released.
+	lastUsed time.Time
+}
+
+func (s *service) Acquire(repoPath string) (*RepoHandle, error) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	persist := s.recordAccess(repoKey{cloneDir})
+	h, err := s.acquireShared(cloneDir)
+	if err == nil && persist {
+		s.recordAccessMetadata(cloneDir)
+	}
+	return h, err
+}
+
+// acquireShared is like Acquire, but it doesn't count as a read of the
+// repo. The returned handle holds the repo's read lock until it is
+// released.
+func (s *service) acquireShared(cloneDir string) (*RepoHandle, error) {
+	mu := s.Mutex(repoKey{cloneDir})
+	mu.RLock()
+	h, err := s.acquire(cloneDir)
+	if err != nil {
+		mu.RUnlock()
+		return nil, err
+	}
+	return withUnlock(h, mu.RUnlock), nil
+}
+
+// acquire is like acquireShared, but the returned handle doesn't hold
+// the repo's lock. The caller must hold the lock.
+func (s *service) acquire(cloneDir string) (*RepoHandle, error) {
+	key := repoKey{cloneDir}
+
+	// Use the instance of the repo that is already open, if any.
+	s.repoMuMu.Lock()
+	if r := s.repos[key]; r != nil {
+		r.users++
+		s.repoMuMu.Unlock()
+		openRepoHits.Add(1)
+		return s.newHandle(key, r.repo), nil
+	}
+	s.repoMuMu.Unlock()
+	openRepoMisses.Add(1)
+
+	vcsType, err := vcsTypeFromDir(cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(cloneDir); err != nil {
+		return nil, err
+	} else if !fi.Mode().IsDir() {
+		return nil, fmt.Errorf("clone path %q is not a directory", cloneDir)
+	}
+	repo, err := vcs.Open(vcsType, cloneDir)
+	if err != nil {
+		return nil, err
+	}
+
+	s.repoMuMu.Lock()
+	if r := s.repos[key]; r != nil {
+		// Another goroutine raced us to open this repo. Use theirs,
+		// not ours, so that there is only 1 instance of this repo in
+		// use at a time.
+		r.users++
+		s.repoMuMu.Unlock()
+		closeRepo(repo)
+		return s.newHandle(key, r.repo), nil
+	}
+	s.repos[key] = &openRepo{repo: repo, users: 1}
+	evicted := s.evictOpenRepos()
+	s.repoMuMu.Unlock()
+
+	closeRepos(evicted)
+	return s.newHandle(key, repo), nil
+}
+
+func (s *service) newHandle(key repoKey, repo interface{}) *RepoHandle {
+	return NewRepoHandle(repo, func() { s.release(key) })
+}
+
+// withUnlock returns a handle to the same repo as h that releases h
+// and then calls unlock when it is released.
+func withUnlock(h *RepoHandle, unlock func()) *RepoHandle {
+	return NewRepoHandle(h.Repo, func() {
+		h.Release()
+		unlock()
+	})
+}
+
+// release releases a handle to the repo. If the repo then has no
+// handles, it is closed now or after OpenRepoIdleTTL.
+func (s *service) release(key repoKey) {
+	s.repoMuMu.Lock()
+	r := s.repos[key]
+	if r == nil || r.users == 0 {
+		s.repoMuMu.Unlock()
+		panic(fmt.Sprintf("release of repo %s without handles", key.cloneDir))
+	}
+	r.users--
+	var evicted []interface{}
+	if r.users == 0 {
+		r.lastUsed = time.Now()
+		if s.openRepoIdleTTL() < 0 {
+			delete(s.repos, key)
+			evicted = append(evicted, r.repo)
+		} else {
+			evicted = s.evictOpenRepos()
+		}
+	}
+	s.repoClosed.Broadcast()
+	s.repoMuMu.Unlock()
+
+	closeRepos(evicted)
+}
+
+// repoUsers returns the number of unreleased handles to the repo. The
+// caller must hold repoMuMu.
+func (s *service) repoUsers(key repoKey) int {
+	if r := s.repos[key]; r != nil {
+		return r.users
+	}
+	return 0
+}
+
+// evictOpenRepos forgets the least recently used repos without
+// handles until there are no more than MaxOpenRepos open repos, and
+// returns them so that the caller can close them (after releasing
+// repoMuMu, which the caller must hold).
+func (s *service) evictOpenRepos() (evicted []interface{}) {
+	if s.MaxOpenRepos <= 0 {
+		return nil
+	}
+	for len(s.repos) > s.MaxOpenRepos {
+		var lru *repoKey
+		for key, r := range s.repos {
+			if r.users == 0 && (lru == nil || r.lastUsed.Before(s.repos[*lru].lastUsed)) {
+				key := key
+				lru = &key
+			}
+		}
+		if lru == nil {
+			// All open repos have handles.
+			break
+		}
+		evicted = append(evicted, s.repos[*lru].repo)
+		delete(s.repos, *lru)
+		openRepoEvictions.Add(1)
+	}
+	return evicted
+}
+
+func (s *service) openRepoIdleTTL() time.Duration {
+	if s.OpenRepoIdleTTL == 0 {
+		return defaultOpenRepoIdleTTL
+	}
+	return s.OpenRepoIdleTTL
+}
+
+// runOpenRepoReaper periodically closes repos that have had no handles
+// for OpenRepoIdleTTL.
+func (s *service) runOpenRepoReaper() {
+	ttl := s.openRepoIdleTTL()
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			closeRepos(s.expireOpenRepos(ttl))
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// expireOpenRepos forgets the repos that have had no handles for ttl,
+// and returns them so that the caller can close them.
+func (s *service) expireOpenRepos(ttl time.Duration) (expired []interface{}) {
+	s.repoMuMu.Lock()
+	defer s.repoMuMu.Unlock()
+	for key, r := range s.repos {
+		if r.users == 0 && time.Since(r.lastUsed) >= ttl {
+			expired = append(expired, r.repo)
+			delete(s.repos, key)
+			openRepoEvictions.Add(1)
+		}
+	}
+	return expired
+}
+
+// closeRepo closes repo if it holds resources that must be closed.
+func closeRepo(repo interface{}) {
+	if c, ok := repo.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+func closeRepos(repos []interface{}) {
+	for _, repo := range repos {
+		closeRepo(repo)
+	}
+}
@@ -0,0 +1,127 @@
+package vcsstore
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestService_Acquire_refcount(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	repoPath := "a.com/x"
+	key := repoKey{initBareGitRepo(t, s, repoPath)}
+
+	hits, misses := openRepoHits.Value(), openRepoMisses.Value()
+	h1, err := s.Acquire(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := s.Acquire(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h1.Repo != h2.Repo {
+		t.Error("got different instances of the same repo")
+	}
+	if got := openRepoMisses.Value() - misses; got != 1 {
+		t.Errorf("got %d misses, want 1", got)
+	}
+	if got := openRepoHits.Value() - hits; got != 1 {
+		t.Errorf("got %d hits, want 1", got)
+	}
+
+	// Releasing a handle more than once must not release the other
+	// handle.
+	h1.Release()
+	h1.Release()
+	s.repoMuMu.Lock()
+	users := s.repoUsers(key)
+	s.repoMuMu.Unlock()
+	if users != 1 {
+		t.Errorf("got %d users, want 1", users)
+	}
+
+	if removed, err := s.remove(repoPath, false); err != nil {
+		t.Fatal(err)
+	} else if removed {
+		t.Error("repo was removed while a handle was unreleased")
+	}
+
+	h2.Release()
+	if removed, err := s.remove(repoPath, false); err != nil {
+		t.Fatal(err)
+	} else if !removed {
+		t.Error("repo was not removed after all handles were released")
+	}
+	if _, present := s.repos[key]; present {
+		t.Error("removed repo is still open")
+	}
+}
+
+func TestService_expireOpenRepos(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	key := repoKey{initBareGitRepo(t, s, "a.com/x")}
+	h, err := s.Acquire("a.com/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired := s.expireOpenRepos(0); len(expired) != 0 {
+		t.Errorf("got %d expired repos while handle was unreleased, want 0", len(expired))
+	}
+
+	h.Release()
+	if _, present := s.repos[key]; !present {
+		t.Fatal("repo was closed before its idle TTL")
+	}
+	before := openRepoEvictions.Value()
+	if expired := s.expireOpenRepos(time.Hour); len(expired) != 0 {
+		t.Errorf("got %d expired repos before idle TTL, want 0", len(expired))
+	}
+	if expired := s.expireOpenRepos(0); len(expired) != 1 {
+		t.Errorf("got %d expired repos after idle TTL, want 1", len(expired))
+	}
+	if _, present := s.repos[key]; present {
+		t.Error("repo is still open after its idle TTL")
+	}
+	if got := openRepoEvictions.Value() - before; got != 1 {
+		t.Errorf("got %d evictions, want 1", got)
+	}
+}
+
+func TestService_MaxOpenRepos(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+	s.MaxOpenRepos = 1
+
+	keyX := repoKey{initBareGitRepo(t, s, "a.com/x")}
+	keyY := repoKey{initBareGitRepo(t, s, "a.com/y")}
+
+	hx, err := s.Acquire("a.com/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hy, err := s.Acquire("a.com/y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Repos with handles are never closed, even above the limit.
+	if len(s.repos) != 2 {
+		t.Errorf("got %d open repos, want 2", len(s.repos))
+	}
+
+	hx.Release()
+	if _, present := s.repos[keyX]; present {
+		t.Error("released repo above limit is still open")
+	}
+	hy.Release()
+	if _, present := s.repos[keyY]; !present {
+		t.Error("released repo within limit was closed")
+	}
+}
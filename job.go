@@ -0,0 +1,190 @@
+package vcsstore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// jobRetention is how long finished jobs are kept, so that clients
+// can get their results.
+const jobRetention = time.Hour
+
+// job is an asynchronous clone-or-update of a repository.
+type job struct {
+	mu sync.Mutex
+	vcsclient.Job
+}
+
+// snapshot returns a copy of the job's current state.
+func (j *job) snapshot() *vcsclient.Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j2 := j.Job
+	if j2.Done() {
+		j2.Duration = j2.Finished.Sub(j2.Started)
+	} else {
+		j2.Duration = time.Since(j2.Started)
+	}
+	return &j2
+}
+
+func (j *job) setProgress(p cloneProgress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Phase = p.Phase
+	if p.Phase == "receiving" {
+		j.ReceivedObjects, j.TotalObjects = p.Objects, p.TotalObjects
+		j.ReceivedBytes = p.Bytes
+	}
+}
+
+func (j *job) finish(result *vcs.UpdateResult, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Finished = time.Now()
+	if err != nil {
+		j.State = vcsclient.JobFailed
+		j.Error = err.Error()
+		_, j.TimedOut = err.(*TimeoutError)
+	} else {
+		j.State = vcsclient.JobSucceeded
+		j.Result = result
+	}
+}
+
+func (j *job) done() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Done()
+}
+
+// StartCloneOrUpdate starts cloning the repository (if it doesn't yet
+// exist locally) or updating it from its default remote, in the
+// background. If a job for the repository is already running, it is
+// returned instead of a new one being started.
+func (s *service) StartCloneOrUpdate(repoPath string, cloneInfo *vcsclient.CloneInfo) (*vcsclient.Job, error) {
+	if _, err := s.CloneDir(repoPath); err != nil {
+		return nil, err
+	}
+
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	s.pruneJobs()
+
+	for _, j := range s.jobs {
+		if j.RepoPath == repoPath && !j.done() {
+			return j.snapshot(), nil
+		}
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+	j := &job{Job: vcsclient.Job{
+		ID:       id,
+		RepoPath: repoPath,
+		State:    vcsclient.JobRunning,
+		Started:  time.Now(),
+	}}
+	s.jobs[id] = j
+	go s.runJob(j, cloneInfo)
+	return j.snapshot(), nil
+}
+
+// Job returns the current state of one of the repository's jobs. If
+// there is no such job, vcsclient.ErrJobNotExist is returned.
+func (s *service) Job(repoPath, id string) (*vcsclient.Job, error) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	j, present := s.jobs[id]
+	if !present || j.RepoPath != repoPath {
+		return nil, vcsclient.ErrJobNotExist
+	}
+	return j.snapshot(), nil
+}
+
+// pruneJobs forgets jobs that finished more than jobRetention ago. The
+// caller must hold jobsMu.
+func (s *service) pruneJobs() {
+	for id, j := range s.jobs {
+		if j.done() && time.Since(j.snapshot().Finished) > jobRetention {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+func (s *service) runJob(j *job, cloneInfo *vcsclient.CloneInfo) {
+	s.Log.Printf("Job %s: cloning or updating %s...", j.ID, j.RepoPath)
+	result, err := s.cloneOrUpdate(j.RepoPath, cloneInfo, j.setProgress)
+	j.finish(result, err)
+	if err != nil {
+		s.Log.Printf("Job %s: cloning or updating %s failed: %s.", j.ID, j.RepoPath, err)
+	} else {
+		s.Log.Printf("Job %s: finished cloning or updating %s in %s.", j.ID, j.RepoPath, j.snapshot().Duration)
+	}
+}
+
+// cloneOrUpdate clones the repository if it doesn't yet exist locally
+// or updates it from its default remote. Progress is reported to
+// progress.
+func (s *service) cloneOrUpdate(repoPath string, cloneInfo *vcsclient.CloneInfo, progress func(cloneProgress)) (*vcs.UpdateResult, error) {
+	h, err := s.Acquire(repoPath)
+	if os.IsNotExist(err) {
+		h, err = s.clone(repoPath, cloneInfo, progress)
+		if err != nil {
+			return nil, err
+		}
+		defer h.Release()
+
+		result, err := ClonedUpdateResult(h.Repo)
+		if err != nil {
+			// The clone itself succeeded, so don't fail the job.
+			s.Log.Printf("Listing branches of newly cloned repository %s failed: %s.", repoPath, err)
+		}
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Release the handle (and its read lock) so that the update can
+	// take the write lock.
+	h.Release()
+
+	return s.sharedFetch(repoPath, cloneInfo, progress)
+}
+
+// ClonedUpdateResult returns an UpdateResult for a newly cloned
+// repository, in which every branch is new. If listing the branches
+// fails, the result so far is returned along with the error.
+func ClonedUpdateResult(repo interface{}) (*vcs.UpdateResult, error) {
+	result := &vcs.UpdateResult{}
+
+	type branches interface {
+		Branches(vcs.BranchesOptions) ([]*vcs.Branch, error)
+	}
+	if repo, ok := repo.(branches); ok {
+		branches, err := repo.Branches(vcs.BranchesOptions{})
+		if err != nil {
+			return result, err
+		}
+		for _, b := range branches {
+			result.Changes = append(result.Changes, vcs.Change{Op: vcs.NewOp, Branch: b.Name})
+		}
+	}
+	return result, nil
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,80 @@
+package vcsstore
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func TestService_StartCloneOrUpdate(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	remoteDir := initRemoteGitRepo(t)
+	defer os.RemoveAll(remoteDir)
+
+	cloneInfo := &vcsclient.CloneInfo{VCS: "git", CloneURL: remoteDir}
+	// The first job clones the repository, and the second updates it
+	// (with no changes).
+	for i, wantChanges := range [][]vcs.Change{{{Op: vcs.NewOp, Branch: "b"}}, nil} {
+		job, err := s.StartCloneOrUpdate("a.com/x", cloneInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State != vcsclient.JobRunning {
+			t.Errorf("#%d: got state %q, want %q", i, job.State, vcsclient.JobRunning)
+		}
+
+		job = waitJob(t, s, job)
+		if job.State != vcsclient.JobSucceeded {
+			t.Fatalf("#%d: got state %q, want %q (error: %s)", i, job.State, vcsclient.JobSucceeded, job.Error)
+		}
+		if job.Result == nil || len(job.Result.Changes) != len(wantChanges) || (len(wantChanges) > 0 && job.Result.Changes[0] != wantChanges[0]) {
+			t.Errorf("#%d: got result %+v, want changes %+v", i, job.Result, wantChanges)
+		}
+		if job.Duration <= 0 {
+			t.Errorf("#%d: got duration %s, want > 0", i, job.Duration)
+		}
+	}
+
+	if _, err := s.Job("a.com/other", "x"); err != vcsclient.ErrJobNotExist {
+		t.Errorf("got error %v, want ErrJobNotExist", err)
+	}
+}
+
+func TestService_StartCloneOrUpdate_error(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	job, err := s.StartCloneOrUpdate("a.com/x", &vcsclient.CloneInfo{VCS: "git", CloneURL: "/does/not/exist"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job = waitJob(t, s, job)
+	if job.State != vcsclient.JobFailed || job.Error == "" {
+		t.Errorf("got state %q and error %q, want failure", job.State, job.Error)
+	}
+	if _, err := os.Stat(mustCloneDir(t, s, "a.com/x")); !os.IsNotExist(err) {
+		t.Errorf("got clone dir stat error %v, want not exist", err)
+	}
+}
+
+func waitJob(t *testing.T, s *service, job *vcsclient.Job) *vcsclient.Job {
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		var err error
+		job, err = s.Job(job.RepoPath, job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Done() {
+			return job
+		}
+	}
+	t.Fatalf("job %s did not finish", job.ID)
+	return nil
+}
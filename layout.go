@@ -0,0 +1,284 @@
+package vcsstore
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// layoutFile is the name of the file in StorageDir that records the
+// StorageDir's layout. A StorageDir without one has LegacyLayout.
+const layoutFile = ".vcsstore-layout.json"
+
+// layoutV1Dir is the dir beneath StorageDir that holds the repos of a
+// version 1 layout.
+const layoutV1Dir = "_v1"
+
+// maxFanOut is the maximum number of levels of hash-prefix dirs.
+const maxFanOut = 4
+
+// A Layout describes how repositories are arranged beneath
+// StorageDir.
+type Layout struct {
+	// Version is the layout's version.
+	//
+	// In version 0 (the legacy layout), each repository's clone dir is
+	// its cleaned repository path, so repository paths are nested dirs
+	// and are stored unescaped.
+	//
+	// In version 1, each repository's clone dir is a single dir named
+	// by EscapeRepositoryPath, beneath FanOut levels of dirs named by
+	// successive bytes (in hex) of the SHA-1 hash of the repository
+	// path, all in the "_v1" dir. For example, with a FanOut of 2,
+	// "github.com/Foo/bar" is stored in
+	// "_v1/17/b2/github.com+!foo+bar".
+	Version int
+
+	// FanOut is the number of levels of hash-prefix dirs (at most 4)
+	// in a version 1 layout.
+	FanOut int `json:",omitempty"`
+}
+
+var (
+	// LegacyLayout is the layout of a StorageDir that has never been
+	// migrated.
+	LegacyLayout = Layout{Version: 0}
+
+	// DefaultLayout is the layout that StorageDirs are migrated to by
+	// default. With a FanOut of 2, there are 65536 hash-prefix dirs,
+	// so each holds few repositories even when there are millions.
+	DefaultLayout = Layout{Version: 1, FanOut: 2}
+)
+
+func (l Layout) String() string {
+	if l.Version == 0 {
+		return "v0 (legacy)"
+	}
+	return fmt.Sprintf("v%d (fan-out %d)", l.Version, l.FanOut)
+}
+
+func (l Layout) validate() error {
+	switch l.Version {
+	case 0:
+		if l.FanOut != 0 {
+			return fmt.Errorf("layout %s doesn't support fan-out", l)
+		}
+	case 1:
+		if l.FanOut < 0 || l.FanOut > maxFanOut {
+			return fmt.Errorf("invalid layout fan-out %d (must be between 0 and %d)", l.FanOut, maxFanOut)
+		}
+	default:
+		return fmt.Errorf("unsupported layout version %d", l.Version)
+	}
+	return nil
+}
+
+// repoDir returns the slash-separated path (relative to StorageDir)
+// of the repository's clone dir in the layout. If the repository path
+// would refer to a reserved dir (see isReservedDir), an error
+// satisfying os.IsNotExist is returned.
+func (l Layout) repoDir(repoPath string) (string, error) {
+	// Check in every layout, so that the same repository paths are
+	// valid in all of them (and a migration's fallback to the previous
+	// layout can't reach a reserved dir either).
+	if isReservedDir(strings.Trim(path.Clean("/"+repoPath), "/")) {
+		return "", &os.PathError{Op: "lookup", Path: repoPath, Err: os.ErrNotExist}
+	}
+
+	if l.Version == 0 {
+		return EncodeRepositoryPath(repoPath), nil
+	}
+
+	repoPath, err := cleanRepositoryPath(repoPath)
+	if err != nil {
+		return "", err
+	}
+	name := EscapeRepositoryPath(repoPath)
+	if len(name) > maxEscapedPathLen {
+		return "", fmt.Errorf("repository path %q is too long (%d bytes escaped, max %d)", repoPath, len(name), maxEscapedPathLen)
+	}
+	sum := sha1.Sum([]byte(repoPath))
+	hash := hex.EncodeToString(sum[:])
+	parts := []string{layoutV1Dir}
+	for i := 0; i < l.FanOut; i++ {
+		parts = append(parts, hash[2*i:2*i+2])
+	}
+	parts = append(parts, name)
+	return strings.Join(parts, "/"), nil
+}
+
+// cloneDir returns the repository's clone dir in the layout.
+func (l Layout) cloneDir(storageDir, repoPath string) (string, error) {
+	dir, err := l.repoDir(repoPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storageDir, filepath.FromSlash(dir)), nil
+}
+
+// repoPathFromDir returns the repository path whose clone dir in the
+// layout is dir (a slash-separated path relative to StorageDir). If
+// dir can't be a clone dir in the layout, ok is false.
+func (l Layout) repoPathFromDir(dir string) (repoPath string, ok bool) {
+	if l.Version == 0 {
+		if isReservedDir(dir) {
+			return "", false
+		}
+		return DecodeRepositoryPath(dir), true
+	}
+
+	parts := strings.Split(dir, "/")
+	if len(parts) != l.FanOut+2 || parts[0] != layoutV1Dir {
+		return "", false
+	}
+	repoPath, err := UnescapeRepositoryPath(parts[len(parts)-1])
+	if err != nil {
+		return "", false
+	}
+	if want, err := l.repoDir(repoPath); err != nil || want != dir {
+		return "", false
+	}
+	return repoPath, true
+}
+
+// isReservedDir returns whether dir (a clean, slash-separated path
+// relative to StorageDir) is or is beneath a dir that vcsstore uses
+// itself: the version 1 layout's tree, the object pools, or a
+// temporary dir (of a clone, removal, etc.). Reserved dirs are never
+// clone dirs.
+func isReservedDir(dir string) bool {
+	for _, reserved := range []string{layoutV1Dir, poolsDir} {
+		if dir == reserved || strings.HasPrefix(dir, reserved+"/") {
+			return true
+		}
+	}
+	for _, name := range strings.Split(dir, "/") {
+		if strings.HasPrefix(name, "_tmp_") {
+			return true
+		}
+	}
+	return false
+}
+
+// storageLayout is the contents of a StorageDir's layout file.
+type storageLayout struct {
+	Layout
+
+	// MigratingFrom is the StorageDir's previous layout while it is
+	// being migrated to Layout. Repositories that haven't been moved
+	// yet are still in their clone dirs in the previous layout.
+	MigratingFrom *Layout `json:",omitempty"`
+}
+
+// cloneDir returns the repository's clone dir in the StorageDir.
+func (sl *storageLayout) cloneDir(storageDir, repoPath string) (string, error) {
+	dir, err := sl.Layout.cloneDir(storageDir, repoPath)
+	if err != nil || sl.MigratingFrom == nil {
+		return dir, err
+	}
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		// The repository hasn't been moved yet (or doesn't exist).
+		if oldDir, err := sl.MigratingFrom.cloneDir(storageDir, repoPath); err == nil {
+			if _, err := vcsTypeFromDir(oldDir); err == nil {
+				return oldDir, nil
+			}
+		}
+	}
+	return dir, nil
+}
+
+// layoutCache holds the layout file of each StorageDir that has been
+// read, so that it is only read again when it changes.
+var layoutCache = struct {
+	dirs map[string]cachedLayout
+	sync.Mutex
+}{dirs: map[string]cachedLayout{}}
+
+type cachedLayout struct {
+	modTime time.Time
+	size    int64
+	layout  *storageLayout
+}
+
+// readStorageLayout returns the layout of storageDir.
+func readStorageLayout(storageDir string) (*storageLayout, error) {
+	file := filepath.Join(storageDir, layoutFile)
+	fi, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		return &storageLayout{Layout: LegacyLayout}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	key := filepath.Clean(storageDir)
+	layoutCache.Lock()
+	c, ok := layoutCache.dirs[key]
+	layoutCache.Unlock()
+	if ok && c.modTime.Equal(fi.ModTime()) && c.size == fi.Size() {
+		return c.layout, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var sl *storageLayout
+	if err := json.Unmarshal(data, &sl); err != nil {
+		return nil, fmt.Errorf("invalid layout file %s: %s", file, err)
+	}
+	if err := sl.validate(); err != nil {
+		return nil, fmt.Errorf("invalid layout file %s: %s", file, err)
+	}
+	if sl.MigratingFrom != nil {
+		if err := sl.MigratingFrom.validate(); err != nil {
+			return nil, fmt.Errorf("invalid layout file %s: %s", file, err)
+		}
+	}
+
+	layoutCache.Lock()
+	layoutCache.dirs[key] = cachedLayout{modTime: fi.ModTime(), size: fi.Size(), layout: sl}
+	layoutCache.Unlock()
+	return sl, nil
+}
+
+// writeStorageLayout "atomically" writes the layout file of
+// storageDir.
+func writeStorageLayout(storageDir string, sl *storageLayout) error {
+	data, err := json.MarshalIndent(sl, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(storageDir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(storageDir, layoutFile+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), filepath.Join(storageDir, layoutFile)); err != nil {
+		return err
+	}
+
+	// Don't rely on the file's modification time to invalidate the
+	// cache, since it may have a coarse resolution.
+	layoutCache.Lock()
+	delete(layoutCache.dirs, filepath.Clean(storageDir))
+	layoutCache.Unlock()
+	return nil
+}
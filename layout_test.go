@@ -0,0 +1,150 @@
+package vcsstore
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func TestLayout_repoDir(t *testing.T) {
+	tests := []struct {
+		layout   Layout
+		repoPath string
+		want     string
+
+		// wantRepoPath is the repository path that want decodes to, if
+		// it isn't repoPath.
+		wantRepoPath string
+	}{
+		{LegacyLayout, "github.com/Foo/bar", "github.com/Foo/bar", ""},
+		{Layout{Version: 1}, "github.com/Foo/bar", "_v1/github.com+!foo+bar", ""},
+		{Layout{Version: 1, FanOut: 2}, "github.com/Foo/bar", "_v1/17/b2/github.com+!foo+bar", ""},
+		{Layout{Version: 1, FanOut: 2}, "/github.com//Foo/bar/", "_v1/17/b2/github.com+!foo+bar", "github.com/Foo/bar"},
+	}
+	for _, test := range tests {
+		dir, err := test.layout.repoDir(test.repoPath)
+		if err != nil {
+			t.Errorf("%s %q: repoDir: %s", test.layout, test.repoPath, err)
+			continue
+		}
+		if dir != test.want {
+			t.Errorf("%s %q: got dir %q, want %q", test.layout, test.repoPath, dir, test.want)
+		}
+		repoPath, ok := test.layout.repoPathFromDir(dir)
+		want := test.wantRepoPath
+		if want == "" {
+			want = test.repoPath
+		}
+		if !ok || repoPath != want {
+			t.Errorf("%s %q: got repoPathFromDir(%q) == %q, %v, want %q", test.layout, test.repoPath, dir, repoPath, ok, want)
+		}
+	}
+
+	for _, dir := range []string{"_v1/17/github.com+!foo+bar", "_v1/00/00/github.com+!foo+bar", "_v1/17/b2/github.com+Foo+bar", "17/b2/github.com+!foo+bar"} {
+		if repoPath, ok := DefaultLayout.repoPathFromDir(dir); ok {
+			t.Errorf("%q: got repoPathFromDir == %q, want !ok", dir, repoPath)
+		}
+	}
+
+	if _, err := DefaultLayout.repoDir(""); err == nil {
+		t.Error("got no error for empty repository path")
+	}
+
+	// Repository paths can't refer to reserved dirs, in any layout.
+	for _, layout := range []Layout{LegacyLayout, DefaultLayout} {
+		for _, repoPath := range []string{"_v1", "_v1/17/b2/github.com+!foo+bar", "/_v1/x", "./_v1/x", "_pools/x", "a.com/_tmp_x-123"} {
+			if dir, err := layout.repoDir(repoPath); !os.IsNotExist(err) {
+				t.Errorf("%s %q: got repoDir == %q (err %v), want os.IsNotExist error", layout, repoPath, dir, err)
+			}
+		}
+	}
+	if dir, err := LegacyLayout.repoDir("a.com/_v1"); err != nil || dir != "a.com/_v1" {
+		t.Errorf("got repoDir(a.com/_v1) == %q (err %v), want a.com/_v1", dir, err)
+	}
+}
+
+func TestService_legacyLayoutReservedDirs(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	// A repository in the version 1 layout's tree (e.g., one that was
+	// moved there during a migration) can't be reached by the
+	// repository path of its dir in the legacy layout.
+	v1Dir := filepath.Join(storageDir, "_v1/17/b2/github.com+!foo+bar")
+	if err := os.MkdirAll(v1Dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("git", "init", "--bare")
+	cmd.Dir = v1Dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init --bare failed: %s\n%s", err, out)
+	}
+
+	const repoPath = "_v1/17/b2/github.com+!foo+bar"
+	if h, err := s.Acquire(repoPath); !os.IsNotExist(err) {
+		if h != nil {
+			h.Release()
+		}
+		t.Errorf("got Acquire error %v, want os.IsNotExist", err)
+	}
+	if err := s.Remove(repoPath); !os.IsNotExist(err) {
+		t.Errorf("got Remove error %v, want os.IsNotExist", err)
+	}
+	if _, err := s.Clone("_v1/x", &vcsclient.CloneInfo{VCS: "git", CloneURL: v1Dir}); !os.IsNotExist(err) {
+		t.Errorf("got Clone error %v, want os.IsNotExist", err)
+	}
+	if _, err := os.Stat(filepath.Join(v1Dir, "HEAD")); err != nil {
+		t.Errorf("repository in the version 1 tree was changed: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(storageDir, "_v1/x")); !os.IsNotExist(err) {
+		t.Errorf("got stat error %v for clone into the version 1 tree, want not exist", err)
+	}
+}
+
+func TestService_versionedLayout(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+	if err := writeStorageLayout(storageDir, &storageLayout{Layout: DefaultLayout}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Repository paths that differ only in case must not share a
+	// clone dir.
+	for _, repoPath := range []string{"a.com/X", "a.com/x", "b.com/y/z"} {
+		initBareGitRepo(t, s, repoPath)
+	}
+	cloneDir := mustCloneDir(t, s, "a.com/X")
+	if want := filepath.Join(storageDir, "_v1/32/4e/a.com+!x"); cloneDir != want {
+		t.Errorf("got clone dir %s, want %s", cloneDir, want)
+	}
+
+	repos, _, err := s.ListRepositories(vcsclient.RepositoryListOptions{Prefix: "a.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repoPaths []string
+	for _, repo := range repos {
+		repoPaths = append(repoPaths, repo.RepoPath)
+	}
+	if want := []string{"a.com/X", "a.com/x"}; !reflect.DeepEqual(repoPaths, want) {
+		t.Errorf("got repos %v, want %v", repoPaths, want)
+	}
+
+	h, err := s.Acquire("a.com/X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+	if err := s.Remove("a.com/X"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cloneDir); !os.IsNotExist(err) {
+		t.Errorf("got stat error %v after Remove, want not exist", err)
+	}
+}
@@ -0,0 +1,188 @@
+package vcsstore
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func (s *service) ListRepositories(opt vcsclient.RepositoryListOptions) ([]*vcsclient.RepositoryInfo, uint, error) {
+	var repos []*vcsclient.RepositoryInfo
+	dirs := map[string]string{}
+	err := walkRepositories(s.StorageDir, opt.Prefix, func(repoPath, cloneDir, vcsType string) error {
+		repos = append(repos, &vcsclient.RepositoryInfo{RepoPath: repoPath, VCS: vcsType})
+		dirs[repoPath] = cloneDir
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	sort.Sort(repositoryInfosByPath(repos))
+
+	total := uint(len(repos))
+	if opt.Skip > total {
+		opt.Skip = total
+	}
+	repos = repos[opt.Skip:]
+	if opt.N != 0 && opt.N < uint(len(repos)) {
+		repos = repos[:opt.N]
+	}
+
+	// Only stat the repositories we're returning, since computing the
+	// size requires walking the whole clone dir.
+	for _, repo := range repos {
+		cloneDir := dirs[repo.RepoPath]
+		repo.Size, err = dirSize(cloneDir)
+		if err != nil {
+			return nil, 0, err
+		}
+		if md, err := readMetadata(cloneDir); err == nil && !md.LastFetched.IsZero() {
+			repo.LastFetched = md.LastFetched
+		} else {
+			repo.LastFetched = lastFetchTime(cloneDir, repo.VCS)
+		}
+	}
+
+	return repos, total, nil
+}
+
+type repositoryInfosByPath []*vcsclient.RepositoryInfo
+
+func (v repositoryInfosByPath) Len() int           { return len(v) }
+func (v repositoryInfosByPath) Less(i, j int) bool { return v[i].RepoPath < v[j].RepoPath }
+func (v repositoryInfosByPath) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+// walkRepositories calls fn for each repository stored beneath
+// storageDir whose repository path begins with prefix. While
+// storageDir is being migrated to a new layout, repositories in both
+// the new and the old layout are included.
+func walkRepositories(storageDir, prefix string, fn func(repoPath, cloneDir, vcsType string) error) error {
+	sl, err := readStorageLayout(storageDir)
+	if err != nil {
+		return err
+	}
+	if sl.MigratingFrom == nil {
+		return walkLayout(storageDir, sl.Layout, prefix, fn)
+	}
+
+	// A repository may be moved between the 2 walks, so don't report
+	// it twice.
+	seen := map[string]bool{}
+	visit := func(repoPath, cloneDir, vcsType string) error {
+		if seen[repoPath] {
+			return nil
+		}
+		seen[repoPath] = true
+		return fn(repoPath, cloneDir, vcsType)
+	}
+	if err := walkLayout(storageDir, sl.Layout, prefix, visit); err != nil {
+		return err
+	}
+	return walkLayout(storageDir, *sl.MigratingFrom, prefix, visit)
+}
+
+// walkLayout calls fn for each repository stored beneath storageDir in
+// layout l whose repository path begins with prefix. Directories that
+// vcsTypeFromDir recognizes as repositories are not descended into,
+// and temporary clone directories (and symlinks left by a layout
+// migration) are skipped.
+func walkLayout(storageDir string, l Layout, prefix string, fn func(repoPath, cloneDir, vcsType string) error) error {
+	storageDir = filepath.Clean(storageDir)
+	root := storageDir
+	if l.Version == 1 {
+		root = filepath.Join(storageDir, layoutV1Dir)
+	}
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if path == root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() || path == root {
+			return nil
+		}
+		if strings.HasPrefix(fi.Name(), "_tmp_") {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(storageDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if l.Version == 1 && strings.Count(rel, "/") <= l.FanOut {
+			// A hash-prefix dir.
+			return nil
+		}
+
+		repoPath, ok := l.repoPathFromDir(rel)
+		if !ok {
+			return filepath.SkipDir
+		}
+		if !strings.HasPrefix(repoPath, prefix) && !strings.HasPrefix(prefix, repoPath+"/") {
+			// Neither this dir nor any of its descendants can match.
+			return filepath.SkipDir
+		}
+
+		vcsType, err := vcsTypeFromDir(path)
+		if err != nil {
+			if l.Version == 1 {
+				return filepath.SkipDir
+			}
+			// Not a repository; keep looking beneath it.
+			return nil
+		}
+		if strings.HasPrefix(repoPath, prefix) {
+			if err := fn(repoPath, path, vcsType); err != nil {
+				return err
+			}
+		}
+		return filepath.SkipDir
+	})
+}
+
+// dirSize returns the total size of all regular files beneath dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// lastFetchFiles lists, for each VCS type, the files (relative to
+// the clone dir) that are written when a repository is cloned or
+// updated from its remote.
+var lastFetchFiles = map[string][]string{
+	"git": {"FETCH_HEAD", "packed-refs", "HEAD", ".git/FETCH_HEAD", ".git/packed-refs", ".git/HEAD"},
+	"hg":  {".hg/store/00changelog.i", ".hg/00changelog.i"},
+}
+
+// lastFetchTime returns the most recent modification time of the
+// VCS's fetch metadata files in cloneDir, or the zero time if none
+// exist.
+func lastFetchTime(cloneDir, vcsType string) time.Time {
+	var t time.Time
+	for _, name := range lastFetchFiles[vcsType] {
+		fi, err := os.Stat(filepath.Join(cloneDir, name))
+		if err != nil {
+			continue
+		}
+		if mt := fi.ModTime(); mt.After(t) {
+			t = mt
+		}
+	}
+	return t
+}
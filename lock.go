@@ -0,0 +1,165 @@
+package vcsstore
+
+import (
+	"expvar"
+	"sync"
+	"time"
+)
+
+// repoLockWaits holds the number of times that a repo's lock was not
+// immediately available ("read-waits" and "write-waits") and the total
+// time spent waiting for it in nanoseconds ("read-wait-ns" and
+// "write-wait-ns").
+var repoLockWaits = expvar.NewMap("vcsstore.repo-lock-waits")
+
+// fairRWMutex is a reader/writer lock on a repository. Reads (of
+// files, commits, etc.) take the read lock, and operations that
+// modify the repository on disk (cloning, updating, gc, and removal)
+// take the write lock.
+//
+// Neither readers nor writers can be starved: once a writer is
+// waiting, new readers wait for it, and readers that were already
+// waiting when a writer releases the lock are admitted before the
+// next writer.
+type fairRWMutex struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	readers int  // number of readers holding the lock
+	writer  bool // whether a writer holds the lock
+
+	readersWaiting, writersWaiting int
+
+	// gen is incremented each time a writer releases the lock.
+	gen int
+
+	// admitted is the number of waiting readers that must be admitted
+	// before the next writer, because they were waiting when the last
+	// writer released the lock.
+	admitted int
+}
+
+func newFairRWMutex() *fairRWMutex {
+	l := &fairRWMutex{}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *fairRWMutex) RLock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.readerMustWait(l.gen) {
+		start := time.Now()
+		g := l.gen
+		l.readersWaiting++
+		for l.readerMustWait(g) {
+			l.cond.Wait()
+		}
+		l.readersWaiting--
+		if l.gen != g && l.admitted > 0 {
+			l.admitted--
+		}
+		repoLockWaits.Add("read-waits", 1)
+		repoLockWaits.Add("read-wait-ns", int64(time.Since(start)))
+	}
+	l.readers++
+}
+
+// readerMustWait returns whether a reader that started waiting in
+// generation g must keep waiting. The caller must hold l.mu.
+func (l *fairRWMutex) readerMustWait(g int) bool {
+	return l.writer || (l.writersWaiting > 0 && l.gen == g)
+}
+
+func (l *fairRWMutex) RUnlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.readers <= 0 {
+		panic("RUnlock of unlocked fairRWMutex")
+	}
+	l.readers--
+	if l.readers == 0 {
+		l.cond.Broadcast()
+	}
+}
+
+func (l *fairRWMutex) Lock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.writerMustWait() {
+		start := time.Now()
+		l.writersWaiting++
+		for l.writerMustWait() {
+			l.cond.Wait()
+		}
+		l.writersWaiting--
+		repoLockWaits.Add("write-waits", 1)
+		repoLockWaits.Add("write-wait-ns", int64(time.Since(start)))
+	}
+	l.writer = true
+}
+
+// TryLock takes the write lock if it is available without waiting,
+// and returns whether it did.
+func (l *fairRWMutex) TryLock() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.writerMustWait() || l.writersWaiting > 0 {
+		return false
+	}
+	l.writer = true
+	return true
+}
+
+// writerMustWait returns whether a writer must wait. The caller must
+// hold l.mu.
+func (l *fairRWMutex) writerMustWait() bool {
+	return l.writer || l.readers > 0 || l.admitted > 0
+}
+
+func (l *fairRWMutex) Unlock() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseWriter()
+}
+
+// Downgrade atomically converts the write lock held by the caller to a
+// read lock.
+func (l *fairRWMutex) Downgrade() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseWriter()
+	l.readers++
+}
+
+// releaseWriter releases the write lock and admits the waiting
+// readers. The caller must hold l.mu.
+func (l *fairRWMutex) releaseWriter() {
+	if !l.writer {
+		panic("Unlock of unlocked fairRWMutex")
+	}
+	l.writer = false
+	l.gen++
+	l.admitted = l.readersWaiting
+	l.cond.Broadcast()
+}
+
+// LockRepo takes the repository's read lock (or its write lock, if
+// exclusive is true) and returns a func that releases it. It is for
+// callers that access the repository's clone dir directly instead of
+// through a handle (such as the git transport).
+func (s *service) LockRepo(repoPath string, exclusive bool) (unlock func(), err error) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	mu := s.Mutex(repoKey{cloneDir})
+	if exclusive {
+		mu.Lock()
+		return mu.Unlock, nil
+	}
+	mu.RLock()
+	return mu.RUnlock, nil
+}
@@ -0,0 +1,128 @@
+package vcsstore
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// blocked returns whether f is still blocked after a short time. If
+// it is, f is left running.
+func blocked(f func()) (done <-chan struct{}, isBlocked bool) {
+	c := make(chan struct{})
+	go func() {
+		f()
+		close(c)
+	}()
+	select {
+	case <-c:
+		return c, false
+	case <-time.After(50 * time.Millisecond):
+		return c, true
+	}
+}
+
+func TestFairRWMutex_writerNotStarved(t *testing.T) {
+	l := newFairRWMutex()
+	l.RLock()
+
+	locked, isBlocked := blocked(l.Lock)
+	if !isBlocked {
+		t.Fatal("Lock did not wait for reader")
+	}
+
+	// New readers must wait for the waiting writer.
+	rlocked, isBlocked := blocked(l.RLock)
+	if !isBlocked {
+		t.Fatal("RLock did not wait for waiting writer")
+	}
+
+	l.RUnlock()
+	<-locked
+	l.Unlock()
+	<-rlocked
+	l.RUnlock()
+}
+
+func TestFairRWMutex_readersNotStarved(t *testing.T) {
+	l := newFairRWMutex()
+	l.Lock()
+
+	rlocked, isBlocked := blocked(l.RLock)
+	if !isBlocked {
+		t.Fatal("RLock did not wait for writer")
+	}
+	locked, isBlocked := blocked(l.Lock)
+	if !isBlocked {
+		t.Fatal("Lock did not wait for writer")
+	}
+
+	// The reader that was waiting before the second writer must be
+	// admitted first.
+	l.Unlock()
+	<-rlocked
+	select {
+	case <-locked:
+		t.Fatal("second writer was admitted before waiting reader")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.RUnlock()
+	<-locked
+	l.Unlock()
+}
+
+func TestFairRWMutex_TryLock(t *testing.T) {
+	l := newFairRWMutex()
+	l.RLock()
+	if l.TryLock() {
+		t.Fatal("TryLock succeeded while read-locked")
+	}
+	l.RUnlock()
+	if !l.TryLock() {
+		t.Fatal("TryLock failed while unlocked")
+	}
+	l.Unlock()
+}
+
+func TestFairRWMutex_Downgrade(t *testing.T) {
+	l := newFairRWMutex()
+	l.Lock()
+	l.Downgrade()
+	if _, isBlocked := blocked(l.RLock); isBlocked {
+		t.Fatal("RLock waited for downgraded lock")
+	}
+	locked, isBlocked := blocked(l.Lock)
+	if !isBlocked {
+		t.Fatal("Lock did not wait for downgraded lock")
+	}
+	l.RUnlock()
+	l.RUnlock()
+	<-locked
+	l.Unlock()
+}
+
+func TestService_Update_waitsForReaders(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+	remoteDir := initRemoteGitRepo(t)
+	defer os.RemoveAll(remoteDir)
+
+	h, err := s.Clone("a.com/x", &vcsclient.CloneInfo{VCS: "git", CloneURL: remoteDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updated, isBlocked := blocked(func() {
+		if _, err := s.Update("a.com/x", &vcsclient.CloneInfo{}); err != nil {
+			t.Error(err)
+		}
+	})
+	if !isBlocked {
+		t.Fatal("Update did not wait for reader to release its handle")
+	}
+	h.Release()
+	<-updated
+}
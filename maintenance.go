@@ -0,0 +1,478 @@
+package vcsstore
+
+import (
+	"expvar"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	maintenanceRuns  = expvar.NewInt("vcsstore.maintenance-runs")
+	maintenanceFails = expvar.NewInt("vcsstore.maintenance-failures")
+)
+
+const (
+	// defaultMaintenanceMaxLooseObjects is used if
+	// Config.MaintenanceMaxLooseObjects is unset. It is git's default
+	// gc.auto.
+	defaultMaintenanceMaxLooseObjects = 6700
+
+	// defaultMaintenanceMaxPacks is used if Config.MaintenanceMaxPacks
+	// is unset. It is git's default gc.autoPackLimit.
+	defaultMaintenanceMaxPacks = 50
+)
+
+// The maintenance tasks, in the order they are run.
+const (
+	taskPackRefs    = "pack-refs"
+	taskRepack      = "repack"
+	taskPrune       = "prune"
+	taskCommitGraph = "commit-graph"
+)
+
+// MaintenanceStatus describes the object storage of a git repository
+// when the maintainer last checked it.
+type MaintenanceStatus struct {
+	RepoPath string
+
+	// LooseObjects and Packs are the numbers of loose objects and of
+	// packs in the repository.
+	LooseObjects int
+	Packs        int
+
+	// CommitGraph is whether the repository's commit-graph file is up
+	// to date.
+	CommitGraph bool
+
+	// Checked is when the repository was checked.
+	Checked time.Time
+
+	// LastMaintained is when maintenance tasks last ran on the
+	// repository, and LastError is the error of the last task that
+	// failed then (if any).
+	LastMaintained time.Time `json:",omitempty"`
+	LastError      string    `json:",omitempty"`
+}
+
+// MaintenanceOptions specifies which repositories Maintain maintains.
+type MaintenanceOptions struct {
+	// RepoPath is the repository to maintain. If empty, all git
+	// repositories and object pools are maintained.
+	RepoPath string
+
+	// Force runs all maintenance tasks, even on repositories that are
+	// below the maintenance thresholds.
+	Force bool
+}
+
+// A MaintenanceResult describes the maintenance of a repository (or of
+// an object pool).
+type MaintenanceResult struct {
+	RepoPath string `json:",omitempty"`
+
+	// Pool is the name of the object pool that was maintained, if it
+	// was a pool and not a repository.
+	Pool string `json:",omitempty"`
+
+	// Tasks are the maintenance tasks that ran.
+	Tasks []string
+
+	// The numbers of loose objects and of packs before and after the
+	// tasks ran.
+	LooseObjectsBefore, LooseObjectsAfter int
+	PacksBefore, PacksAfter               int
+
+	Duration time.Duration
+
+	// Error is the error of the task that failed, if any. The tasks
+	// after it didn't run.
+	Error string `json:",omitempty"`
+}
+
+// runMaintainer periodically maintains all git repositories and
+// object pools.
+func (s *service) runMaintainer() {
+	ticker := time.NewTicker(s.MaintenanceInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.closed:
+			return
+		}
+		results, err := s.Maintain(MaintenanceOptions{})
+		if err != nil {
+			s.Log.Printf("Maintenance failed: %s.", err)
+			continue
+		}
+		if len(results) > 0 {
+			s.Log.Printf("Maintenance ran on %d repositories and pools.", len(results))
+		}
+	}
+}
+
+// Maintain runs maintenance tasks (such as gc, repacking, and writing
+// the commit-graph) on the git repository opt.RepoPath, or on all git
+// repositories and object pools if it is empty. Unless opt.Force is
+// set, only the tasks that a repository needs are run: a repository
+// with too many loose objects or packs (see
+// Config.MaintenanceMaxLooseObjects and MaintenanceMaxPacks) is
+// gc'd, and one whose commit-graph is out of date gets a new
+// commit-graph. The tasks run while holding the repository's write
+// lock.
+//
+// It returns the results for the repositories and pools that tasks
+// ran on.
+func (s *service) Maintain(opt MaintenanceOptions) ([]*MaintenanceResult, error) {
+	results := []*MaintenanceResult{}
+	if opt.RepoPath != "" {
+		r, err := s.maintainRepo(s.CanonicalRepoPath(opt.RepoPath), opt.Force)
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			results = append(results, r)
+		}
+		return results, nil
+	}
+
+	var repoPaths []string
+	err := walkRepositories(s.StorageDir, "", func(repoPath, cloneDir, vcsType string) error {
+		if vcsType == "git" {
+			repoPaths = append(repoPaths, repoPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, repoPath := range repoPaths {
+		r, err := s.maintainRepo(repoPath, opt.Force)
+		if os.IsNotExist(err) {
+			// Removed meanwhile.
+			continue
+		} else if err != nil {
+			r = &MaintenanceResult{RepoPath: repoPath, Error: err.Error()}
+		}
+		if r != nil {
+			results = append(results, r)
+		}
+	}
+
+	poolResults, err := s.maintainPools(opt.Force)
+	if err != nil {
+		return nil, err
+	}
+	return append(results, poolResults...), nil
+}
+
+// maintainRepo runs the maintenance tasks that the repository at
+// repoPath needs (or all of them, if force is set). If it needs none,
+// the result is nil.
+func (s *service) maintainRepo(repoPath string, force bool) (*MaintenanceResult, error) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	vcsType, err := vcsTypeFromDir(cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	if vcsType != "git" {
+		return nil, fmt.Errorf("maintenance not yet implemented for VCS %q", vcsType)
+	}
+
+	// Check without the lock first, so that repositories that don't
+	// need maintenance don't block their readers.
+	st, err := s.checkRepo(repoPath, cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	if tasks := s.maintenanceTasks(st, force); len(tasks) == 0 {
+		return nil, nil
+	}
+
+	mu := s.Mutex(repoKey{cloneDir})
+	mu.Lock()
+	defer mu.Unlock()
+	if _, err := vcsTypeFromDir(cloneDir); err != nil {
+		return nil, err
+	}
+	if st, err = s.checkRepo(repoPath, cloneDir); err != nil {
+		return nil, err
+	}
+	tasks := s.maintenanceTasks(st, force)
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+
+	r := &MaintenanceResult{RepoPath: repoPath, LooseObjectsBefore: st.LooseObjects, PacksBefore: st.Packs}
+	start := time.Now()
+	s.runMaintenanceTasks(cloneDir, tasks, r)
+	r.Duration = time.Since(start)
+
+	st, err = s.checkRepo(repoPath, cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	r.LooseObjectsAfter, r.PacksAfter = st.LooseObjects, st.Packs
+	s.maintenanceMu.Lock()
+	st.LastMaintained, st.LastError = start, r.Error
+	s.maintenanceMu.Unlock()
+	return r, nil
+}
+
+// maintenanceTasks returns the tasks that a repository whose status is
+// st needs (or all tasks, if force is set).
+func (s *service) maintenanceTasks(st *MaintenanceStatus, force bool) []string {
+	maxLoose := s.MaintenanceMaxLooseObjects
+	if maxLoose == 0 {
+		maxLoose = defaultMaintenanceMaxLooseObjects
+	}
+	maxPacks := s.MaintenanceMaxPacks
+	if maxPacks == 0 {
+		maxPacks = defaultMaintenanceMaxPacks
+	}
+
+	switch {
+	case force || st.LooseObjects >= maxLoose || st.Packs >= maxPacks:
+		return []string{taskPackRefs, taskRepack, taskPrune, taskCommitGraph}
+	case !st.CommitGraph:
+		return []string{taskCommitGraph}
+	}
+	return nil
+}
+
+// runMaintenanceTasks runs tasks in the git repository in dir, and
+// records them in r. It stops at the first task that fails.
+func (s *service) runMaintenanceTasks(dir string, tasks []string, r *MaintenanceResult) {
+	maintenanceRuns.Add(1)
+	for _, task := range tasks {
+		var args []string
+		switch task {
+		case taskPackRefs:
+			args = []string{"pack-refs", "--all", "--prune"}
+		case taskRepack:
+			// Objects that the repository borrows from an object pool
+			// are left out (-l).
+			args = []string{"repack", "-a", "-d", "-l", "-q"}
+		case taskPrune:
+			// Unreachable objects can be pruned right away, since
+			// nobody is fetching into the repository.
+			args = []string{"prune", "--expire=now"}
+		case taskCommitGraph:
+			args = []string{"commit-graph", "write", "--reachable"}
+		}
+		if err := runGitIn(dir, args...); err != nil {
+			maintenanceFails.Add(1)
+			r.Error = fmt.Sprintf("%s: %s", task, err)
+			s.Log.Printf("Maintenance of %s failed: %s.", dir, r.Error)
+			return
+		}
+		r.Tasks = append(r.Tasks, task)
+	}
+}
+
+// checkRepo counts the objects of the git repository at repoPath and
+// records its status.
+func (s *service) checkRepo(repoPath, cloneDir string) (*MaintenanceStatus, error) {
+	loose, packs, err := countObjects(cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	st := &MaintenanceStatus{
+		RepoPath:     repoPath,
+		LooseObjects: loose,
+		Packs:        packs,
+		CommitGraph:  commitGraphFresh(cloneDir),
+		Checked:      time.Now(),
+	}
+
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+	if prev, ok := s.maintenance[repoPath]; ok {
+		st.LastMaintained, st.LastError = prev.LastMaintained, prev.LastError
+	}
+	s.maintenance[repoPath] = st
+	return st, nil
+}
+
+// forgetMaintenance forgets the maintenance status of the repo
+// (because it was removed).
+func (s *service) forgetMaintenance(repoPath string) {
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+	delete(s.maintenance, repoPath)
+}
+
+// MaintenanceStatuses returns the status of each git repository that
+// the maintainer has checked, sorted by repository path.
+func (s *service) MaintenanceStatuses() []*MaintenanceStatus {
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+	statuses := make([]*MaintenanceStatus, 0, len(s.maintenance))
+	for _, st := range s.maintenance {
+		copy := *st
+		statuses = append(statuses, &copy)
+	}
+	sort.Sort(maintenanceStatusesByRepoPath(statuses))
+	return statuses
+}
+
+type maintenanceStatusesByRepoPath []*MaintenanceStatus
+
+func (v maintenanceStatusesByRepoPath) Len() int           { return len(v) }
+func (v maintenanceStatusesByRepoPath) Less(i, j int) bool { return v[i].RepoPath < v[j].RepoPath }
+func (v maintenanceStatusesByRepoPath) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+// maintainPools gc's the object pools that need it (or all of them, if
+// force is set). Before a pool is gc'd, the refs of all of its members
+// are fetched into it again, so that no object that a member borrows
+// is removed. Pools that forks are joining are skipped.
+func (s *service) maintainPools(force bool) ([]*MaintenanceResult, error) {
+	pools, err := s.Pools()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := s.poolsRoot()
+	if err != nil {
+		return nil, err
+	}
+	var results []*MaintenanceResult
+	for _, p := range pools {
+		poolDir := filepath.Join(root, p.Name)
+		r, err := s.maintainPool(poolDir, force)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			r = &MaintenanceResult{Pool: p.Name, Error: err.Error()}
+		}
+		if r != nil {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+func (s *service) maintainPool(poolDir string, force bool) (*MaintenanceResult, error) {
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+	if s.poolJoins[poolDir] > 0 {
+		return nil, nil
+	}
+
+	loose, packs, err := countObjects(poolDir)
+	if err != nil {
+		return nil, err
+	}
+	st := &MaintenanceStatus{LooseObjects: loose, Packs: packs, CommitGraph: true}
+	if tasks := s.maintenanceTasks(st, force); len(tasks) == 0 {
+		return nil, nil
+	}
+
+	// Hold the read locks of the members, so that none of them is
+	// updated (and starts referring to objects that are unreachable
+	// in the pool) while unreachable objects are removed from the
+	// pool. They are locked in order of their clone dirs, as other
+	// callers that hold several repos' locks do.
+	pi, err := readPoolInfo(poolDir)
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]string, len(pi.Members)) // clone dir -> repo path
+	cloneDirs := make([]string, 0, len(pi.Members))
+	for _, m := range pi.Members {
+		cloneDir, err := s.CloneDir(m)
+		if err != nil {
+			continue
+		}
+		if _, dup := members[cloneDir]; dup {
+			continue
+		}
+		members[cloneDir] = m
+		cloneDirs = append(cloneDirs, cloneDir)
+	}
+	sort.Strings(cloneDirs)
+	for _, cloneDir := range cloneDirs {
+		m := members[cloneDir]
+		mu := s.Mutex(repoKey{cloneDir})
+		mu.RLock()
+		defer mu.RUnlock()
+		if _, err := vcsTypeFromDir(cloneDir); err != nil {
+			continue
+		}
+		if p, err := s.repoPool(cloneDir); err != nil || p != poolDir {
+			continue
+		}
+		if err := s.addPoolMember(poolDir, m, cloneDir); err != nil {
+			return nil, fmt.Errorf("fetching the refs of %s into the pool failed: %s", m, err)
+		}
+	}
+
+	r := &MaintenanceResult{Pool: filepath.Base(poolDir), LooseObjectsBefore: loose, PacksBefore: packs}
+	start := time.Now()
+	s.runMaintenanceTasks(poolDir, []string{taskPackRefs, taskRepack, taskPrune}, r)
+	r.Duration = time.Since(start)
+	if r.LooseObjectsAfter, r.PacksAfter, err = countObjects(poolDir); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// countObjects returns the numbers of loose objects and of packs in the
+// git repository in dir.
+func countObjects(dir string) (loose, packs int, err error) {
+	counts, err := gitCountObjects(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	return counts["count"], counts["packs"], nil
+}
+
+// gitCountObjects returns the numeric fields (such as "count",
+// "in-pack", and "packs") output by git count-objects -v in dir.
+func gitCountObjects(dir string) (map[string]int, error) {
+	cmd := exec.Command("git", "count-objects", "-v")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git count-objects failed: %s", err)
+	}
+	counts := map[string]int{}
+	for _, line := range strings.Split(string(out), "\n") {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 || parts[0] == "alternate" {
+			continue
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("git count-objects: bad %s: %q", parts[0], parts[1])
+		}
+		counts[parts[0]] = n
+	}
+	return counts, nil
+}
+
+// commitGraphFresh returns whether the git repository in dir has a
+// commit-graph file that was written after it was last fetched into.
+func commitGraphFresh(dir string) bool {
+	objectsDir := gitObjectsDir(dir)
+	var graph time.Time
+	for _, name := range []string{"commit-graph", "commit-graphs/commit-graph-chain"} {
+		if fi, err := os.Stat(filepath.Join(objectsDir, "info", filepath.FromSlash(name))); err == nil && fi.ModTime().After(graph) {
+			graph = fi.ModTime()
+		}
+	}
+	if graph.IsZero() {
+		return false
+	}
+	return !lastFetchTime(dir, "git").After(graph)
+}
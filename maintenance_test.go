@@ -0,0 +1,75 @@
+package vcsstore
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func TestService_Maintain(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+	s.MaintenanceMaxLooseObjects = 1
+
+	remote := initRemoteGitRepo(t)
+	defer os.RemoveAll(remote)
+	// A file:// URL makes git transfer a pack instead of copying the
+	// remote's loose objects.
+	cloneInfo := &vcsclient.CloneInfo{VCS: "git", CloneURL: "file://" + remote}
+	h, err := s.Clone("a.com/r", cloneInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+
+	maintain := func(opt MaintenanceOptions) []*MaintenanceResult {
+		results, err := s.Maintain(opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range results {
+			if r.Error != "" {
+				t.Fatalf("maintenance of %s failed: %s", r.RepoPath, r.Error)
+			}
+		}
+		return results
+	}
+
+	// A new clone only needs a commit-graph.
+	results := maintain(MaintenanceOptions{})
+	if len(results) != 1 || !reflect.DeepEqual(results[0].Tasks, []string{taskCommitGraph}) {
+		t.Fatalf("got results %+v, want only a commit-graph write", results)
+	}
+	if results := maintain(MaintenanceOptions{}); len(results) != 0 {
+		t.Errorf("got results %+v, want none after the commit-graph was written", results)
+	}
+
+	// Updates leave loose objects behind, which are packed by gc.
+	gitOutput(t, remote, "-c", "user.name=a", "-c", "user.email=a@a.com", "commit", "--allow-empty", "-m", "y")
+	if _, err := s.Update("a.com/r", cloneInfo); err != nil {
+		t.Fatal(err)
+	}
+	results = maintain(MaintenanceOptions{RepoPath: "a.com/r"})
+	if len(results) != 1 {
+		t.Fatalf("got results %+v, want 1", results)
+	}
+	r := results[0]
+	if want := []string{taskPackRefs, taskRepack, taskPrune, taskCommitGraph}; !reflect.DeepEqual(r.Tasks, want) {
+		t.Errorf("got tasks %v, want %v", r.Tasks, want)
+	}
+	if r.LooseObjectsBefore == 0 || r.LooseObjectsAfter != 0 || r.PacksAfter != 1 {
+		t.Errorf("got result %+v, want loose objects packed into 1 pack", r)
+	}
+
+	statuses := s.MaintenanceStatuses()
+	if len(statuses) != 1 || statuses[0].RepoPath != "a.com/r" || statuses[0].LastMaintained.IsZero() || !statuses[0].CommitGraph {
+		t.Errorf("got statuses %+v", statuses)
+	}
+
+	if results := maintain(MaintenanceOptions{RepoPath: "a.com/r", Force: true}); len(results) != 1 || len(results[0].Tasks) != 4 {
+		t.Errorf("got forced results %+v, want all tasks", results)
+	}
+}
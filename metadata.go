@@ -0,0 +1,143 @@
+package vcsstore
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// metadataFile is the name of the file in each clone dir that holds
+// the repo's metadata.
+const metadataFile = ".vcsstore-metadata.json"
+
+// metadataAccessResolution is how often a repo's LastAccessed time is
+// persisted while it's being read, so that reads don't each write the
+// metadata file.
+const metadataAccessResolution = 10 * time.Minute
+
+// readMetadata reads the metadata of the repo in cloneDir. If the repo
+// has no metadata (e.g., because it was cloned by an older version of
+// vcsstore), an os.ErrNotExist-satisfying error is returned.
+func readMetadata(cloneDir string) (*vcsclient.RepositoryMetadata, error) {
+	data, err := ioutil.ReadFile(filepath.Join(cloneDir, metadataFile))
+	if err != nil {
+		return nil, err
+	}
+	var md *vcsclient.RepositoryMetadata
+	if err := json.Unmarshal(data, &md); err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// writeMetadata "atomically" writes the metadata of the repo in
+// cloneDir, so that readers never see a partially written file.
+func writeMetadata(cloneDir string, md *vcsclient.RepositoryMetadata) error {
+	data, err := json.MarshalIndent(md, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(cloneDir, metadataFile+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(cloneDir, metadataFile))
+}
+
+// updateMetadata calls f with the metadata of the repo in cloneDir (or
+// new metadata, if it has none) and writes the result.
+func (s *service) updateMetadata(cloneDir string, f func(md *vcsclient.RepositoryMetadata)) error {
+	s.metadataMu.Lock()
+	defer s.metadataMu.Unlock()
+
+	md, err := readMetadata(cloneDir)
+	if os.IsNotExist(err) {
+		md = &vcsclient.RepositoryMetadata{}
+		md.VCS, _ = vcsTypeFromDir(cloneDir)
+	} else if err != nil {
+		return err
+	}
+	f(md)
+	return writeMetadata(cloneDir, md)
+}
+
+// recordFetchMetadata records the outcome of an update of the repo in
+// cloneDir that started at start.
+func (s *service) recordFetchMetadata(cloneDir string, cloneInfo *vcsclient.CloneInfo, start time.Time, fetchErr error) {
+	err := s.updateMetadata(cloneDir, func(md *vcsclient.RepositoryMetadata) {
+		if md.CloneURL == "" && cloneInfo.CloneURL != "" {
+			md.CloneURL = redactCloneURL(cloneInfo.CloneURL)
+		}
+		if fetchErr != nil {
+			md.LastError = fetchErr.Error()
+			md.LastErrorTime = time.Now()
+		} else {
+			md.LastFetched = time.Now()
+			md.FetchDuration = time.Since(start)
+			md.LastError, md.LastErrorTime = "", time.Time{}
+		}
+	})
+	if err != nil {
+		s.Log.Printf("Recording metadata of update of %s failed: %s.", cloneDir, err)
+	}
+}
+
+// recordAccessMetadata persists the time that the repo in cloneDir
+// was last read.
+func (s *service) recordAccessMetadata(cloneDir string) {
+	err := s.updateMetadata(cloneDir, func(md *vcsclient.RepositoryMetadata) {
+		md.LastAccessed = time.Now()
+	})
+	if err != nil && !os.IsNotExist(err) {
+		s.Log.Printf("Recording access time of %s failed: %s.", cloneDir, err)
+	}
+}
+
+// Metadata returns the metadata of the repository. The LastAccessed
+// time includes reads that haven't been persisted yet. If the
+// repository has no metadata, an os.ErrNotExist-satisfying error is
+// returned.
+func (s *service) Metadata(repoPath string) (*vcsclient.RepositoryMetadata, error) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s.metadataMu.Lock()
+	md, err := readMetadata(cloneDir)
+	s.metadataMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := s.lastRead(repoPath); t.After(md.LastAccessed) {
+		md.LastAccessed = t
+	}
+	return md, nil
+}
+
+// redactCloneURL removes the password (if any) from cloneURL, so that
+// it isn't persisted.
+func redactCloneURL(cloneURL string) string {
+	u, err := url.Parse(cloneURL)
+	if err != nil || u.User == nil {
+		return cloneURL
+	}
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.User(u.User.Username())
+	}
+	return u.String()
+}
@@ -0,0 +1,277 @@
+package vcsstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A LayoutMigration describes a completed migration of StorageDir to a
+// new layout.
+type LayoutMigration struct {
+	From, To Layout
+
+	// Moved is the number of repositories that were moved.
+	Moved int
+}
+
+// MigrateLayout moves the repositories in StorageDir to layout to,
+// which must be a version 1 layout. Only migrations from the legacy
+// layout are supported.
+//
+// Repositories remain readable (and can be cloned and updated) during
+// the migration. Each repository is moved while holding its write
+// lock, and a symlink to its new clone dir is left at its old one
+// until all repositories have been moved, for readers that looked it
+// up before it was moved. Until the migration is done, StorageDir's
+// layout file records both layouts, so repositories that haven't been
+// moved yet are still found (by this and other processes). If the
+// migration is interrupted, calling MigrateLayout again resumes it.
+func (s *service) MigrateLayout(to Layout) (*LayoutMigration, error) {
+	if err := to.validate(); err != nil {
+		return nil, err
+	}
+	if to.Version != 1 {
+		return nil, fmt.Errorf("can't migrate to layout %s", to)
+	}
+
+	s.migrateMu.Lock()
+	defer s.migrateMu.Unlock()
+
+	sl, err := readStorageLayout(s.StorageDir)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case sl.MigratingFrom != nil:
+		if sl.Layout != to {
+			return nil, fmt.Errorf("a migration from layout %s to %s is in progress", *sl.MigratingFrom, sl.Layout)
+		}
+		s.Log.Printf("Resuming the migration of %s from layout %s to %s.", s.StorageDir, *sl.MigratingFrom, to)
+	case sl.Layout == to:
+		return &LayoutMigration{From: to, To: to}, nil
+	case sl.Layout != LegacyLayout:
+		return nil, fmt.Errorf("can't migrate from layout %s to %s", sl.Layout, to)
+	default:
+		from := sl.Layout
+		sl = &storageLayout{Layout: to, MigratingFrom: &from}
+		if err := writeStorageLayout(s.StorageDir, sl); err != nil {
+			return nil, err
+		}
+	}
+	m := &LayoutMigration{From: *sl.MigratingFrom, To: to}
+
+	start := time.Now()
+	s.Log.Printf("Migrating %s from layout %s to %s...", s.StorageDir, m.From, to)
+
+	// List the repositories first, since moving them while walking
+	// the old layout would confuse the walk.
+	oldDirs := map[string]string{}
+	err = walkLayout(s.StorageDir, m.From, "", func(repoPath, cloneDir, vcsType string) error {
+		oldDirs[repoPath] = cloneDir
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for repoPath, oldDir := range oldDirs {
+		moved, err := s.moveRepo(repoPath, oldDir, to)
+		if err != nil {
+			return nil, fmt.Errorf("moving repository %s failed: %s", repoPath, err)
+		}
+		if moved {
+			m.Moved++
+			if m.Moved%1000 == 0 {
+				s.Log.Printf("Moved %d of %d repositories to layout %s.", m.Moved, len(oldDirs), to)
+			}
+		}
+	}
+
+	if err := s.removeLegacyLinks(to); err != nil {
+		return nil, err
+	}
+	if err := writeStorageLayout(s.StorageDir, &storageLayout{Layout: to}); err != nil {
+		return nil, err
+	}
+	s.Log.Printf("Migrated %s to layout %s (moved %d repositories) in %s.", s.StorageDir, to, m.Moved, time.Since(start))
+	return m, nil
+}
+
+// moveRepo moves the repository from oldDir to its clone dir in layout
+// to, and leaves a symlink to it at oldDir. If the repository is no
+// longer at oldDir (e.g., because it was removed), moved is false.
+func (s *service) moveRepo(repoPath, oldDir string, to Layout) (moved bool, err error) {
+	newDir, err := to.cloneDir(s.StorageDir, repoPath)
+	if err != nil {
+		return false, err
+	}
+	newKey, oldKey := repoKey{newDir}, repoKey{oldDir}
+
+	// During the migration, Mutex returns the same lock for the old
+	// and new clone dirs, but callers that got the old clone dir's
+	// lock before the migration started may still hold it.
+	mu := s.Mutex(newKey)
+	mu.Lock()
+	defer mu.Unlock()
+	s.repoMuMu.Lock()
+	oldMu := s.repoMu[oldKey]
+	s.repoMuMu.Unlock()
+	if oldMu != nil && oldMu != mu {
+		oldMu.Lock()
+		defer oldMu.Unlock()
+	}
+
+	if fi, err := os.Lstat(oldDir); os.IsNotExist(err) || (err == nil && !fi.IsDir()) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if _, err := os.Lstat(newDir); err == nil {
+		return false, fmt.Errorf("%s already exists", newDir)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newDir), 0700); err != nil {
+		return false, err
+	}
+	if err := os.Rename(oldDir, newDir); err != nil {
+		return false, err
+	}
+	if target, err := filepath.Rel(filepath.Dir(oldDir), newDir); err == nil {
+		err = os.Symlink(target, oldDir)
+		if err != nil {
+			// Readers that look up the repository from now on will
+			// find it at newDir.
+			s.Log.Printf("Creating symlink from %s to %s failed: %s.", oldDir, newDir, err)
+		}
+	}
+
+	// Nobody has a handle to the repository (since we hold its write
+	// lock), so close the instance that was opened at oldDir and carry
+	// its access and fetch times over to newDir.
+	s.repoMuMu.Lock()
+	closed := s.repos[oldKey]
+	delete(s.repos, oldKey)
+	if t, ok := s.lastAccess[oldKey]; ok {
+		s.lastAccess[newKey] = t
+		delete(s.lastAccess, oldKey)
+	}
+	if t, ok := s.persistedAccess[oldKey]; ok {
+		s.persistedAccess[newKey] = t
+		delete(s.persistedAccess, oldKey)
+	}
+	s.repoMuMu.Unlock()
+	s.fetchesMu.Lock()
+	if t, ok := s.lastFetched[oldKey]; ok {
+		s.lastFetched[newKey] = t
+		delete(s.lastFetched, oldKey)
+	}
+	s.fetchesMu.Unlock()
+	if closed != nil {
+		closeRepo(closed.repo)
+	}
+
+	s.debugLogf("MigrateLayout: moved %s from %s to %s", repoPath, oldDir, newDir)
+	return true, nil
+}
+
+// removeLegacyLinks removes the symlinks that moveRepo left in the
+// legacy layout, and the dirs that are left empty.
+func (s *service) removeLegacyLinks(to Layout) error {
+	storageDir := filepath.Clean(s.StorageDir)
+	var dirs []string
+	err := filepath.Walk(storageDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == storageDir {
+			return nil
+		}
+		if fi.IsDir() && strings.HasPrefix(fi.Name(), "_tmp_") {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(storageDir, path)
+		if err != nil {
+			return err
+		}
+		repoPath, ok := LegacyLayout.repoPathFromDir(filepath.ToSlash(rel))
+		if !ok {
+			return filepath.SkipDir
+		}
+		if fi.IsDir() {
+			if _, err := vcsTypeFromDir(path); err == nil {
+				// A repository that wasn't moved.
+				return filepath.SkipDir
+			}
+			dirs = append(dirs, path)
+			return nil
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return s.removeLegacyLink(repoPath, path, to)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remove the dirs that are now empty, children before parents.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+	return nil
+}
+
+// removeLegacyLink removes the symlink at oldDir that moveRepo left
+// for the repository, once nobody is using it.
+func (s *service) removeLegacyLink(repoPath, oldDir string, to Layout) error {
+	newDir, err := to.cloneDir(s.StorageDir, repoPath)
+	if err != nil {
+		return err
+	}
+	mu := s.Mutex(repoKey{newDir})
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.Remove(oldDir); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	// Readers that looked up the repository before it was moved may
+	// have opened it at oldDir.
+	oldKey := repoKey{oldDir}
+	s.repoMuMu.Lock()
+	closed := s.repos[oldKey]
+	delete(s.repos, oldKey)
+	delete(s.repoMu, oldKey)
+	s.repoMuMu.Unlock()
+	if closed != nil {
+		closeRepo(closed.repo)
+	}
+	return nil
+}
+
+// lockKey returns the key of the lock of the repository whose clone
+// dir is key.cloneDir. While StorageDir is being migrated to a new
+// layout, a repository that hasn't been moved yet has the same lock as
+// it will have after it is moved.
+func (s *service) lockKey(key repoKey) repoKey {
+	sl, err := readStorageLayout(s.StorageDir)
+	if err != nil || sl.MigratingFrom == nil {
+		return key
+	}
+	rel, err := filepath.Rel(s.StorageDir, key.cloneDir)
+	if err != nil {
+		return key
+	}
+	repoPath, ok := sl.MigratingFrom.repoPathFromDir(filepath.ToSlash(rel))
+	if !ok {
+		return key
+	}
+	newDir, err := sl.Layout.cloneDir(s.StorageDir, repoPath)
+	if err != nil {
+		return key
+	}
+	return repoKey{newDir}
+}
@@ -0,0 +1,142 @@
+package vcsstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func TestService_MigrateLayout(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	for _, repoPath := range []string{"a.com/x", "b.com/y/z"} {
+		initBareGitRepo(t, s, repoPath)
+	}
+
+	// The migration must wait for readers of a repository before
+	// moving it.
+	h, err := s.Acquire("a.com/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m *LayoutMigration
+	migrated, isBlocked := blocked(func() {
+		m, err = s.MigrateLayout(DefaultLayout)
+	})
+	if !isBlocked {
+		t.Fatal("MigrateLayout did not wait for reader")
+	}
+
+	// Both repositories are available during the migration,
+	// regardless of whether they have been moved yet.
+	sl, err := readStorageLayout(storageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.Layout != DefaultLayout || sl.MigratingFrom == nil || *sl.MigratingFrom != LegacyLayout {
+		t.Errorf("got layout %+v during migration", sl)
+	}
+	if _, total, err := s.ListRepositories(vcsclient.RepositoryListOptions{}); err != nil {
+		t.Fatal(err)
+	} else if total != 2 {
+		t.Errorf("got %d repositories during migration, want 2", total)
+	}
+	if _, err := vcsTypeFromDir(mustCloneDir(t, s, "b.com/y/z")); err != nil {
+		t.Errorf("b.com/y/z during migration: %s", err)
+	}
+
+	h.Release()
+	<-migrated
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Moved != 2 {
+		t.Errorf("got %d moved repositories, want 2", m.Moved)
+	}
+
+	sl, err = readStorageLayout(storageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sl.Layout != DefaultLayout || sl.MigratingFrom != nil {
+		t.Errorf("got layout %+v after migration", sl)
+	}
+	for _, repoPath := range []string{"a.com/x", "b.com/y/z"} {
+		cloneDir, err := DefaultLayout.cloneDir(storageDir, repoPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := mustCloneDir(t, s, repoPath); got != cloneDir {
+			t.Errorf("%s: got clone dir %s, want %s", repoPath, got, cloneDir)
+		}
+		h, err := s.Acquire(repoPath)
+		if err != nil {
+			t.Errorf("%s: Acquire after migration: %s", repoPath, err)
+			continue
+		}
+		h.Release()
+	}
+
+	// The legacy dirs (and the symlinks left in them) are removed.
+	for _, name := range []string{"a.com", "b.com"} {
+		if _, err := os.Lstat(filepath.Join(storageDir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s: got Lstat error %v after migration, want not exist", name, err)
+		}
+	}
+
+	// Migrating again does nothing.
+	m, err = s.MigrateLayout(DefaultLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Moved != 0 {
+		t.Errorf("got %d moved repositories on 2nd migration, want 0", m.Moved)
+	}
+	if _, err := s.MigrateLayout(Layout{Version: 1, FanOut: 1}); err == nil {
+		t.Error("got no error migrating to another version 1 layout")
+	}
+}
+
+func TestService_MigrateLayout_cloneDuringMigration(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	oldDir := initBareGitRepo(t, s, "a.com/x")
+	if err := writeStorageLayout(storageDir, &storageLayout{Layout: DefaultLayout, MigratingFrom: &LegacyLayout}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Repositories that haven't been moved yet are found in the old
+	// layout, and new repositories are stored in the new layout.
+	if got := mustCloneDir(t, s, "a.com/x"); got != oldDir {
+		t.Errorf("got clone dir %s for unmoved repository, want %s", got, oldDir)
+	}
+	newDir := initBareGitRepo(t, s, "a.com/y")
+	if want, _ := DefaultLayout.cloneDir(storageDir, "a.com/y"); newDir != want {
+		t.Errorf("got clone dir %s for new repository, want %s", newDir, want)
+	}
+
+	// Both are locked by the same lock before and after they're moved.
+	if s.Mutex(repoKey{oldDir}) != s.Mutex(repoKey{mustCloneDir(t, s, "a.com/x")}) {
+		t.Error("unmoved repository's lock differs from its lock in the new layout")
+	}
+
+	// Resume the migration.
+	m, err := s.MigrateLayout(DefaultLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Moved != 1 {
+		t.Errorf("got %d moved repositories, want 1", m.Moved)
+	}
+	if _, total, err := s.ListRepositories(vcsclient.RepositoryListOptions{}); err != nil {
+		t.Fatal(err)
+	} else if total != 2 {
+		t.Errorf("got %d repositories after migration, want 2", total)
+	}
+}
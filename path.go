@@ -1,20 +1,167 @@
 package vcsstore
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	pathpkg "path"
 	"path/filepath"
+	"strings"
 )
 
+// EncodeRepositoryPath returns the path (relative to StorageDir) of
+// the repository's clone dir in the legacy layout, which is the
+// cleaned repository path.
 func EncodeRepositoryPath(repoPath string) (path string) {
 	return pathpkg.Clean(repoPath)
 }
 
+// DecodeRepositoryPath returns the repository path of a clone dir in
+// the legacy layout, given its path relative to StorageDir.
 func DecodeRepositoryPath(path string) (repoPath string) {
 	return path
 }
 
+// maxEscapedPathLen is the maximum length of an escaped repository
+// path, which is the most that common filesystems allow in a single
+// file name.
+const maxEscapedPathLen = 255
+
+// EscapeRepositoryPath returns a single file name that represents the
+// repository path. The escaping is reversible by
+// UnescapeRepositoryPath, and repository paths that differ only in
+// case have names that differ other than in case, so they can't
+// collide on case-insensitive filesystems.
+//
+// Lowercase ASCII letters, digits, '-', '.', and '_' are kept as is
+// (except for a leading '.' or '_', so that names are never hidden or
+// mistaken for temporary dirs), '/' becomes '+', an uppercase letter
+// becomes '!' followed by the lowercase letter, and all other bytes
+// become '%' followed by 2 uppercase hex digits. For example,
+// "github.com/Foo/bar" becomes "github.com+!foo+bar".
+func EscapeRepositoryPath(repoPath string) string {
+	const hex = "0123456789ABCDEF"
+	buf := make([]byte, 0, len(repoPath))
+	for i := 0; i < len(repoPath); i++ {
+		c := repoPath[i]
+		switch {
+		case (c == '.' || c == '_') && i == 0:
+			buf = append(buf, '%', hex[c>>4], hex[c&0xF])
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '.', c == '_':
+			buf = append(buf, c)
+		case c >= 'A' && c <= 'Z':
+			buf = append(buf, '!', c+('a'-'A'))
+		case c == '/':
+			buf = append(buf, '+')
+		default:
+			buf = append(buf, '%', hex[c>>4], hex[c&0xF])
+		}
+	}
+	return string(buf)
+}
+
+var errInvalidEscapedPath = errors.New("invalid escaped repository path")
+
+// UnescapeRepositoryPath returns the repository path that name (as
+// returned by EscapeRepositoryPath) represents. Names that
+// EscapeRepositoryPath would not have returned are rejected, so that
+// each repository path has exactly 1 name.
+func UnescapeRepositoryPath(name string) (repoPath string, err error) {
+	buf := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case '!':
+			if i+1 == len(name) || name[i+1] < 'a' || name[i+1] > 'z' {
+				return "", errInvalidEscapedPath
+			}
+			buf = append(buf, name[i+1]-('a'-'A'))
+			i++
+		case '+':
+			buf = append(buf, '/')
+		case '%':
+			if i+2 >= len(name) {
+				return "", errInvalidEscapedPath
+			}
+			hi, lo := unhex(name[i+1]), unhex(name[i+2])
+			if hi < 0 || lo < 0 {
+				return "", errInvalidEscapedPath
+			}
+			buf = append(buf, byte(hi<<4|lo))
+			i += 2
+		default:
+			buf = append(buf, c)
+		}
+	}
+	repoPath = string(buf)
+	if EscapeRepositoryPath(repoPath) != name {
+		return "", errInvalidEscapedPath
+	}
+	return repoPath, nil
+}
+
+// unhex returns the value of the uppercase hex digit c, or -1 if c
+// isn't one.
+func unhex(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'A' && c <= 'F':
+		return int(c - 'A' + 10)
+	}
+	return -1
+}
+
+// cleanRepositoryPath cleans repoPath and checks that it can be
+// stored.
+func cleanRepositoryPath(repoPath string) (string, error) {
+	repoPath = strings.Trim(pathpkg.Clean("/"+repoPath), "/")
+	if repoPath == "" {
+		return "", errors.New("empty repository path")
+	}
+	return repoPath, nil
+}
+
+// PathRules configure how repository paths are normalized, so that
+// the different forms of a repository's path refer to the same
+// repository. The zero value leaves repository paths unchanged.
+//
+// Repositories that were stored before a rule was enabled are only
+// reachable at their normalized paths after they are cloned again.
+type PathRules struct {
+	// LowercaseHosts lowercases the host (the first component) of
+	// repository paths.
+	LowercaseHosts bool
+
+	// StripGitSuffix removes a trailing ".git" from repository paths.
+	StripGitSuffix bool
+
+	// CaseInsensitiveHosts lists the hosts whose repository paths are
+	// case-insensitive (e.g., "github.com"). Repository paths on them
+	// are lowercased.
+	CaseInsensitiveHosts []string
+}
+
+// Normalize returns the normalized form of repoPath.
+func (r *PathRules) Normalize(repoPath string) string {
+	host, rest := repoPath, ""
+	if i := strings.Index(repoPath, "/"); i != -1 {
+		host, rest = repoPath[:i], repoPath[i:]
+	}
+	if r.LowercaseHosts {
+		host = strings.ToLower(host)
+	}
+	if r.StripGitSuffix && strings.HasSuffix(rest, ".git") && !strings.HasSuffix(rest, "/.git") {
+		rest = strings.TrimSuffix(rest, ".git")
+	}
+	for _, h := range r.CaseInsensitiveHosts {
+		if strings.EqualFold(host, h) {
+			rest = strings.ToLower(rest)
+			break
+		}
+	}
+	return host + rest
+}
+
 func vcsTypeFromDir(cloneDir string) (vcsType string, err error) {
 	if _, err := os.Stat(filepath.Join(cloneDir, ".git")); err == nil {
 		// git non-bare
@@ -29,6 +29,42 @@ func TestEncodeAndDecodeRepositoryPath(t *testing.T) {
 	}
 }
 
+func TestEscapeAndUnescapeRepositoryPath(t *testing.T) {
+	tests := []struct {
+		repoPath string
+		want     string
+	}{
+		{"github.com/sourcegraph/go-sourcegraph", "github.com+sourcegraph+go-sourcegraph"},
+		{"github.com/Foo/bar", "github.com+!foo+bar"},
+		{"github.com/foo/BAR", "github.com+foo+!b!a!r"},
+		{"a.com/x+y!z%", "a.com+x%2By%21z%25"},
+		{"a.com/x y/\u00e9", "a.com+x%20y+%C3%A9"},
+		{".hidden/x", "%2Ehidden+x"},
+		{"_tmp_x", "%5Ftmp_x"},
+	}
+	for _, test := range tests {
+		name := EscapeRepositoryPath(test.repoPath)
+		if name != test.want {
+			t.Errorf("%q: got escaped path %q, want %q", test.repoPath, name, test.want)
+		}
+		repoPath, err := UnescapeRepositoryPath(name)
+		if err != nil {
+			t.Errorf("%q: UnescapeRepositoryPath(%q): %s", test.repoPath, name, err)
+			continue
+		}
+		if repoPath != test.repoPath {
+			t.Errorf("%q: got unescaped path %q", test.repoPath, repoPath)
+		}
+	}
+
+	// Names that EscapeRepositoryPath never returns are invalid.
+	for _, name := range []string{"a/b", "A", "!", "!A", "%2b", "%41", "%2", ".x", "_x", "%2Fx"} {
+		if repoPath, err := UnescapeRepositoryPath(name); err == nil {
+			t.Errorf("%q: got unescaped path %q, want error", name, repoPath)
+		}
+	}
+}
+
 func TestVCSTypeFromDir(t *testing.T) {
 	tests := []struct {
 		initCmd    string
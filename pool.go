@@ -0,0 +1,497 @@
+package vcsstore
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// poolsDir is the dir beneath StorageDir that holds the object pools
+// of fork networks.
+const poolsDir = "_pools"
+
+// poolFile is the name of the file in each pool dir that lists the
+// pool's members.
+const poolFile = ".vcsstore-pool.json"
+
+// An ObjectPool is a bare git repository that holds the objects shared
+// by a network of forks. The members of the pool borrow objects from it
+// (using git alternates) instead of storing their own copies.
+//
+// The pool fetches all refs of each member into a namespace of its own
+// (and is never gc'd automatically), so every object that a member may
+// be borrowing stays reachable in the pool. Removing or gc'ing a member
+// (including the fork parent that the pool was created for) therefore
+// never removes objects that other members need.
+type ObjectPool struct {
+	// Name is the name of the pool's dir in StorageDir's "_pools"
+	// dir, which is the escaped path (see EscapeRepositoryPath) of the
+	// repository that the pool was created for.
+	Name string
+
+	// Members are the paths of the repositories that borrow objects
+	// from the pool.
+	Members []string
+
+	// Size is the total size of the pool's files.
+	Size int64
+
+	// SpaceSaved estimates the disk space saved by sharing the pool's
+	// objects: the pool's size for each member beyond the first, since
+	// each member would otherwise store its own copy. (The fork parent
+	// keeps its own copy of the objects that it had when the pool was
+	// created until it is next gc'd.)
+	SpaceSaved int64
+}
+
+// poolInfo is the contents of a pool's poolFile.
+type poolInfo struct {
+	Members []string
+}
+
+// poolsRoot returns the absolute path of the dir that holds the
+// pools. Members refer to pools by absolute path in their alternates.
+func (s *service) poolsRoot() (string, error) {
+	storageDir, err := filepath.Abs(s.StorageDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(storageDir, poolsDir), nil
+}
+
+// poolRefPrefix returns the prefix of the refs in a pool that hold
+// the refs of the member repository at repoPath.
+func poolRefPrefix(repoPath string) string {
+	sum := sha1.Sum([]byte(repoPath))
+	return "refs/vcsstore/members/" + hex.EncodeToString(sum[:]) + "/"
+}
+
+// gitObjectsDir returns the objects dir of the git repository in dir.
+func gitObjectsDir(dir string) string {
+	if fi, err := os.Stat(filepath.Join(dir, ".git")); err == nil && fi.IsDir() {
+		return filepath.Join(dir, ".git", "objects")
+	}
+	return filepath.Join(dir, "objects")
+}
+
+// repoPool returns the dir of the pool that the git repository in
+// cloneDir borrows objects from, or "" if it doesn't borrow from one.
+func (s *service) repoPool(cloneDir string) (string, error) {
+	root, err := s.poolsRoot()
+	if err != nil {
+		return "", err
+	}
+	data, err := ioutil.ReadFile(filepath.Join(gitObjectsDir(cloneDir), "info", "alternates"))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if !filepath.IsAbs(line) {
+			continue
+		}
+		if poolDir := filepath.Dir(filepath.Clean(line)); filepath.Dir(poolDir) == root {
+			return poolDir, nil
+		}
+	}
+	return "", nil
+}
+
+// joinForkNetwork returns the dir of the pool that a new fork of the
+// repository at parent should borrow objects from. If parent doesn't
+// belong to a pool yet, a pool is created for it and parent becomes
+// its first member. The pool is kept until the fork has joined it (or
+// failed to), which the caller must report by calling releasePool.
+// The caller must not hold any repository's lock.
+func (s *service) joinForkNetwork(parent string) (string, error) {
+	parentDir, err := s.CloneDir(parent)
+	if err != nil {
+		return "", err
+	}
+
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+
+	// Only the read lock is needed, since the parent's objects aren't
+	// modified: the pool fetches them, and they are only removed from
+	// the parent the next time it's gc'd.
+	mu := s.Mutex(repoKey{parentDir})
+	mu.RLock()
+	defer mu.RUnlock()
+
+	vcsType, err := vcsTypeFromDir(parentDir)
+	if err != nil {
+		return "", err
+	}
+	if vcsType != "git" {
+		return "", fmt.Errorf("fork parent %s is a %s repository", parent, vcsType)
+	}
+
+	poolDir, err := s.repoPool(parentDir)
+	if err != nil {
+		return "", err
+	}
+	if poolDir == "" {
+		if poolDir, err = s.createPool(parent); err != nil {
+			return "", err
+		}
+		if err := s.addPoolMember(poolDir, parent, parentDir); err != nil {
+			return "", err
+		}
+		if err := addAlternate(parentDir, filepath.Join(poolDir, "objects")); err != nil {
+			return "", err
+		}
+		s.Log.Printf("Created object pool %s for %s.", poolDir, parent)
+	}
+	s.poolJoins[poolDir]++
+	return poolDir, nil
+}
+
+// releasePool reports that a fork that was going to join the pool in
+// poolDir (see joinForkNetwork) has joined it or failed to.
+func (s *service) releasePool(poolDir string) {
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+	s.poolJoins[poolDir]--
+	if s.poolJoins[poolDir] == 0 {
+		delete(s.poolJoins, poolDir)
+	}
+	s.removePoolIfUnused(poolDir)
+}
+
+// createPool creates the pool for the repository at repoPath (or
+// returns the existing one, which is left behind if the repository
+// was removed while it had forks). The caller must hold poolMu.
+func (s *service) createPool(repoPath string) (string, error) {
+	root, err := s.poolsRoot()
+	if err != nil {
+		return "", err
+	}
+	repoPath, err = cleanRepositoryPath(repoPath)
+	if err != nil {
+		return "", err
+	}
+	name := EscapeRepositoryPath(repoPath)
+	if len(name) > maxEscapedPathLen {
+		return "", fmt.Errorf("repository path %q is too long for an object pool", repoPath)
+	}
+	poolDir := filepath.Join(root, name)
+	if _, err := vcsTypeFromDir(poolDir); err == nil {
+		return poolDir, nil
+	}
+
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", err
+	}
+	tmpDir, err := ioutil.TempDir(root, "_tmp_"+name+"-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := runGitIn(tmpDir, "init", "--bare", "--quiet"); err != nil {
+		return "", err
+	}
+	// Only vcsstore may gc the pool, since it must not prune objects
+	// while a fork is joining it.
+	if err := runGitIn(tmpDir, "config", "gc.auto", "0"); err != nil {
+		return "", err
+	}
+	if err := writePoolInfo(tmpDir, &poolInfo{}); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpDir, poolDir); err != nil {
+		return "", err
+	}
+	return poolDir, nil
+}
+
+// addPoolMember fetches all refs of the git repository in cloneDir
+// into the pool, so that the objects the repository may borrow from
+// the pool stay reachable in it, and records the repository as a
+// member of the pool. The caller must hold poolMu, and either a lock on
+// the repository or exclusive use of cloneDir (such as a clone's
+// temporary dir).
+//
+// Pool operations lock the repositories of the pool's members while
+// holding poolMu, so poolMu must never be acquired while holding a
+// repository's lock.
+func (s *service) addPoolMember(poolDir, repoPath, cloneDir string) error {
+	refspec := "+refs/*:" + poolRefPrefix(repoPath) + "*"
+	if err := runGitIn(poolDir, "fetch", "--quiet", "--no-tags", "--prune", cloneDir, refspec); err != nil {
+		return err
+	}
+
+	pi, err := readPoolInfo(poolDir)
+	if err != nil {
+		return err
+	}
+	for _, m := range pi.Members {
+		if m == repoPath {
+			return nil
+		}
+	}
+	pi.Members = append(pi.Members, repoPath)
+	return writePoolInfo(poolDir, pi)
+}
+
+// refreshPoolMember fetches the refs of the repository at repoPath
+// into its pool (if it belongs to one) after it was updated, so that
+// the objects it now refers to stay in the pool if they are removed
+// from other members.
+func (s *service) refreshPoolMember(repoPath string) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return
+	}
+	poolDir, err := s.repoPool(cloneDir)
+	if err != nil || poolDir == "" {
+		return
+	}
+
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+	mu := s.Mutex(repoKey{cloneDir})
+	mu.RLock()
+	defer mu.RUnlock()
+	if _, err := vcsTypeFromDir(cloneDir); err != nil {
+		return
+	}
+	if err := s.addPoolMember(poolDir, repoPath, cloneDir); err != nil {
+		s.Log.Printf("Fetching the refs of %s into object pool %s failed: %s.", repoPath, poolDir, err)
+	}
+}
+
+// leavePool removes the repository at repoPath (which has been
+// removed) from the pool in poolDir. Its refs are only removed from
+// the pool after the refs of all other members have been fetched into
+// the pool again, so that no objects that they borrow become
+// unreachable. The pool itself is removed when it has no members left.
+func (s *service) leavePool(poolDir, repoPath string) {
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+
+	pi, err := readPoolInfo(poolDir)
+	if err != nil {
+		s.Log.Printf("Reading object pool %s failed: %s.", poolDir, err)
+		return
+	}
+
+	// Refresh the remaining members, and forget those that no longer
+	// borrow from the pool.
+	refreshed := true
+	var members []string
+	for _, m := range pi.Members {
+		if m == repoPath {
+			continue
+		}
+		ok, err := s.refreshMemberLocked(poolDir, m)
+		if err != nil {
+			s.Log.Printf("Fetching the refs of %s into object pool %s failed: %s.", m, poolDir, err)
+			refreshed = false
+		}
+		if ok || err != nil {
+			members = append(members, m)
+		}
+	}
+	pi.Members = members
+	if err := writePoolInfo(poolDir, pi); err != nil {
+		s.Log.Printf("Writing object pool %s failed: %s.", poolDir, err)
+		return
+	}
+
+	if s.removePoolIfUnused(poolDir) {
+		return
+	}
+	if refreshed && s.poolJoins[poolDir] == 0 {
+		if err := deleteRefs(poolDir, poolRefPrefix(repoPath)); err != nil {
+			s.Log.Printf("Removing the refs of %s from object pool %s failed: %s.", repoPath, poolDir, err)
+		}
+	}
+}
+
+// refreshMemberLocked fetches the refs of the member repository at
+// repoPath into the pool. If the repository no longer exists or no
+// longer borrows from the pool, ok is false. The caller must hold
+// poolMu.
+func (s *service) refreshMemberLocked(poolDir, repoPath string) (ok bool, err error) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return false, nil
+	}
+	mu := s.Mutex(repoKey{cloneDir})
+	mu.RLock()
+	defer mu.RUnlock()
+	if _, err := vcsTypeFromDir(cloneDir); err != nil {
+		return false, nil
+	}
+	if p, err := s.repoPool(cloneDir); err != nil || p != poolDir {
+		return false, err
+	}
+	return true, s.addPoolMember(poolDir, repoPath, cloneDir)
+}
+
+// removePoolIfUnused removes the pool in poolDir if it has no members
+// and no forks are joining it. The caller must hold poolMu.
+func (s *service) removePoolIfUnused(poolDir string) (removed bool) {
+	if s.poolJoins[poolDir] > 0 {
+		return false
+	}
+	pi, err := readPoolInfo(poolDir)
+	if err != nil || len(pi.Members) > 0 {
+		return false
+	}
+
+	rmTmpDir, err := ioutil.TempDir(filepath.Dir(poolDir), "_tmp_rm_"+filepath.Base(poolDir)+"-")
+	if err == nil {
+		defer os.RemoveAll(rmTmpDir)
+		err = os.Rename(poolDir, filepath.Join(rmTmpDir, filepath.Base(poolDir)))
+	}
+	if err != nil {
+		s.Log.Printf("Removing unused object pool %s failed: %s.", poolDir, err)
+		return false
+	}
+	s.Log.Printf("Removed unused object pool %s.", poolDir)
+	return true
+}
+
+// Pools returns the object pools of the fork networks in StorageDir,
+// sorted by name.
+func (s *service) Pools() ([]*ObjectPool, error) {
+	root, err := s.poolsRoot()
+	if err != nil {
+		return nil, err
+	}
+	s.poolMu.Lock()
+	defer s.poolMu.Unlock()
+
+	fis, err := ioutil.ReadDir(root)
+	if os.IsNotExist(err) {
+		return []*ObjectPool{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	pools := []*ObjectPool{}
+	for _, fi := range fis {
+		if !fi.IsDir() || strings.HasPrefix(fi.Name(), "_tmp_") {
+			continue
+		}
+		poolDir := filepath.Join(root, fi.Name())
+		pi, err := readPoolInfo(poolDir)
+		if err != nil {
+			return nil, err
+		}
+		size, err := dirSize(poolDir)
+		if err != nil {
+			return nil, err
+		}
+		p := &ObjectPool{Name: fi.Name(), Members: pi.Members, Size: size}
+		if p.Members == nil {
+			p.Members = []string{}
+		}
+		if n := int64(len(p.Members)); n > 1 {
+			p.SpaceSaved = (n - 1) * size
+		}
+		pools = append(pools, p)
+	}
+	return pools, nil
+}
+
+func readPoolInfo(poolDir string) (*poolInfo, error) {
+	data, err := ioutil.ReadFile(filepath.Join(poolDir, poolFile))
+	if err != nil {
+		return nil, err
+	}
+	var pi *poolInfo
+	if err := json.Unmarshal(data, &pi); err != nil {
+		return nil, err
+	}
+	return pi, nil
+}
+
+// writePoolInfo "atomically" writes the pool's poolFile.
+func writePoolInfo(poolDir string, pi *poolInfo) error {
+	data, err := json.MarshalIndent(pi, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(poolDir, poolFile+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(poolDir, poolFile))
+}
+
+// addAlternate makes the git repository in dir borrow objects from
+// the objects dir objectsDir.
+func addAlternate(dir, objectsDir string) error {
+	file := filepath.Join(gitObjectsDir(dir), "info", "alternates")
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == objectsDir {
+			return nil
+		}
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	data = append(data, objectsDir+"\n"...)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
+
+// deleteRefs deletes the refs beginning with prefix in the git
+// repository in dir.
+func deleteRefs(dir, prefix string) error {
+	cmd := exec.Command("git", "for-each-ref", "--format=%(refname)", prefix)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("git for-each-ref failed: %s", err)
+	}
+	var in bytes.Buffer
+	for _, ref := range strings.Fields(string(out)) {
+		fmt.Fprintf(&in, "delete %s\n", ref)
+	}
+	if in.Len() == 0 {
+		return nil
+	}
+	cmd = exec.Command("git", "update-ref", "--stdin")
+	cmd.Dir = dir
+	cmd.Stdin = &in
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git update-ref failed: %s (%s)", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// runGitIn runs a local git command in dir.
+func runGitIn(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s failed: %s (%s)", args[0], err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package vcsstore
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %s\n%s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestService_Clone_fork(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	parentRemote := initRemoteGitRepo(t)
+	defer os.RemoveAll(parentRemote)
+	parentCommit := gitOutput(t, parentRemote, "rev-parse", "HEAD")
+	forkRemote, err := ioutil.TempDir("", "vcsstore-test-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(forkRemote)
+	gitOutput(t, forkRemote, "clone", "--quiet", parentRemote, ".")
+	gitOutput(t, forkRemote, "-c", "user.name=a", "-c", "user.email=a@a.com", "commit", "--allow-empty", "-m", "y")
+
+	h, err := s.Clone("a.com/p", &vcsclient.CloneInfo{VCS: "git", CloneURL: parentRemote})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+	h, err = s.Clone("a.com/f", &vcsclient.CloneInfo{VCS: "git", CloneURL: forkRemote, ForkParent: "a.com/p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+
+	parentDir, forkDir := mustCloneDir(t, s, "a.com/p"), mustCloneDir(t, s, "a.com/f")
+	root, _ := s.poolsRoot()
+	poolDir := filepath.Join(root, "a.com+p")
+	for _, dir := range []string{parentDir, forkDir} {
+		if p, err := s.repoPool(dir); err != nil || p != poolDir {
+			t.Errorf("%s: got pool %q (err %v), want %q", dir, p, err, poolDir)
+		}
+	}
+	if md, err := readMetadata(forkDir); err != nil || md.ForkParent != "a.com/p" {
+		t.Errorf("got fork metadata %+v (err %v), want ForkParent", md, err)
+	}
+
+	// The pool must not be listed as a repository.
+	repos, _, err := s.ListRepositories(vcsclient.RepositoryListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 2 {
+		t.Errorf("got repos %+v, want only the parent and the fork", repos)
+	}
+
+	pools, err := s.Pools()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 1 {
+		t.Fatalf("got %d pools, want 1", len(pools))
+	}
+	if want := []string{"a.com/p", "a.com/f"}; !reflect.DeepEqual(pools[0].Members, want) {
+		t.Errorf("got members %v, want %v", pools[0].Members, want)
+	}
+	if pools[0].Size == 0 || pools[0].SpaceSaved != pools[0].Size {
+		t.Errorf("got pool size %d and space saved %d, want equal and nonzero", pools[0].Size, pools[0].SpaceSaved)
+	}
+
+	// Gc'ing the parent and the pool must not break the fork either.
+	results, err := s.Maintain(MaintenanceOptions{Force: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pooled bool
+	for _, r := range results {
+		if r.Error != "" {
+			t.Errorf("maintenance of %s%s failed: %s", r.RepoPath, r.Pool, r.Error)
+		}
+		pooled = pooled || r.Pool == "a.com+p"
+	}
+	if !pooled {
+		t.Errorf("got maintenance results %+v, want the pool to be maintained", results)
+	}
+	gitOutput(t, forkDir, "fsck", "--connectivity-only")
+
+	// Removing the parent must not break the fork, which borrows the
+	// parent's commit from the pool.
+	if err := s.Remove("a.com/p"); err != nil {
+		t.Fatal(err)
+	}
+	gitOutput(t, forkDir, "cat-file", "-e", parentCommit)
+	gitOutput(t, forkDir, "fsck", "--connectivity-only")
+	if refs := gitOutput(t, poolDir, "for-each-ref", poolRefPrefix("a.com/p")); refs != "" {
+		t.Errorf("got refs of removed parent in pool:\n%s", refs)
+	}
+	gitOutput(t, poolDir, "cat-file", "-e", parentCommit)
+	if pools, err := s.Pools(); err != nil || len(pools) != 1 || !reflect.DeepEqual(pools[0].Members, []string{"a.com/f"}) {
+		t.Errorf("after removing parent, got pools %+v (err %v)", pools, err)
+	}
+
+	// The pool is removed along with its last member.
+	if err := s.Remove("a.com/f"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(poolDir); !os.IsNotExist(err) {
+		t.Errorf("got Stat(poolDir) err == %v, want os.IsNotExist", err)
+	}
+	if pools, err := s.Pools(); err != nil || len(pools) != 0 {
+		t.Errorf("after removing fork, got pools %+v (err %v)", pools, err)
+	}
+}
+
+func TestService_poolNotARepository(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	parentRemote := initRemoteGitRepo(t)
+	defer os.RemoveAll(parentRemote)
+	for _, c := range []struct{ repoPath, parent string }{{"a.com/p", ""}, {"a.com/f", "a.com/p"}} {
+		h, err := s.Clone(c.repoPath, &vcsclient.CloneInfo{VCS: "git", CloneURL: parentRemote, ForkParent: c.parent})
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.Release()
+	}
+	root, _ := s.poolsRoot()
+	poolDir := filepath.Join(root, "a.com+p")
+	refs := gitOutput(t, poolDir, "for-each-ref")
+
+	// The pool's dir must not be reachable by repository path.
+	const repoPath = "_pools/a.com+p"
+	if h, err := s.Acquire(repoPath); !os.IsNotExist(err) {
+		if h != nil {
+			h.Release()
+		}
+		t.Errorf("got Acquire error %v, want os.IsNotExist", err)
+	}
+	if h, err := s.Clone(repoPath, &vcsclient.CloneInfo{VCS: "git", CloneURL: parentRemote}); !os.IsNotExist(err) {
+		if h != nil {
+			h.Release()
+		}
+		t.Errorf("got Clone error %v, want os.IsNotExist", err)
+	}
+	if _, err := s.Update(repoPath, &vcsclient.CloneInfo{}); !os.IsNotExist(err) {
+		t.Errorf("got Update error %v, want os.IsNotExist", err)
+	}
+	if err := s.Remove(repoPath); !os.IsNotExist(err) {
+		t.Errorf("got Remove error %v, want os.IsNotExist", err)
+	}
+
+	if got := gitOutput(t, poolDir, "for-each-ref"); got != refs {
+		t.Errorf("pool refs changed:\n%s\nwant:\n%s", got, refs)
+	}
+	gitOutput(t, mustCloneDir(t, s, "a.com/f"), "fsck", "--connectivity-only")
+}
+
+func TestService_Clone_forkOfMissingParent(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	remote := initRemoteGitRepo(t)
+	defer os.RemoveAll(remote)
+
+	h, err := s.Clone("a.com/f", &vcsclient.CloneInfo{VCS: "git", CloneURL: remote, ForkParent: "a.com/p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+
+	if p, err := s.repoPool(mustCloneDir(t, s, "a.com/f")); err != nil || p != "" {
+		t.Errorf("got pool %q (err %v), want none", p, err)
+	}
+}
+
+// TestService_Clone_forkConcurrent clones and removes members of the
+// same pool concurrently, which deadlocks if the pool's lock and the
+// repositories' locks aren't always acquired in the same order.
+func TestService_Clone_forkConcurrent(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	remote := initRemoteGitRepo(t)
+	defer os.RemoveAll(remote)
+
+	h, err := s.Clone("a.com/p", &vcsclient.CloneInfo{VCS: "git", CloneURL: remote})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+
+	forks := []string{"a.com/f1", "a.com/f2", "a.com/f3"}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, fork := range forks {
+		wg.Add(1)
+		go func(fork string) {
+			defer wg.Done()
+			for i := 0; i < 3; i++ {
+				h, err := s.Clone(fork, &vcsclient.CloneInfo{VCS: "git", CloneURL: remote, ForkParent: "a.com/p"})
+				if err != nil {
+					t.Errorf("clone %s: %s", fork, err)
+					return
+				}
+				h.Release()
+				if err := s.Remove(fork); err != nil {
+					t.Errorf("remove %s: %s", fork, err)
+					return
+				}
+			}
+		}(fork)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 3; i++ {
+			if _, err := s.Update("a.com/p", &vcsclient.CloneInfo{}); err != nil {
+				t.Errorf("update: %s", err)
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("timed out (deadlock?)")
+	}
+
+	pools, err := s.Pools()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 1 || !reflect.DeepEqual(pools[0].Members, []string{"a.com/p"}) {
+		t.Errorf("got pools %+v, want only the parent as a member", pools)
+	}
+}
+
+// TestService_Clone_forkLockOrder checks that a clone of a fork doesn't
+// hold the fork's lock while it waits for poolMu, since pool operations
+// (such as removing another member) lock members while holding poolMu.
+func TestService_Clone_forkLockOrder(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	remote := initRemoteGitRepo(t)
+	defer os.RemoveAll(remote)
+
+	h, err := s.Clone("a.com/p", &vcsclient.CloneInfo{VCS: "git", CloneURL: remote})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+
+	s.poolMu.Lock()
+	cloned, isBlocked := blocked(func() {
+		h, err := s.Clone("a.com/f", &vcsclient.CloneInfo{VCS: "git", CloneURL: remote, ForkParent: "a.com/p"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		h.Release()
+	})
+	if !isBlocked {
+		t.Fatal("Clone of fork did not wait for poolMu")
+	}
+	mu := s.Mutex(repoKey{mustCloneDir(t, s, "a.com/f")})
+	rlocked, isBlocked := blocked(func() {
+		mu.RLock()
+		mu.RUnlock()
+	})
+	if isBlocked {
+		t.Error("Clone of fork holds the fork's lock while waiting for poolMu")
+	}
+	s.poolMu.Unlock()
+	<-rlocked
+	<-cloned
+}
@@ -0,0 +1,203 @@
+package vcsstore
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// cloneProgress is the progress of a clone or fetch, as reported by
+// the VCS.
+type cloneProgress struct {
+	Phase string // "counting", "compressing", "receiving", "resolving", or "renaming"
+	Line  string // the VCS's progress output line
+
+	Objects, TotalObjects int64 // only set in the "receiving" phase
+	Bytes                 int64 // approximate bytes received
+}
+
+// gitProgressPhases maps the operation names in git's progress output
+// to phases.
+var gitProgressPhases = map[string]string{
+	"Enumerating objects": "counting",
+	"Counting objects":    "counting",
+	"Compressing objects": "compressing",
+	"Receiving objects":   "receiving",
+	"Resolving deltas":    "resolving",
+}
+
+var (
+	gitProgressCountPattern = regexp.MustCompile(`\((\d+)/(\d+)\)`)
+	gitProgressBytesPattern = regexp.MustCompile(`, ([\d.]+) (bytes|KiB|MiB|GiB)`)
+)
+
+var gitProgressUnits = map[string]float64{
+	"bytes": 1,
+	"KiB":   1 << 10,
+	"MiB":   1 << 20,
+	"GiB":   1 << 30,
+}
+
+// parseGitProgress parses a line of the progress output of `git clone
+// --progress`, such as "Receiving objects:  45% (450/1000), 1.20 MiB |
+// 2.00 MiB/s". If the line isn't a progress line, ok is false.
+func parseGitProgress(line string) (p cloneProgress, ok bool) {
+	line = strings.TrimPrefix(line, "remote: ")
+	i := strings.Index(line, ":")
+	if i == -1 {
+		return p, false
+	}
+	p.Phase, ok = gitProgressPhases[line[:i]]
+	if !ok {
+		return p, false
+	}
+
+	if p.Phase == "receiving" {
+		if m := gitProgressCountPattern.FindStringSubmatch(line); m != nil {
+			p.Objects, _ = strconv.ParseInt(m[1], 10, 64)
+			p.TotalObjects, _ = strconv.ParseInt(m[2], 10, 64)
+		}
+		if m := gitProgressBytesPattern.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.ParseFloat(m[1], 64)
+			p.Bytes = int64(n * gitProgressUnits[m[2]])
+		}
+	}
+	return p, true
+}
+
+// lineWriter is an io.Writer that calls fn with each line written to
+// it. Lines may be terminated by "\n" or "\r" (which git uses to
+// redraw progress lines in place). Empty lines are skipped.
+type lineWriter struct {
+	fn  func(line string)
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' || b == '\r' {
+			w.flush()
+		} else {
+			w.buf = append(w.buf, b)
+		}
+	}
+	return len(p), nil
+}
+
+// flush calls fn with the unterminated line that has been written so
+// far, if any.
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.fn(string(w.buf))
+		w.buf = w.buf[:0]
+	}
+}
+
+// progressEventBufferSize is the number of events that are buffered
+// for each watcher. If a watcher falls further behind, progress line
+// events are dropped, and older events are dropped to make room for
+// phase changes and final events.
+const progressEventBufferSize = 64
+
+// progressHub broadcasts the progress events of clones and updates to
+// the watchers of each repository.
+type progressHub struct {
+	mu       sync.Mutex
+	watchers map[string]map[chan *vcsclient.ProgressEvent]struct{}
+}
+
+// watch returns a channel that receives the progress events of the
+// repository until stop is called.
+func (h *progressHub) watch(repoPath string) (events <-chan *vcsclient.ProgressEvent, stop func()) {
+	ch := make(chan *vcsclient.ProgressEvent, progressEventBufferSize)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.watchers == nil {
+		h.watchers = map[string]map[chan *vcsclient.ProgressEvent]struct{}{}
+	}
+	if h.watchers[repoPath] == nil {
+		h.watchers[repoPath] = map[chan *vcsclient.ProgressEvent]struct{}{}
+	}
+	h.watchers[repoPath][ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.watchers[repoPath], ch)
+		if len(h.watchers[repoPath]) == 0 {
+			delete(h.watchers, repoPath)
+		}
+	}
+}
+
+// publish sends ev to the repository's watchers without blocking.
+func (h *progressHub) publish(repoPath string, ev *vcsclient.ProgressEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.watchers[repoPath] {
+		select {
+		case ch <- ev:
+			continue
+		default:
+		}
+		if ev.Type == vcsclient.ProgressLine {
+			continue
+		}
+		// Make room for the more important event.
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+func (h *progressHub) publishPhase(repoPath, phase string) {
+	h.publish(repoPath, &vcsclient.ProgressEvent{Type: vcsclient.ProgressPhase, Phase: phase})
+}
+
+func (h *progressHub) publishLine(repoPath, line string) {
+	h.publish(repoPath, &vcsclient.ProgressEvent{Type: vcsclient.ProgressLine, Line: line})
+}
+
+// publishDone publishes the final event of a clone or update.
+func (h *progressHub) publishDone(repoPath string, result *vcs.UpdateResult, err error) {
+	if err != nil {
+		h.publish(repoPath, &vcsclient.ProgressEvent{Type: vcsclient.ProgressError, Error: err.Error()})
+	} else {
+		h.publish(repoPath, &vcsclient.ProgressEvent{Type: vcsclient.ProgressSuccess, Result: result})
+	}
+}
+
+// reporter returns a func that publishes progress (and phase changes)
+// of a clone or update of the repository to its watchers, and then
+// calls progress (if non-nil).
+func (h *progressHub) reporter(repoPath string, progress func(cloneProgress)) func(cloneProgress) {
+	var phase string
+	return func(p cloneProgress) {
+		if p.Phase != phase {
+			phase = p.Phase
+			h.publishPhase(repoPath, phase)
+		}
+		if p.Line != "" {
+			h.publishLine(repoPath, p.Line)
+		}
+		if progress != nil {
+			progress(p)
+		}
+	}
+}
+
+// WatchProgress returns a channel that receives the progress events of
+// clones and updates of the repository until stop is called.
+func (s *service) WatchProgress(repoPath string) (events <-chan *vcsclient.ProgressEvent, stop func()) {
+	return s.progress.watch(repoPath)
+}
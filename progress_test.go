@@ -0,0 +1,105 @@
+package vcsstore
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func TestParseGitProgress(t *testing.T) {
+	tests := map[string]struct {
+		want cloneProgress
+		ok   bool
+	}{
+		"remote: Counting objects: 1000, done.":                       {cloneProgress{Phase: "counting"}, true},
+		"remote: Enumerating objects: 12, done.":                      {cloneProgress{Phase: "counting"}, true},
+		"remote: Compressing objects:  50% (1/2)":                     {cloneProgress{Phase: "compressing"}, true},
+		"Receiving objects:   0% (1/1000)":                            {cloneProgress{Phase: "receiving", Objects: 1, TotalObjects: 1000}, true},
+		"Receiving objects:  45% (450/1000), 1.50 MiB | 2.00 MiB/s":   {cloneProgress{Phase: "receiving", Objects: 450, TotalObjects: 1000, Bytes: 1572864}, true},
+		"Receiving objects: 100% (3/3), 512 bytes | 0 bytes/s, done.": {cloneProgress{Phase: "receiving", Objects: 3, TotalObjects: 3, Bytes: 512}, true},
+		"Resolving deltas: 100% (3/3), done.":                         {cloneProgress{Phase: "resolving"}, true},
+		"Cloning into bare repository 'x'...":                         {cloneProgress{}, false},
+		"fatal: repository 'x' does not exist":                        {cloneProgress{}, false},
+	}
+	for line, test := range tests {
+		p, ok := parseGitProgress(line)
+		if ok != test.ok {
+			t.Errorf("%q: got ok %v, want %v", line, ok, test.ok)
+			continue
+		}
+		if p != test.want {
+			t.Errorf("%q: got %+v, want %+v", line, p, test.want)
+		}
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := &lineWriter{fn: func(line string) { lines = append(lines, line) }}
+	w.Write([]byte("a: 1%\ra: 5"))
+	w.Write([]byte("0%\r\nb\n\nc"))
+	w.flush()
+
+	want := []string{"a: 1%", "a: 50%", "b", "c"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got lines %q, want %q", lines, want)
+	}
+}
+
+func TestProgressHub(t *testing.T) {
+	var h progressHub
+	events, stop := h.watch("a")
+	other, stopOther := h.watch("b")
+	defer stopOther()
+
+	h.publishPhase("a", "receiving")
+	h.publishLine("a", "x")
+	h.publishDone("a", nil, nil)
+
+	want := []vcsclient.ProgressEvent{
+		{Type: vcsclient.ProgressPhase, Phase: "receiving"},
+		{Type: vcsclient.ProgressLine, Line: "x"},
+		{Type: vcsclient.ProgressSuccess},
+	}
+	for i, want := range want {
+		if ev := <-events; *ev != want {
+			t.Errorf("#%d: got event %+v, want %+v", i, ev, want)
+		}
+	}
+	select {
+	case ev := <-other:
+		t.Errorf("got event %+v for other repository", ev)
+	default:
+	}
+
+	stop()
+	h.publishPhase("a", "resolving")
+	select {
+	case ev := <-events:
+		t.Errorf("got event %+v after stop", ev)
+	default:
+	}
+}
+
+func TestProgressHub_slowWatcher(t *testing.T) {
+	var h progressHub
+	events, stop := h.watch("a")
+	defer stop()
+
+	// Fill the buffer. Further progress lines are dropped, but the
+	// final event must still be delivered.
+	for i := 0; i < progressEventBufferSize+10; i++ {
+		h.publishLine("a", "x")
+	}
+	h.publishDone("a", nil, errors.New("e"))
+
+	var last *vcsclient.ProgressEvent
+	for i := 0; i < progressEventBufferSize; i++ {
+		last = <-events
+	}
+	if want := (vcsclient.ProgressEvent{Type: vcsclient.ProgressError, Error: "e"}); *last != want {
+		t.Errorf("got last event %+v, want %+v", last, want)
+	}
+}
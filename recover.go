@@ -0,0 +1,204 @@
+package vcsstore
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// BrokenRepository describes a stored repository that failed the
+// integrity check.
+type BrokenRepository struct {
+	RepoPath string
+	VCS      string
+
+	// Problem describes why the repository is considered broken.
+	Problem string
+}
+
+// recoverStorage cleans up after vcsstore processes that died in the
+// middle of cloning or removing repositories (if OrphanedTempDirAge is
+// set), and checks the integrity of the stored repositories. It walks
+// StorageDir, so it runs in the background; recovered is closed when
+// it is done.
+func (s *service) recoverStorage() {
+	defer close(s.recovered)
+	start := time.Now()
+
+	var orphans []string
+	if s.OrphanedTempDirAge > 0 {
+		var err error
+		orphans, err = findOrphanedTempDirs(s.StorageDir, start.Add(-s.OrphanedTempDirAge))
+		if err != nil {
+			s.Log.Printf("Recovery: finding orphaned temporary dirs failed: %s.", err)
+		}
+		s.disposeOrphans(orphans)
+	}
+
+	var nBroken int
+	err := walkRepositories(s.StorageDir, "", func(repoPath, cloneDir, vcsType string) error {
+		select {
+		case <-s.closed:
+			return errServiceClosed
+		default:
+		}
+		if err := checkRepoIntegrity(cloneDir, vcsType); err != nil {
+			s.Log.Printf("Recovery: repository %s at %s is broken: %s.", repoPath, cloneDir, err)
+			nBroken++
+			s.brokenMu.Lock()
+			s.broken = append(s.broken, &BrokenRepository{RepoPath: repoPath, VCS: vcsType, Problem: err.Error()})
+			s.brokenMu.Unlock()
+		}
+		return nil
+	})
+	if err != nil && err != errServiceClosed {
+		s.Log.Printf("Recovery: checking repositories failed: %s.", err)
+	}
+
+	s.Log.Printf("Recovery: found %d orphaned temporary dirs and %d broken repositories in %s.", len(orphans), nBroken, time.Since(start))
+}
+
+// errServiceClosed stops background work when the service is closed.
+var errServiceClosed = errors.New("service closed")
+
+// findOrphanedTempDirs returns the temporary dirs beneath storageDir
+// that were last modified before cutoff, which are assumed to have
+// been left behind by interrupted clones and removals. Newer ones may
+// belong to clones that are still running (in this or another
+// process), so they are kept.
+func findOrphanedTempDirs(storageDir string, cutoff time.Time) ([]string, error) {
+	storageDir = filepath.Clean(storageDir)
+	var orphans []string
+	err := filepath.Walk(storageDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if path == storageDir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.IsDir() || path == storageDir {
+			return nil
+		}
+		if strings.HasPrefix(fi.Name(), "_tmp_") {
+			if fi.ModTime().Before(cutoff) {
+				orphans = append(orphans, path)
+			}
+			return filepath.SkipDir
+		}
+		if _, err := vcsTypeFromDir(path); err == nil {
+			// Temporary dirs are siblings of repositories, never
+			// inside them.
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return orphans, err
+}
+
+// disposeOrphans removes orphaned temporary dirs, or moves them to
+// QuarantineDir if it is set.
+func (s *service) disposeOrphans(orphans []string) {
+	quarantineDir := ""
+	if s.QuarantineDir != "" {
+		quarantineDir = filepath.Join(s.QuarantineDir, time.Now().UTC().Format("20060102T150405Z"))
+	}
+
+	for _, dir := range orphans {
+		if quarantineDir != "" {
+			rel, err := filepath.Rel(s.StorageDir, dir)
+			if err == nil {
+				dst := filepath.Join(quarantineDir, rel)
+				if err = os.MkdirAll(filepath.Dir(dst), 0700); err == nil {
+					err = os.Rename(dir, dst)
+				}
+			}
+			if err != nil {
+				s.Log.Printf("Recovery: quarantining orphaned temporary dir %s failed: %s.", dir, err)
+				continue
+			}
+			s.Log.Printf("Recovery: quarantined orphaned temporary dir %s in %s.", dir, quarantineDir)
+			continue
+		}
+
+		if err := os.RemoveAll(dir); err != nil {
+			s.Log.Printf("Recovery: removing orphaned temporary dir %s failed: %s.", dir, err)
+			continue
+		}
+		s.Log.Printf("Recovery: removed orphaned temporary dir %s.", dir)
+	}
+}
+
+var gitHEADPattern = regexp.MustCompile(`^(ref: refs/\S+|[0-9a-f]{40})\n?$`)
+
+// checkRepoIntegrity performs a cheap check that the repository in
+// cloneDir isn't obviously broken (e.g., by a partial copy or an
+// interrupted write). It doesn't verify the repository's objects.
+func checkRepoIntegrity(cloneDir, vcsType string) error {
+	switch vcsType {
+	case "git":
+		gitDir := cloneDir
+		if _, err := os.Stat(filepath.Join(cloneDir, ".git")); err == nil {
+			gitDir = filepath.Join(cloneDir, ".git")
+		}
+		for _, name := range []string{"objects", "refs"} {
+			if fi, err := os.Stat(filepath.Join(gitDir, name)); err != nil || !fi.IsDir() {
+				return fmt.Errorf("missing %s dir", name)
+			}
+		}
+		head, err := ioutil.ReadFile(filepath.Join(gitDir, "HEAD"))
+		if err != nil {
+			return fmt.Errorf("missing HEAD: %s", err)
+		}
+		if !gitHEADPattern.Match(head) {
+			return fmt.Errorf("invalid HEAD %q", head)
+		}
+
+	case "hg":
+		for _, name := range []string{".hg/requires", ".hg/store"} {
+			if _, err := os.Stat(filepath.Join(cloneDir, name)); err != nil {
+				return fmt.Errorf("missing %s", name)
+			}
+		}
+	}
+	return nil
+}
+
+// BrokenRepositories returns the repositories that failed the
+// integrity check when the service started, and that are still
+// broken. The check runs in the background, and broken repositories
+// are reported as soon as it finds them, so the repositories it hasn't
+// reached yet are not reported.
+func (s *service) BrokenRepositories() []*BrokenRepository {
+	s.brokenMu.Lock()
+	defer s.brokenMu.Unlock()
+
+	// Forget repositories that were since removed or re-cloned.
+	stillBroken := s.broken[:0]
+	for _, b := range s.broken {
+		cloneDir, err := s.CloneDir(b.RepoPath)
+		if err != nil {
+			continue
+		}
+		vcsType, err := vcsTypeFromDir(cloneDir)
+		if err != nil {
+			continue
+		}
+		if err := checkRepoIntegrity(cloneDir, vcsType); err != nil {
+			b.Problem = err.Error()
+			stillBroken = append(stillBroken, b)
+		}
+	}
+	s.broken = stillBroken
+
+	broken := make([]*BrokenRepository, len(s.broken))
+	for i, b := range s.broken {
+		b2 := *b
+		broken[i] = &b2
+	}
+	return broken
+}
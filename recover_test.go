@@ -0,0 +1,142 @@
+package vcsstore
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// restartService closes s and creates a new service that uses the
+// same StorageDir, as though the process had restarted, and waits for
+// its startup recovery to finish. Temporary dirs older than orphanAge
+// (if nonzero) are treated as orphans. The caller must close the new
+// service.
+func restartService(s *service, quarantineDir string, orphanAge time.Duration) *service {
+	s.Close()
+	s = NewService(&Config{
+		StorageDir:         s.StorageDir,
+		OrphanedTempDirAge: orphanAge,
+		QuarantineDir:      quarantineDir,
+		Log:                log.New(ioutil.Discard, "", 0),
+	}).(*service)
+	<-s.recovered
+	return s
+}
+
+// makeOrphan creates a temporary dir that was last modified age ago.
+func makeOrphan(t *testing.T, dir string, age time.Duration) {
+	if err := os.MkdirAll(filepath.Join(dir, "objects"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(dir, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestService_recoverStorage_orphans(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	cloneDir := initBareGitRepo(t, s, "a.com/x")
+	orphans := []string{
+		filepath.Join(storageDir, "a.com/_tmp_y-123"),
+		filepath.Join(storageDir, "a.com/_tmp_rm_z-456"),
+	}
+	for _, dir := range orphans {
+		makeOrphan(t, dir, 2*time.Hour)
+	}
+	// A recent temporary dir may belong to a clone that is still
+	// running in another process.
+	recent := filepath.Join(storageDir, "a.com/_tmp_w-789")
+	makeOrphan(t, recent, time.Minute)
+
+	// Orphans are only removed if OrphanedTempDirAge is set.
+	s = restartService(s, "", 0)
+	defer s.Close()
+	for _, dir := range append(orphans, recent) {
+		if _, err := os.Stat(dir); err != nil {
+			t.Errorf("temporary dir was removed without OrphanedTempDirAge: %s", err)
+		}
+	}
+
+	s = restartService(s, "", time.Hour)
+	defer s.Close()
+	for _, dir := range orphans {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s: got error %v, want not exist", dir, err)
+		}
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("recent temporary dir was removed: %s", err)
+	}
+	if _, err := os.Stat(cloneDir); err != nil {
+		t.Errorf("repository was removed: %s", err)
+	}
+}
+
+func TestService_recoverStorage_quarantine(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+	quarantineDir, err := ioutil.TempDir("", "vcsstore-test-quarantine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(quarantineDir)
+
+	orphan := filepath.Join(storageDir, "a.com/_tmp_y-123")
+	makeOrphan(t, orphan, 2*time.Hour)
+
+	s = restartService(s, quarantineDir, time.Hour)
+	defer s.Close()
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("got error %v, want not exist", err)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(quarantineDir, "*", "a.com", "_tmp_y-123", "objects"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Errorf("got quarantined dirs %v, want 1", matches)
+	}
+}
+
+func TestService_BrokenRepositories(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	initBareGitRepo(t, s, "a.com/ok")
+	noHEAD := initBareGitRepo(t, s, "a.com/nohead")
+	if err := os.Remove(filepath.Join(noHEAD, "HEAD")); err != nil {
+		t.Fatal(err)
+	}
+	badHEAD := initBareGitRepo(t, s, "a.com/badhead")
+	if err := ioutil.WriteFile(filepath.Join(badHEAD, "HEAD"), []byte{0, 0, 0}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s = restartService(s, "", 0)
+	defer s.Close()
+	broken := s.BrokenRepositories()
+	if len(broken) != 2 || broken[0].RepoPath != "a.com/badhead" || broken[1].RepoPath != "a.com/nohead" {
+		t.Fatalf("got broken repos %+v, want a.com/badhead and a.com/nohead", broken)
+	}
+
+	// Repaired and removed repositories are no longer reported.
+	if err := ioutil.WriteFile(filepath.Join(badHEAD, "HEAD"), []byte("ref: refs/heads/master\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Remove("a.com/nohead"); err != nil {
+		t.Fatal(err)
+	}
+	if broken := s.BrokenRepositories(); len(broken) != 0 {
+		t.Errorf("got broken repos %+v, want none", broken)
+	}
+}
@@ -0,0 +1,270 @@
+package vcsstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/go-vcs/vcs/gitcmd"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// A TimeoutError is returned when a clone or update is canceled
+// because it took longer than its timeout.
+type TimeoutError struct {
+	Op       string // "clone" or "update"
+	RepoPath string
+	Timeout  time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s of %s timed out after %s", e.Op, e.RepoPath, e.Timeout)
+}
+
+// errCanceled is returned by runRemoteCmd when the command is killed
+// because it was canceled.
+var errCanceled = errors.New("canceled")
+
+// timeoutCanceler returns a channel that is closed after timeout, and
+// a func that stops the timer. If timeout is zero, the channel is
+// never closed.
+func timeoutCanceler(timeout time.Duration) (cancel <-chan struct{}, stop func()) {
+	if timeout <= 0 {
+		return nil, func() {}
+	}
+	c := make(chan struct{})
+	t := time.AfterFunc(timeout, func() { close(c) })
+	return c, func() { t.Stop() }
+}
+
+// cloneRepo clones the repository described by cloneInfo to dir as a
+// bare mirror. Git and hg repositories are cloned by running the VCS
+// directly, so that the clone can be canceled by closing cancel and
+// so that the progress of git clones is reported to progress (if
+// non-nil). Other VCSs are cloned using vcs.Clone.
+//
+// If poolDir is set, a git repository borrows the objects in the
+// object pool in poolDir instead of fetching them.
+func cloneRepo(cloneInfo *vcsclient.CloneInfo, dir, poolDir string, progress func(cloneProgress), cancel <-chan struct{}) error {
+	switch cloneInfo.VCS {
+	case "git":
+		args := []string{"clone", "--mirror", "--progress"}
+		if poolDir != "" {
+			args = append(args, "--reference", poolDir)
+		}
+		args = append(args, "--", cloneInfo.CloneURL, filepath.ToSlash(dir))
+		return runGit(cloneInfo.RemoteOpts, "", progress, cancel, args...)
+	case "hg":
+		if cloneInfo.RemoteOpts.SSH != nil {
+			return errors.New("hg: ssh remote not supported")
+		}
+		cmd := exec.Command("hg", "clone", "--noupdate", "--", cloneInfo.CloneURL, dir)
+		return runRemoteCmd(cmd, progress, cancel)
+	}
+
+	cloneOpt := vcs.CloneOpt{Bare: true, Mirror: true, RemoteOpts: cloneInfo.RemoteOpts}
+	_, err := vcs.Clone(cloneInfo.VCS, cloneInfo.CloneURL, dir, cloneOpt)
+	return err
+}
+
+// fetchRepo updates all branches, tags, etc., of the repository in
+// dir to match its default remote. Like cloneRepo, it can be canceled
+// by closing cancel, and it reports the progress of git fetches to
+// progress.
+func fetchRepo(vcsType, dir string, opt vcs.RemoteOpts, progress func(cloneProgress), cancel <-chan struct{}) error {
+	switch vcsType {
+	case "git":
+		return runGit(opt, dir, progress, cancel, "fetch", "--all", "--prune", "--progress")
+	case "hg":
+		if opt.SSH != nil {
+			return errors.New("hg: ssh remote not supported")
+		}
+		cmd := exec.Command("hg", "pull")
+		cmd.Dir = dir
+		return runRemoteCmd(cmd, progress, cancel)
+	}
+	return fmt.Errorf("remote updates not yet implemented for VCS %q", vcsType)
+}
+
+// runGit runs a git command that communicates with a remote using
+// opt, in dir (or the current directory if dir is empty).
+func runGit(opt vcs.RemoteOpts, dir string, progress func(cloneProgress), cancel <-chan struct{}, args ...string) error {
+	env, tmpDir, err := gitRemoteEnv(opt)
+	if tmpDir != "" {
+		defer os.RemoveAll(tmpDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	return runRemoteCmd(cmd, progress, cancel)
+}
+
+// runRemoteCmd runs cmd and reports the git progress lines in its
+// output to progress (if non-nil). If cancel is closed before cmd
+// exits, cmd and all of its child processes (such as ssh) are killed
+// and errCanceled is returned.
+func runRemoteCmd(cmd *exec.Cmd, progress func(cloneProgress), cancel <-chan struct{}) error {
+	// Report progress lines, and keep all other output for the error
+	// message.
+	var out bytes.Buffer
+	w := &lineWriter{fn: func(line string) {
+		if p, ok := parseGitProgress(line); ok {
+			p.Line = line
+			if progress != nil {
+				progress(p)
+			}
+			return
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}}
+	cmd.Stdout, cmd.Stderr = w, w
+
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-cancel:
+		killProcessGroup(cmd)
+		<-done
+		return errCanceled
+	}
+
+	w.flush()
+	if err != nil {
+		return fmt.Errorf("exec `%s` failed: %s. Output was:\n\n%s", strings.Join(cmd.Args[:2], " "), err, out.Bytes())
+	}
+	return nil
+}
+
+// gitRemoteEnv returns the environment for a git command that
+// communicates with a remote using opt. The SSH key and password (if
+// any) are written to files in tmpDir, which the caller must remove
+// after the command exits.
+func gitRemoteEnv(opt vcs.RemoteOpts) (env []string, tmpDir string, err error) {
+	env = os.Environ()
+	if opt.SSH == nil && opt.HTTPS == nil {
+		return env, "", nil
+	}
+
+	tmpDir, err = ioutil.TempDir("", "vcsstore-git-")
+	if err != nil {
+		return nil, "", err
+	}
+
+	if opt.SSH != nil {
+		keyFile := filepath.Join(tmpDir, "id")
+		if err := ioutil.WriteFile(keyFile, opt.SSH.PrivateKey, 0600); err != nil {
+			return nil, tmpDir, err
+		}
+		sshOpt := "-o ControlMaster=no -o ControlPath=none"
+		if gitcmd.InsecureSkipCheckVerifySSH {
+			sshOpt += " -o StrictHostKeyChecking=no"
+		}
+		sshWrapper := filepath.Join(tmpDir, "ssh")
+		script := "#!/bin/sh\nexec ssh " + sshOpt + " -i '" + keyFile + "' \"$@\"\n"
+		if err := ioutil.WriteFile(sshWrapper, []byte(script), 0500); err != nil {
+			return nil, tmpDir, err
+		}
+		env = setEnv(env, "GIT_SSH", sshWrapper)
+	}
+
+	if opt.HTTPS != nil {
+		passFile := filepath.Join(tmpDir, "password")
+		if err := ioutil.WriteFile(passFile, []byte(opt.HTTPS.Pass), 0600); err != nil {
+			return nil, tmpDir, err
+		}
+		passHelper := filepath.Join(tmpDir, "askpass")
+		script := "#!/bin/sh\ncat '" + passFile + "'\n"
+		if err := ioutil.WriteFile(passHelper, []byte(script), 0500); err != nil {
+			return nil, tmpDir, err
+		}
+		env = setEnv(env, "GIT_ASKPASS", passHelper)
+	}
+
+	return env, tmpDir, nil
+}
+
+// setEnv returns env (a list of "key=value" strings) with key set to
+// value.
+func setEnv(env []string, key, value string) []string {
+	env2 := make([]string, 0, len(env)+1)
+	for _, kv := range env {
+		if !strings.HasPrefix(kv, key+"=") {
+			env2 = append(env2, kv)
+		}
+	}
+	return append(env2, key+"="+value)
+}
+
+type branchLister interface {
+	Branches(vcs.BranchesOptions) ([]*vcs.Branch, error)
+}
+
+// branchHeads returns the head commit of each of the repository's
+// branches.
+func branchHeads(repo branchLister) (map[string]vcs.CommitID, error) {
+	branches, err := repo.Branches(vcs.BranchesOptions{})
+	if err != nil {
+		return nil, err
+	}
+	heads := make(map[string]vcs.CommitID, len(branches))
+	for _, b := range branches {
+		heads[b.Name] = b.Head
+	}
+	return heads, nil
+}
+
+// diffBranchHeads returns an UpdateResult that describes how the
+// branch heads changed from before to after. Updated branches are
+// fast-forwarded if their old head is an ancestor of their new head
+// (if repo can't determine that, they are considered force-updated).
+func diffBranchHeads(repo interface{}, before, after map[string]vcs.CommitID) *vcs.UpdateResult {
+	result := &vcs.UpdateResult{}
+	for name, oldHead := range before {
+		newHead, present := after[name]
+		switch {
+		case !present:
+			result.Changes = append(result.Changes, vcs.Change{Op: vcs.DeletedOp, Branch: name})
+		case newHead != oldHead:
+			op := vcs.ForceUpdatedOp
+			if repo, ok := repo.(vcs.Merger); ok {
+				if base, err := repo.MergeBase(oldHead, newHead); err == nil && base == oldHead {
+					op = vcs.FFUpdatedOp
+				}
+			}
+			result.Changes = append(result.Changes, vcs.Change{Op: op, Branch: name})
+		}
+	}
+	for name := range after {
+		if _, present := before[name]; !present {
+			result.Changes = append(result.Changes, vcs.Change{Op: vcs.NewOp, Branch: name})
+		}
+	}
+	sort.Sort(changesByBranch(result.Changes))
+	return result
+}
+
+type changesByBranch []vcs.Change
+
+func (v changesByBranch) Len() int           { return len(v) }
+func (v changesByBranch) Less(i, j int) bool { return v[i].Branch < v[j].Branch }
+func (v changesByBranch) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
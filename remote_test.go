@@ -0,0 +1,132 @@
+package vcsstore
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// hangingGitSSH sets GIT_SSH to a script that never exits, so that
+// git operations on ssh:// remotes hang. The caller should call the
+// returned func to restore GIT_SSH and remove the script.
+func hangingGitSSH(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "vcsstore-test-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "ssh")
+	if err := ioutil.WriteFile(script, []byte("#!/bin/sh\nsleep 60\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	old := os.Getenv("GIT_SSH")
+	os.Setenv("GIT_SSH", script)
+	return func() {
+		os.Setenv("GIT_SSH", old)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestService_Clone_timeout(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+	defer hangingGitSSH(t)()
+
+	start := time.Now()
+	cloneInfo := &vcsclient.CloneInfo{VCS: "git", CloneURL: "ssh://example.com/x", Timeout: 100 * time.Millisecond}
+	_, err := s.Clone("a.com/x", cloneInfo)
+	if _, ok := err.(*TimeoutError); !ok {
+		t.Fatalf("got error %v, want *TimeoutError", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("clone took %s, want it to be killed after the timeout", d)
+	}
+
+	// The temporary clone dir should have been removed.
+	entries, err := ioutil.ReadDir(filepath.Dir(mustCloneDir(t, s, "a.com/x")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("got %d entries in clone dir's parent, want none (first is %s)", len(entries), entries[0].Name())
+	}
+}
+
+func TestService_Update_timeout(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+	remoteDir := initRemoteGitRepo(t)
+	defer os.RemoveAll(remoteDir)
+
+	h, err := s.Clone("a.com/x", &vcsclient.CloneInfo{VCS: "git", CloneURL: remoteDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+
+	// Point the remote at a hanging ssh:// URL.
+	cmd := exec.Command("git", "config", "remote.origin.url", "ssh://example.com/x")
+	cmd.Dir = mustCloneDir(t, s, "a.com/x")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git config failed: %s\n%s", err, out)
+	}
+	defer hangingGitSSH(t)()
+
+	s.UpdateTimeout = 100 * time.Millisecond
+	_, err = s.Update("a.com/x", &vcsclient.CloneInfo{})
+	if err, ok := err.(*TimeoutError); !ok || err.Op != "update" {
+		t.Fatalf("got error %v, want update *TimeoutError", err)
+	}
+}
+
+func TestService_Clone_hgSSH(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	// The ssh config can't be passed to hg, so the clone must fail
+	// instead of running without it.
+	cloneInfo := &vcsclient.CloneInfo{VCS: "hg", CloneURL: "ssh://example.com/x", RemoteOpts: vcs.RemoteOpts{SSH: &vcs.SSHConfig{User: "u"}}}
+	if _, err := s.Clone("a.com/x", cloneInfo); err == nil || err.Error() != "hg: ssh remote not supported" {
+		t.Fatalf("got error %v, want hg ssh remote not supported", err)
+	}
+	if _, err := os.Stat(mustCloneDir(t, s, "a.com/x")); !os.IsNotExist(err) {
+		t.Errorf("got clone dir stat error %v, want it not to exist", err)
+	}
+}
+
+type mockMerger struct {
+	mergeBases map[[2]vcs.CommitID]vcs.CommitID
+}
+
+func (m mockMerger) MergeBase(a, b vcs.CommitID) (vcs.CommitID, error) {
+	return m.mergeBases[[2]vcs.CommitID{a, b}], nil
+}
+
+func TestDiffBranchHeads(t *testing.T) {
+	repo := mockMerger{mergeBases: map[[2]vcs.CommitID]vcs.CommitID{
+		{"a1", "a2"}: "a1", // fast-forward
+		{"b1", "b2"}: "b0", // force update
+	}}
+	before := map[string]vcs.CommitID{"a": "a1", "b": "b1", "c": "c1", "d": "d1"}
+	after := map[string]vcs.CommitID{"a": "a2", "b": "b2", "c": "c1", "e": "e1"}
+
+	got := diffBranchHeads(repo, before, after)
+	want := &vcs.UpdateResult{Changes: []vcs.Change{
+		{Op: vcs.FFUpdatedOp, Branch: "a"},
+		{Op: vcs.ForceUpdatedOp, Branch: "b"},
+		{Op: vcs.DeletedOp, Branch: "d"},
+		{Op: vcs.NewOp, Branch: "e"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,274 @@
+package vcsstore
+
+import (
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// ScheduledUpdate describes a repository in the update scheduler's
+// queue.
+type ScheduledUpdate struct {
+	RepoPath string
+
+	// NextUpdate is when the repository is next due to be updated.
+	NextUpdate time.Time
+
+	// LastUpdate is when the scheduler last finished updating the
+	// repository (successfully or not).
+	LastUpdate time.Time
+
+	// LastRead is when the repository was last opened by a reader.
+	LastRead time.Time
+
+	// Failures is the number of consecutive failed updates. Each
+	// failure doubles the delay until the next attempt.
+	Failures int
+
+	// LastError is the error message of the last failed update.
+	LastError string `json:",omitempty"`
+
+	// Running is whether the repository is currently being updated.
+	Running bool
+}
+
+const (
+	// defaultUpdateConcurrency is used if Config.UpdateConcurrency is
+	// unset.
+	defaultUpdateConcurrency = 4
+
+	// defaultUpdateMaxBackoffFactor is the multiple of
+	// Config.UpdateInterval that is used as the maximum backoff if
+	// Config.UpdateMaxBackoff is unset.
+	defaultUpdateMaxBackoffFactor = 16
+)
+
+// updateScheduler periodically updates all repositories from their
+// remotes. Due repositories that were read more recently are updated
+// first, and no more than concurrency updates run at once.
+type updateScheduler struct {
+	interval    time.Duration
+	maxBackoff  time.Duration
+	concurrency int
+
+	listRepos func() ([]string, error)        // lists the repositories to keep updated
+	update    func(repoPath string) error     // updates a repository from its remote
+	lastRead  func(repoPath string) time.Time // when a repository was last read (or zero)
+	log       *log.Logger
+
+	mu      sync.Mutex
+	queue   map[string]*ScheduledUpdate
+	running int
+
+	stopped chan struct{}
+}
+
+func newUpdateScheduler(c *Config) *updateScheduler {
+	u := &updateScheduler{
+		interval:    c.UpdateInterval,
+		maxBackoff:  c.UpdateMaxBackoff,
+		concurrency: c.UpdateConcurrency,
+		log:         c.Log,
+		queue:       map[string]*ScheduledUpdate{},
+		stopped:     make(chan struct{}),
+	}
+	if u.maxBackoff == 0 {
+		u.maxBackoff = defaultUpdateMaxBackoffFactor * u.interval
+	}
+	if u.concurrency <= 0 {
+		u.concurrency = defaultUpdateConcurrency
+	}
+	return u
+}
+
+// run dispatches due updates until stop is called. It rescans the
+// set of stored repositories once per interval.
+func (u *updateScheduler) run() {
+	u.refresh()
+
+	tick := u.interval / 10
+	if tick > time.Minute {
+		tick = time.Minute
+	} else if tick <= 0 {
+		tick = u.interval
+	}
+	dispatchTicker := time.NewTicker(tick)
+	defer dispatchTicker.Stop()
+	refreshTicker := time.NewTicker(u.interval)
+	defer refreshTicker.Stop()
+
+	for {
+		select {
+		case <-dispatchTicker.C:
+			u.dispatch()
+		case <-refreshTicker.C:
+			u.refresh()
+		case <-u.stopped:
+			return
+		}
+	}
+}
+
+func (u *updateScheduler) stop() { close(u.stopped) }
+
+// refresh adds newly stored repositories to the queue and removes
+// repositories that no longer exist. New repositories are scheduled
+// at a random time within the next interval, so that updates of
+// existing repositories are spread out after startup.
+func (u *updateScheduler) refresh() {
+	repoPaths, err := u.listRepos()
+	if err != nil {
+		u.log.Printf("Update scheduler: listing repositories failed: %s.", err)
+		return
+	}
+
+	now := time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	exists := make(map[string]struct{}, len(repoPaths))
+	for _, repoPath := range repoPaths {
+		exists[repoPath] = struct{}{}
+		if _, present := u.queue[repoPath]; !present {
+			u.queue[repoPath] = &ScheduledUpdate{
+				RepoPath:   repoPath,
+				NextUpdate: now.Add(time.Duration(rand.Int63n(int64(u.interval)))),
+			}
+		}
+	}
+	for repoPath, e := range u.queue {
+		if _, present := exists[repoPath]; !present && !e.Running {
+			delete(u.queue, repoPath)
+		}
+	}
+}
+
+// dispatch starts updates of due repositories, up to the
+// concurrency limit.
+func (u *updateScheduler) dispatch() {
+	now := time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	var due []*ScheduledUpdate
+	for _, e := range u.queue {
+		if !e.Running && !e.NextUpdate.After(now) {
+			e.LastRead = u.lastRead(e.RepoPath)
+			due = append(due, e)
+		}
+	}
+	sort.Sort(scheduledUpdatesByPriority(due))
+
+	for _, e := range due {
+		if u.running >= u.concurrency {
+			break
+		}
+		e.Running = true
+		u.running++
+		go u.runUpdate(e.RepoPath)
+	}
+}
+
+func (u *updateScheduler) runUpdate(repoPath string) {
+	start := time.Now()
+	err := u.update(repoPath)
+	now := time.Now()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.running--
+	e, present := u.queue[repoPath]
+	if !present {
+		return
+	}
+	e.Running = false
+	e.LastUpdate = now
+	if err != nil {
+		e.Failures++
+		e.LastError = err.Error()
+		e.NextUpdate = now.Add(u.backoff(e.Failures))
+		u.log.Printf("Update scheduler: updating %s failed (%d consecutive failures; retrying at %s): %s.", repoPath, e.Failures, e.NextUpdate, err)
+		return
+	}
+	e.Failures = 0
+	e.LastError = ""
+	e.NextUpdate = now.Add(u.interval)
+	u.log.Printf("Update scheduler: updated %s in %s.", repoPath, now.Sub(start))
+}
+
+// backoff returns the delay before retrying an update after the
+// given number of consecutive failures.
+func (u *updateScheduler) backoff(failures int) time.Duration {
+	d := u.interval
+	for i := 0; i < failures && d < u.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > u.maxBackoff {
+		d = u.maxBackoff
+	}
+	return d
+}
+
+// Queue returns a snapshot of the queue, sorted by next update time.
+func (u *updateScheduler) Queue() []*ScheduledUpdate {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	q := make([]*ScheduledUpdate, 0, len(u.queue))
+	for _, e := range u.queue {
+		e2 := *e
+		q = append(q, &e2)
+	}
+	sort.Sort(scheduledUpdatesByNextUpdate(q))
+	return q
+}
+
+// scheduledUpdatesByPriority sorts the most recently read
+// repositories first, and then the ones that have been due the
+// longest.
+type scheduledUpdatesByPriority []*ScheduledUpdate
+
+func (v scheduledUpdatesByPriority) Len() int      { return len(v) }
+func (v scheduledUpdatesByPriority) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v scheduledUpdatesByPriority) Less(i, j int) bool {
+	if !v[i].LastRead.Equal(v[j].LastRead) {
+		return v[i].LastRead.After(v[j].LastRead)
+	}
+	return v[i].NextUpdate.Before(v[j].NextUpdate)
+}
+
+type scheduledUpdatesByNextUpdate []*ScheduledUpdate
+
+func (v scheduledUpdatesByNextUpdate) Len() int      { return len(v) }
+func (v scheduledUpdatesByNextUpdate) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v scheduledUpdatesByNextUpdate) Less(i, j int) bool {
+	return v[i].NextUpdate.Before(v[j].NextUpdate)
+}
+
+// UpdateQueue returns the background update scheduler's queue,
+// sorted by next update time. If background updates are disabled, it
+// returns nil.
+func (s *service) UpdateQueue() []*ScheduledUpdate {
+	if s.scheduler == nil {
+		return nil
+	}
+	return s.scheduler.Queue()
+}
+
+// listRepoPaths returns the paths of all stored repositories.
+func (s *service) listRepoPaths() ([]string, error) {
+	var repoPaths []string
+	err := walkRepositories(s.StorageDir, "", func(repoPath, cloneDir, vcsType string) error {
+		repoPaths = append(repoPaths, repoPath)
+		return nil
+	})
+	return repoPaths, err
+}
+
+// update updates a stored repository from its default remote.
+func (s *service) update(repoPath string) error {
+	_, err := s.Update(repoPath, &vcsclient.CloneInfo{})
+	return err
+}
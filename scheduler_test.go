@@ -0,0 +1,180 @@
+package vcsstore
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestUpdateScheduler(concurrency int, update func(repoPath string) error) *updateScheduler {
+	u := newUpdateScheduler(&Config{
+		UpdateInterval:    time.Hour,
+		UpdateConcurrency: concurrency,
+		Log:               log.New(ioutil.Discard, "", 0),
+	})
+	u.listRepos = func() ([]string, error) { return []string{"a", "b", "c"}, nil }
+	u.update = update
+	u.lastRead = func(repoPath string) time.Time { return time.Time{} }
+	return u
+}
+
+// makeAllDue makes every repository in the scheduler's queue due for
+// an update.
+func makeAllDue(u *updateScheduler) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for _, e := range u.queue {
+		e.NextUpdate = time.Now().Add(-time.Second)
+	}
+}
+
+// waitIdle waits until the scheduler has no running updates.
+func waitIdle(t *testing.T, u *updateScheduler) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		u.mu.Lock()
+		running := u.running
+		u.mu.Unlock()
+		if running == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for updates to finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUpdateScheduler_prioritizesRecentlyRead(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		updated []string
+	)
+	u := newTestUpdateScheduler(1, func(repoPath string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		updated = append(updated, repoPath)
+		return nil
+	})
+	now := time.Now()
+	u.lastRead = func(repoPath string) time.Time {
+		switch repoPath {
+		case "b":
+			return now
+		case "c":
+			return now.Add(-time.Minute)
+		}
+		return time.Time{}
+	}
+
+	u.refresh()
+	makeAllDue(u)
+	for i := 0; i < 3; i++ {
+		u.dispatch()
+		waitIdle(t, u)
+	}
+
+	if want := []string{"b", "c", "a"}; !stringSlicesEqual(updated, want) {
+		t.Errorf("got update order %v, want %v", updated, want)
+	}
+	for _, e := range u.Queue() {
+		if e.NextUpdate.Before(now.Add(u.interval)) {
+			t.Errorf("%s: got NextUpdate %s, want at least one interval from now", e.RepoPath, e.NextUpdate)
+		}
+	}
+}
+
+func TestUpdateScheduler_concurrencyLimit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan string, 3)
+	u := newTestUpdateScheduler(2, func(repoPath string) error {
+		started <- repoPath
+		<-release
+		return nil
+	})
+
+	u.refresh()
+	makeAllDue(u)
+	u.dispatch()
+	<-started
+	<-started
+	u.dispatch() // should not start a 3rd concurrent update
+	select {
+	case repoPath := <-started:
+		t.Errorf("started update of %s while at concurrency limit", repoPath)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	waitIdle(t, u)
+	u.dispatch()
+	<-started
+	waitIdle(t, u)
+}
+
+func TestUpdateScheduler_backoff(t *testing.T) {
+	u := newTestUpdateScheduler(3, func(repoPath string) error {
+		if repoPath == "b" {
+			return errors.New("x")
+		}
+		return nil
+	})
+	u.maxBackoff = 3 * u.interval
+
+	u.refresh()
+	for i := 1; i <= 3; i++ {
+		makeAllDue(u)
+		start := time.Now()
+		u.dispatch()
+		waitIdle(t, u)
+
+		wantBackoff := u.interval << uint(i)
+		if wantBackoff > u.maxBackoff {
+			wantBackoff = u.maxBackoff
+		}
+		for _, e := range u.Queue() {
+			if e.RepoPath != "b" {
+				if e.Failures != 0 || e.LastError != "" {
+					t.Errorf("%s: got Failures %d and LastError %q, want none", e.RepoPath, e.Failures, e.LastError)
+				}
+				continue
+			}
+			if e.Failures != i {
+				t.Errorf("got Failures %d, want %d", e.Failures, i)
+			}
+			if e.LastError != "x" {
+				t.Errorf("got LastError %q, want %q", e.LastError, "x")
+			}
+			if min, max := start.Add(wantBackoff), time.Now().Add(wantBackoff); e.NextUpdate.Before(min) || e.NextUpdate.After(max) {
+				t.Errorf("after %d failures: got NextUpdate %s, want backoff of %s", i, e.NextUpdate, wantBackoff)
+			}
+		}
+	}
+}
+
+func TestUpdateScheduler_refreshRemovesDeleted(t *testing.T) {
+	u := newTestUpdateScheduler(1, func(string) error { return nil })
+	u.refresh()
+	u.listRepos = func() ([]string, error) { return []string{"a"}, nil }
+	u.refresh()
+
+	q := u.Queue()
+	if len(q) != 1 || q[0].RepoPath != "a" {
+		t.Errorf("got queue %+v, want only repo a", q)
+	}
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
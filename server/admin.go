@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"sourcegraph.com/sourcegraph/vcsstore"
+)
+
+func (h *Handler) serveAdminUpdateQueue(w http.ResponseWriter, r *http.Request) error {
+	type updateQueuer interface {
+		UpdateQueue() []*vcsstore.ScheduledUpdate
+	}
+	if svc, ok := h.Service.(updateQueuer); ok {
+		queue := svc.UpdateQueue()
+		if queue == nil {
+			queue = []*vcsstore.ScheduledUpdate{}
+		}
+		return writeJSON(w, queue)
+	}
+
+	return &httpError{http.StatusNotImplemented, fmt.Errorf("UpdateQueue not yet implemented for %T", h.Service)}
+}
+
+func (h *Handler) serveAdminBrokenRepos(w http.ResponseWriter, r *http.Request) error {
+	type brokenRepoLister interface {
+		BrokenRepositories() []*vcsstore.BrokenRepository
+	}
+	if svc, ok := h.Service.(brokenRepoLister); ok {
+		broken := svc.BrokenRepositories()
+		if broken == nil {
+			broken = []*vcsstore.BrokenRepository{}
+		}
+		return writeJSON(w, broken)
+	}
+
+	return &httpError{http.StatusNotImplemented, fmt.Errorf("BrokenRepositories not yet implemented for %T", h.Service)}
+}
+
+func (h *Handler) serveAdminPools(w http.ResponseWriter, r *http.Request) error {
+	type poolLister interface {
+		Pools() ([]*vcsstore.ObjectPool, error)
+	}
+	if svc, ok := h.Service.(poolLister); ok {
+		pools, err := svc.Pools()
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, pools)
+	}
+
+	return &httpError{http.StatusNotImplemented, fmt.Errorf("Pools not yet implemented for %T", h.Service)}
+}
+
+func (h *Handler) serveAdminMigrateLayout(w http.ResponseWriter, r *http.Request) error {
+	to := vcsstore.DefaultLayout
+	if err := schemaDecoder.Decode(&to, r.URL.Query()); err != nil {
+		return &httpError{http.StatusBadRequest, err}
+	}
+
+	type layoutMigrater interface {
+		MigrateLayout(to vcsstore.Layout) (*vcsstore.LayoutMigration, error)
+	}
+	if svc, ok := h.Service.(layoutMigrater); ok {
+		m, err := svc.MigrateLayout(to)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, m)
+	}
+
+	return &httpError{http.StatusNotImplemented, fmt.Errorf("MigrateLayout not yet implemented for %T", h.Service)}
+}
+
+func (h *Handler) serveAdminGCStatus(w http.ResponseWriter, r *http.Request) error {
+	type maintenanceStatusLister interface {
+		MaintenanceStatuses() []*vcsstore.MaintenanceStatus
+	}
+	if svc, ok := h.Service.(maintenanceStatusLister); ok {
+		return writeJSON(w, svc.MaintenanceStatuses())
+	}
+
+	return &httpError{http.StatusNotImplemented, fmt.Errorf("MaintenanceStatuses not yet implemented for %T", h.Service)}
+}
+
+func (h *Handler) serveAdminGC(w http.ResponseWriter, r *http.Request) error {
+	var opt vcsstore.MaintenanceOptions
+	if err := schemaDecoder.Decode(&opt, r.URL.Query()); err != nil {
+		return &httpError{http.StatusBadRequest, err}
+	}
+
+	type maintainer interface {
+		Maintain(opt vcsstore.MaintenanceOptions) ([]*vcsstore.MaintenanceResult, error)
+	}
+	if svc, ok := h.Service.(maintainer); ok {
+		results, err := svc.Maintain(opt)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, results)
+	}
+
+	return &httpError{http.StatusNotImplemented, fmt.Errorf("Maintain not yet implemented for %T", h.Service)}
+}
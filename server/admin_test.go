@@ -0,0 +1,212 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/vcsstore"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+type mockUpdateQueuer struct {
+	mockService
+	queue []*vcsstore.ScheduledUpdate
+}
+
+func (m *mockUpdateQueuer) UpdateQueue() []*vcsstore.ScheduledUpdate { return m.queue }
+
+func TestServeAdminUpdateQueue(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	testHandler.Service = &mockUpdateQueuer{
+		queue: []*vcsstore.ScheduledUpdate{{RepoPath: "a.b/c", Failures: 2, LastError: "x"}},
+	}
+
+	resp, err := http.Get(server.URL + testHandler.router.URLTo(vcsclient.RouteAdminUpdateQueue).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+
+	var queue []*vcsstore.ScheduledUpdate
+	if err := json.NewDecoder(resp.Body).Decode(&queue); err != nil {
+		t.Fatal(err)
+	}
+	if len(queue) != 1 || queue[0].RepoPath != "a.b/c" || queue[0].Failures != 2 {
+		t.Errorf("got queue %s", asJSON(queue))
+	}
+}
+
+func TestServeAdminUpdateQueue_NotImplemented(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	testHandler.Service = &mockService{t: t}
+
+	resp, err := http.Get(server.URL + testHandler.router.URLTo(vcsclient.RouteAdminUpdateQueue).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusNotImplemented; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+	}
+}
+
+type mockBrokenRepoLister struct {
+	mockService
+	broken []*vcsstore.BrokenRepository
+}
+
+func (m *mockBrokenRepoLister) BrokenRepositories() []*vcsstore.BrokenRepository { return m.broken }
+
+func TestServeAdminBrokenRepos(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	testHandler.Service = &mockBrokenRepoLister{
+		broken: []*vcsstore.BrokenRepository{{RepoPath: "a.b/c", VCS: "git", Problem: "missing HEAD"}},
+	}
+
+	resp, err := http.Get(server.URL + testHandler.router.URLTo(vcsclient.RouteAdminBrokenRepos).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+
+	var broken []*vcsstore.BrokenRepository
+	if err := json.NewDecoder(resp.Body).Decode(&broken); err != nil {
+		t.Fatal(err)
+	}
+	if len(broken) != 1 || broken[0].RepoPath != "a.b/c" || broken[0].Problem != "missing HEAD" {
+		t.Errorf("got broken repos %s", asJSON(broken))
+	}
+}
+
+type mockLayoutMigrater struct {
+	mockService
+	to vcsstore.Layout
+}
+
+func (m *mockLayoutMigrater) MigrateLayout(to vcsstore.Layout) (*vcsstore.LayoutMigration, error) {
+	m.to = to
+	return &vcsstore.LayoutMigration{From: vcsstore.LegacyLayout, To: to, Moved: 3}, nil
+}
+
+func TestServeAdminMigrateLayout(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	svc := &mockLayoutMigrater{}
+	testHandler.Service = svc
+
+	resp, err := http.Post(server.URL+testHandler.router.URLTo(vcsclient.RouteAdminMigrateLayout).String()+"?FanOut=1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+
+	if want := (vcsstore.Layout{Version: 1, FanOut: 1}); svc.to != want {
+		t.Errorf("got layout %+v, want %+v", svc.to, want)
+	}
+	var m *vcsstore.LayoutMigration
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Moved != 3 {
+		t.Errorf("got migration %s", asJSON(m))
+	}
+}
+
+type mockPoolLister struct {
+	mockService
+	pools []*vcsstore.ObjectPool
+}
+
+func (m *mockPoolLister) Pools() ([]*vcsstore.ObjectPool, error) { return m.pools, nil }
+
+func TestServeAdminPools(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	testHandler.Service = &mockPoolLister{
+		pools: []*vcsstore.ObjectPool{{Name: "a.b+c", Members: []string{"a.b/c", "a.b/d"}, Size: 10, SpaceSaved: 10}},
+	}
+
+	resp, err := http.Get(server.URL + testHandler.router.URLTo(vcsclient.RouteAdminPools).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+
+	var pools []*vcsstore.ObjectPool
+	if err := json.NewDecoder(resp.Body).Decode(&pools); err != nil {
+		t.Fatal(err)
+	}
+	if len(pools) != 1 || pools[0].Name != "a.b+c" || pools[0].SpaceSaved != 10 {
+		t.Errorf("got pools %s", asJSON(pools))
+	}
+}
+
+type mockMaintainer struct {
+	mockService
+	opt vcsstore.MaintenanceOptions
+}
+
+func (m *mockMaintainer) Maintain(opt vcsstore.MaintenanceOptions) ([]*vcsstore.MaintenanceResult, error) {
+	m.opt = opt
+	return []*vcsstore.MaintenanceResult{{RepoPath: opt.RepoPath, Tasks: []string{"repack"}, PacksBefore: 60, PacksAfter: 1}}, nil
+}
+
+func TestServeAdminGC(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	svc := &mockMaintainer{}
+	testHandler.Service = svc
+
+	resp, err := http.Post(server.URL+testHandler.router.URLTo(vcsclient.RouteAdminGC).String()+"?RepoPath=a.b/c&Force=true", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+
+	if want := (vcsstore.MaintenanceOptions{RepoPath: "a.b/c", Force: true}); svc.opt != want {
+		t.Errorf("got options %+v, want %+v", svc.opt, want)
+	}
+	var results []*vcsstore.MaintenanceResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].RepoPath != "a.b/c" || results[0].PacksAfter != 1 {
+		t.Errorf("got results %s", asJSON(results))
+	}
+}
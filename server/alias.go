@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/sourcegraph/mux"
+	"sourcegraph.com/sourcegraph/vcsstore"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// repoPathVars are the names of the route vars that contain repository
+// paths.
+var repoPathVars = []string{"RepoPath", "HeadRepoPath", "BRepoPath"}
+
+// canonicalizeRepoPaths replaces the repository paths in the request's
+// route vars with their canonical paths, if the service supports
+// aliases and normalization. If the main repository path (RepoPath)
+// changed, its canonical path is reported in the
+// CanonicalRepoPathHeader header, and GET and HEAD requests are
+// redirected (with 301 Moved Permanently) to the canonical URL, and
+// redirected is true. Other requests, and requests in which only other
+// repository paths (such as the head repository of a cross-repo diff)
+// changed, are served at the canonical paths, so that clients don't
+// need to resend them.
+func (h *Handler) canonicalizeRepoPaths(w http.ResponseWriter, r *http.Request) (redirected bool, err error) {
+	type repoPathCanonicalizer interface {
+		CanonicalRepoPath(repoPath string) string
+	}
+	svc, ok := h.Service.(repoPathCanonicalizer)
+	if !ok {
+		return false, nil
+	}
+
+	v := mux.Vars(r)
+	var repoPathChanged bool
+	for _, name := range repoPathVars {
+		repoPath, present := v[name]
+		if !present {
+			continue
+		}
+		if canonical := svc.CanonicalRepoPath(repoPath); canonical != repoPath {
+			v[name] = canonical
+			if name == "RepoPath" {
+				repoPathChanged = true
+			}
+		}
+	}
+	if !repoPathChanged {
+		return false, nil
+	}
+
+	w.Header().Set(vcsclient.CanonicalRepoPathHeader, v["RepoPath"])
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false, nil
+	}
+
+	pairs := make([]string, 0, 2*len(v))
+	for name, val := range v {
+		pairs = append(pairs, name, val)
+	}
+	u, err := mux.CurrentRoute(r).URLPath(pairs...)
+	if err != nil {
+		return false, err
+	}
+	u.RawQuery = r.URL.RawQuery
+	http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	return true, nil
+}
+
+func (h *Handler) serveAdminAliases(w http.ResponseWriter, r *http.Request) error {
+	type aliasLister interface {
+		Aliases() []*vcsstore.RepositoryAlias
+	}
+	if svc, ok := h.Service.(aliasLister); ok {
+		return writeJSON(w, svc.Aliases())
+	}
+
+	return &httpError{http.StatusNotImplemented, fmt.Errorf("Aliases not yet implemented for %T", h.Service)}
+}
+
+func (h *Handler) serveAdminSetAlias(w http.ResponseWriter, r *http.Request) error {
+	var alias vcsstore.RepositoryAlias
+	if err := json.NewDecoder(r.Body).Decode(&alias); err != nil {
+		return &httpError{http.StatusBadRequest, err}
+	}
+
+	type aliasSetter interface {
+		SetAlias(alias *vcsstore.RepositoryAlias) error
+	}
+	if svc, ok := h.Service.(aliasSetter); ok {
+		if err := svc.SetAlias(&alias); err != nil {
+			if os.IsNotExist(err) {
+				return &httpError{http.StatusNotFound, err}
+			}
+			return err
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	return &httpError{http.StatusNotImplemented, fmt.Errorf("SetAlias not yet implemented for %T", h.Service)}
+}
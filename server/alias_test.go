@@ -0,0 +1,135 @@
+package server
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	vcs_testing "sourcegraph.com/sourcegraph/go-vcs/vcs/testing"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+type mockCanonicalizer struct {
+	mockService
+	canonical map[string]string
+}
+
+func (m *mockCanonicalizer) CanonicalRepoPath(repoPath string) string {
+	if c, ok := m.canonical[repoPath]; ok {
+		return c
+	}
+	return repoPath
+}
+
+func TestCanonicalizeRepoPaths_redirect(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	testHandler.Service = &mockCanonicalizer{
+		mockService: mockService{t: t},
+		canonical:   map[string]string{"a.b/Old": "a.b/new"},
+	}
+
+	u := testHandler.router.URLTo(vcsclient.RouteRepoBranch, "RepoPath", "a.b/Old", "Branch", "master")
+	resp, err := ignoreRedirectsClient.Get(server.URL + u.String() + "?x=y")
+	if err != nil && !isIgnoredRedirectErr(err) {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusMovedPermanently; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+	}
+	wantLoc := testHandler.router.URLTo(vcsclient.RouteRepoBranch, "RepoPath", "a.b/new", "Branch", "master").String() + "?x=y"
+	if loc := resp.Header.Get("location"); loc != wantLoc {
+		t.Errorf("got location %q, want %q", loc, wantLoc)
+	}
+	if got := resp.Header.Get(vcsclient.CanonicalRepoPathHeader); got != "a.b/new" {
+		t.Errorf("got canonical repo path header %q", got)
+	}
+}
+
+func TestCanonicalizeRepoPaths_rewrite(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	var calledRemove bool
+	testHandler.Service = &mockCanonicalizer{
+		mockService: mockService{
+			t:        t,
+			repoPath: "a.b/new",
+			remove: func(repoPath string) error {
+				calledRemove = true
+				return nil
+			},
+		},
+		canonical: map[string]string{"a.b/Old": "a.b/new"},
+	}
+
+	req, err := http.NewRequest("DELETE", server.URL+testHandler.router.URLToRepo("a.b/Old").String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if !calledRemove {
+		t.Errorf("!calledRemove")
+	}
+	if got, want := resp.StatusCode, http.StatusNoContent; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+	}
+	if got := resp.Header.Get(vcsclient.CanonicalRepoPathHeader); got != "a.b/new" {
+		t.Errorf("got canonical repo path header %q", got)
+	}
+}
+
+func TestCanonicalizeRepoPaths_otherRepoPath(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	mockRepoB := vcs_testing.MockRepository{}
+	rm := &mockCrossRepoMergeBase{
+		t:         t,
+		a:         "a",
+		repoB:     mockRepoB,
+		b:         "b",
+		mergeBase: "abcd",
+	}
+	testHandler.Service = &mockCanonicalizer{
+		mockService: mockService{
+			t: t,
+			open: func(repoPath string) (interface{}, error) {
+				switch repoPath {
+				case "a.b/c":
+					return rm, nil
+				case "a.b/new":
+					return mockRepoB, nil
+				}
+				t.Errorf("unexpected repo opened: %s", repoPath)
+				return nil, os.ErrNotExist
+			},
+		},
+		canonical: map[string]string{"a.b/Old": "a.b/new"},
+	}
+
+	// Only the B repo's path is an alias, so the request is served at
+	// its canonical path instead of being redirected (which clients
+	// couldn't tell apart from the merge base redirect).
+	resp, err := ignoreRedirectsClient.Get(server.URL + testHandler.router.URLToRepoCrossRepoMergeBase("a.b/c", "a", "a.b/Old", "b").String())
+	if err != nil && !isIgnoredRedirectErr(err) {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if !rm.called {
+		t.Errorf("!called")
+	}
+	testRedirectedTo(t, resp, http.StatusFound, testHandler.router.URLToRepoCommit("a.b/c", "abcd"))
+	if got := resp.Header.Get(vcsclient.CanonicalRepoPathHeader); got != "" {
+		t.Errorf("got canonical repo path header %q, want none", got)
+	}
+}
@@ -0,0 +1,443 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+var archiveContentTypes = map[string]string{
+	vcsclient.ArchiveTar:   "application/x-tar",
+	vcsclient.ArchiveTarGz: "application/gzip",
+	vcsclient.ArchiveZip:   "application/zip",
+}
+
+// serveRepoArchive streams an archive of the tree at a commit. Git
+// repositories are archived with git archive, and hg repositories
+// with hg archive (unless a subdirectory is archived); the archives of
+// other repositories are built by walking the commit's FileSystem.
+func (h *Handler) serveRepoArchive(w http.ResponseWriter, r *http.Request) error {
+	repo, repoPath, done, err := h.getRepo(r)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	commitID, canon, err := getCommitID(r)
+	if err != nil {
+		return err
+	}
+
+	var opt vcsclient.ArchiveOptions
+	if err := schemaDecoder.Decode(&opt, r.URL.Query()); err != nil {
+		return &httpError{http.StatusBadRequest, err}
+	}
+	if opt.Format == "" {
+		opt.Format = vcsclient.ArchiveTar
+	}
+	contentType, ok := archiveContentTypes[opt.Format]
+	if !ok {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("unknown archive format %q", opt.Format)}
+	}
+	for _, pattern := range opt.Pathspecs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return &httpError{http.StatusBadRequest, fmt.Errorf("bad pathspec %q: %s", pattern, err)}
+		}
+	}
+
+	type fileSystem interface {
+		FileSystem(vcs.CommitID) (vfs.FileSystem, error)
+	}
+	repoFS, ok := repo.(fileSystem)
+	if !ok {
+		return &httpError{http.StatusNotImplemented, fmt.Errorf("FileSystem not yet implemented for %T", repo)}
+	}
+	fs, err := repoFS.FileSystem(commitID)
+	if err != nil {
+		return err
+	}
+
+	dir := path.Clean(strings.TrimPrefix(opt.Path, "/"))
+	if dir == "" {
+		dir = "."
+	}
+	fi, err := fs.Lstat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &httpError{http.StatusNotFound, err}
+		}
+		return err
+	}
+	if !fi.Mode().IsDir() {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("%s is not a directory", dir)}
+	}
+
+	sw := &streamWriter{w: w, start: func() {
+		if canon {
+			setLongCache(w)
+		} else {
+			setShortCache(w)
+		}
+		w.Header().Set("content-type", contentType)
+		filename := fmt.Sprintf("%s-%s.%s", path.Base(repoPath), commitID, opt.Format)
+		w.Header().Set("content-disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}}
+	defer sw.close()
+
+	cmd, err := archiveCmd(repo, commitID, dir, opt)
+	if err == nil {
+		if cmd != nil {
+			err = runArchiveCmd(cmd, sw)
+		} else {
+			err = writeArchive(sw, fs, dir, opt)
+		}
+	}
+	if err != nil {
+		// Once the archive is being written, errors can no longer be
+		// reported with an HTTP status code. Abort the response
+		// instead, so that the client doesn't mistake a truncated
+		// archive for a complete one.
+		if sw.started {
+			h.Log.Printf("Writing archive of %s at %s failed: %s.", repoPath, commitID, err)
+			panic(http.ErrAbortHandler)
+		}
+		if os.IsNotExist(err) {
+			return &httpError{http.StatusNotFound, err}
+		}
+		return err
+	}
+	return nil
+}
+
+// archiveCmd returns a command that writes an archive of the tree at
+// the commit using the repository's VCS, which is much faster than
+// walking its FileSystem. It returns nil if the repository's VCS (or
+// the options) can't be archived that way.
+func archiveCmd(repo interface{}, at vcs.CommitID, dir string, opt vcsclient.ArchiveOptions) (*exec.Cmd, error) {
+	type gitRepository interface {
+		GitRootDir() string
+	}
+	type hgRepository interface {
+		RepoDir() string
+	}
+	switch repo := repo.(type) {
+	case gitRepository:
+		return gitArchiveCmd(repo.GitRootDir(), at, dir, opt)
+	case hgRepository:
+		if dir != "." || !hgArchivePrefixOK(opt.Prefix) {
+			return nil, nil
+		}
+		return hgArchiveCmd(repo.RepoDir(), at, opt), nil
+	}
+	return nil, nil
+}
+
+// gitArchiveCmd returns a git archive command.
+func gitArchiveCmd(gitDir string, at vcs.CommitID, dir string, opt vcsclient.ArchiveOptions) (*exec.Cmd, error) {
+	treeish := string(at)
+	if dir != "." {
+		treeish += ":" + dir
+	}
+	args := []string{"archive", "--format=" + opt.Format, "--prefix=" + opt.Prefix, treeish}
+	if len(opt.Pathspecs) > 0 {
+		pathspecs, err := gitArchivePathspecs(gitDir, treeish, opt.Pathspecs)
+		if err != nil {
+			return nil, err
+		}
+		args = append(append(args, "--"), pathspecs...)
+	}
+	cmd := exec.Command("git", args...)
+	cmd.Dir = gitDir
+	return cmd, nil
+}
+
+// gitArchivePathspecs translates pathspecs (see
+// vcsclient.ArchiveOptions) into git glob pathspecs that match the same
+// files in the tree. A git glob pathspec that matches a directory
+// doesn't match the files in it, so each pathspec P becomes P (for the
+// files it matches) and P/** (for the files in the directories it
+// matches). git archive fails if any of its pathspecs match nothing,
+// so only those that match files in the tree are returned; if none
+// do, an error satisfying os.IsNotExist is returned.
+func gitArchivePathspecs(gitDir, treeish string, pathspecs []string) ([]string, error) {
+	cmd := exec.Command("git", "ls-tree", "-r", "-z", "--name-only", treeish)
+	cmd.Dir = gitDir
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-tree failed: %s", err)
+	}
+
+	matched := map[string]bool{}
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name == "" {
+			continue
+		}
+		for _, pattern := range pathspecs {
+			pattern = path.Clean(pattern)
+			if ok, _ := path.Match(pattern, name); ok {
+				matched[":(glob)"+pattern] = true
+			}
+			for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+				if ok, _ := path.Match(pattern, dir); ok {
+					matched[":(glob)"+pattern+"/**"] = true
+				}
+			}
+		}
+	}
+	if len(matched) == 0 {
+		return nil, &os.PathError{Op: "archive", Path: strings.Join(pathspecs, " "), Err: os.ErrNotExist}
+	}
+
+	gitPathspecs := make([]string, 0, len(matched))
+	for pathspec := range matched {
+		gitPathspecs = append(gitPathspecs, pathspec)
+	}
+	sort.Strings(gitPathspecs)
+	return gitPathspecs, nil
+}
+
+var hgArchiveTypes = map[string]string{
+	vcsclient.ArchiveTar:   "tar",
+	vcsclient.ArchiveTarGz: "tgz",
+	vcsclient.ArchiveZip:   "zip",
+}
+
+// hgArchiveCmd returns an hg archive command for the whole tree. hg's
+// include patterns already match the files in the directories they
+// match, as pathspecs do.
+func hgArchiveCmd(repoDir string, at vcs.CommitID, opt vcsclient.ArchiveOptions) *exec.Cmd {
+	// hg archive uses "<repo>-<rev>/" if the prefix is empty, but it
+	// drops a leading "./".
+	args := []string{"--config", "ui.archivemeta=false", "archive", "--rev", string(at), "--type", hgArchiveTypes[opt.Format], "--prefix", "./" + opt.Prefix}
+	for _, pattern := range opt.Pathspecs {
+		args = append(args, "--include", "glob:"+path.Clean(pattern))
+	}
+	cmd := exec.Command("hg", append(args, "-")...)
+	cmd.Dir = repoDir
+	return cmd
+}
+
+// hgArchivePrefixOK returns whether hg archive uses prefix as is. It
+// treats prefixes as directories (adding a trailing slash), cleans
+// them, and expands "%" escapes in them.
+func hgArchivePrefixOK(prefix string) bool {
+	if prefix == "" {
+		return true
+	}
+	return path.Clean(prefix)+"/" == prefix && !path.IsAbs(prefix) && !strings.HasPrefix(prefix, "../") && !strings.ContainsAny(prefix, "%\\")
+}
+
+// runArchiveCmd runs cmd, writing the archive it outputs to w.
+func runArchiveCmd(cmd *exec.Cmd, w io.Writer) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, stdout); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		msg := bytes.TrimSpace(stderr.Bytes())
+		if bytes.Contains(msg, []byte("did not match any files")) || bytes.Contains(msg, []byte("no files match")) {
+			return &os.PathError{Op: "archive", Path: strings.Join(cmd.Args, " "), Err: os.ErrNotExist}
+		}
+		return fmt.Errorf("%s archive failed: %s: %s", cmd.Args[0], err, msg)
+	}
+	return nil
+}
+
+// writeArchive writes an archive of the files beneath dir in fs to w.
+// If there are pathspecs and none of the files match them, it returns
+// an error satisfying os.IsNotExist before writing anything.
+func writeArchive(w io.Writer, fs vfs.FileSystem, dir string, opt vcsclient.ArchiveOptions) error {
+	var aw archiveWriter
+	switch opt.Format {
+	case vcsclient.ArchiveTar:
+		aw = &tarArchiveWriter{w: tar.NewWriter(w)}
+	case vcsclient.ArchiveTarGz:
+		gw := gzip.NewWriter(w)
+		aw = &tarArchiveWriter{w: tar.NewWriter(gw), gw: gw}
+	case vcsclient.ArchiveZip:
+		aw = &zipArchiveWriter{w: zip.NewWriter(w)}
+	default:
+		return fmt.Errorf("unknown archive format %q", opt.Format)
+	}
+
+	var n int
+	if err := walkArchiveTree(fs, dir, "", opt.Pathspecs, func(name string, fi os.FileInfo) error {
+		n++
+		return aw.add(fs, path.Join(dir, name), opt.Prefix+name, fi)
+	}); err != nil {
+		return err
+	}
+	if n == 0 && len(opt.Pathspecs) > 0 {
+		return &os.PathError{Op: "archive", Path: strings.Join(opt.Pathspecs, " "), Err: os.ErrNotExist}
+	}
+	return aw.close()
+}
+
+// walkArchiveTree calls fn for each file (or symlink) beneath dir in
+// fs that matches pathspecs, with its name relative to dir. Files in
+// a directory that matches a pathspec are included, too. If pathspecs
+// is empty, all files are included.
+func walkArchiveTree(fs vfs.FileSystem, dir, rel string, pathspecs []string, fn func(name string, fi os.FileInfo) error) error {
+	fis, err := fs.ReadDir(path.Join(dir, rel))
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		name := path.Join(rel, fi.Name())
+		matched := matchPathspecs(pathspecs, name)
+		switch {
+		case fi.Mode().IsDir():
+			specs := pathspecs
+			if matched {
+				specs = nil
+			}
+			if err := walkArchiveTree(fs, dir, name, specs, fn); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular() || fi.Mode()&os.ModeSymlink != 0:
+			if matched {
+				if err := fn(name, fi); err != nil {
+					return err
+				}
+			}
+		}
+		// Other entries (such as submodules) are omitted.
+	}
+	return nil
+}
+
+// matchPathspecs returns whether name matches any of pathspecs (or
+// true if there are none).
+func matchPathspecs(pathspecs []string, name string) bool {
+	if len(pathspecs) == 0 {
+		return true
+	}
+	for _, pattern := range pathspecs {
+		if ok, _ := path.Match(path.Clean(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// An archiveWriter adds files from a vfs.FileSystem to an archive.
+type archiveWriter interface {
+	add(fs vfs.FileSystem, path, name string, fi os.FileInfo) error
+	close() error
+}
+
+// archiveFileMode returns the permission bits to archive a regular
+// file with.
+func archiveFileMode(fi os.FileInfo) os.FileMode {
+	if fi.Mode()&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
+
+// symlinkDest returns the destination of the symlink at path.
+func symlinkDest(path string, fi os.FileInfo) (string, error) {
+	if si, ok := fi.Sys().(vcs.SymlinkInfo); ok {
+		return si.Dest, nil
+	}
+	return "", fmt.Errorf("can't determine destination of symlink %s", path)
+}
+
+type tarArchiveWriter struct {
+	w  *tar.Writer
+	gw *gzip.Writer // nil if uncompressed
+}
+
+func (aw *tarArchiveWriter) add(fs vfs.FileSystem, path, name string, fi os.FileInfo) error {
+	hdr := &tar.Header{Name: name, ModTime: fi.ModTime()}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		dest, err := symlinkDest(path, fi)
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag, hdr.Linkname, hdr.Mode = tar.TypeSymlink, dest, 0777
+		return aw.w.WriteHeader(hdr)
+	}
+
+	f, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hdr.Typeflag, hdr.Mode, hdr.Size = tar.TypeReg, int64(archiveFileMode(fi)), fi.Size()
+	if err := aw.w.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(aw.w, f)
+	return err
+}
+
+func (aw *tarArchiveWriter) close() error {
+	if err := aw.w.Close(); err != nil {
+		return err
+	}
+	if aw.gw != nil {
+		return aw.gw.Close()
+	}
+	return nil
+}
+
+type zipArchiveWriter struct {
+	w *zip.Writer
+}
+
+func (aw *zipArchiveWriter) add(fs vfs.FileSystem, path, name string, fi os.FileInfo) error {
+	hdr := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: fi.ModTime()}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		dest, err := symlinkDest(path, fi)
+		if err != nil {
+			return err
+		}
+		hdr.SetMode(os.ModeSymlink | 0777)
+		fw, err := aw.w.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, dest)
+		return err
+	}
+
+	f, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hdr.SetMode(archiveFileMode(fi))
+	fw, err := aw.w.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
+
+func (aw *zipArchiveWriter) close() error { return aw.w.Close() }
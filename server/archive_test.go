@@ -0,0 +1,216 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/go-vcs/vcs/gitcmd"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func TestServeRepoArchive(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	commitID := vcs.CommitID(strings.Repeat("a", 40))
+
+	repoPath := "a.b/c"
+	testHandler.Service = &mockServiceForExistingRepo{
+		t:        t,
+		repoPath: repoPath,
+		repo: &mockFileSystem{
+			t:  t,
+			at: commitID,
+			fs: mapFS(map[string]string{
+				"README":       "readme",
+				"src/a.go":     "package a",
+				"src/a.txt":    "a",
+				"src/sub/b.go": "package b",
+			}),
+		},
+	}
+
+	resp, data := getArchive(t, repoPath, commitID, vcsclient.ArchiveOptions{})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status code %d, want 200", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("content-type"); ct != "application/x-tar" {
+		t.Errorf("got content-type %q, want application/x-tar", ct)
+	}
+	if cc := resp.Header.Get("cache-control"); cc != longCacheControl {
+		t.Errorf("got cache-control %q, want %q", cc, longCacheControl)
+	}
+	if files, want := tarFiles(t, bytes.NewReader(data)), map[string]string{"README": "readme", "src/a.go": "package a", "src/a.txt": "a", "src/sub/b.go": "package b"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got tar files %v, want %v", files, want)
+	}
+
+	// A subdirectory, limited by pathspecs, gzipped.
+	resp, data = getArchive(t, repoPath, commitID, vcsclient.ArchiveOptions{Format: vcsclient.ArchiveTarGz, Path: "src", Pathspecs: []string{"*.go", "sub"}, Prefix: "p/"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status code %d, want 200", resp.StatusCode)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files, want := tarFiles(t, gr), map[string]string{"p/a.go": "package a", "p/sub/b.go": "package b"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got tar.gz files %v, want %v", files, want)
+	}
+
+	resp, data = getArchive(t, repoPath, commitID, vcsclient.ArchiveOptions{Format: vcsclient.ArchiveZip, Path: "src/sub"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status code %d, want 200", resp.StatusCode)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "b.go" {
+		t.Errorf("got zip files %+v, want only b.go", zr.File)
+	}
+
+	for _, opt := range []vcsclient.ArchiveOptions{{Format: "rar"}, {Path: "README"}, {Pathspecs: []string{"["}}} {
+		if resp, _ := getArchive(t, repoPath, commitID, opt); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%+v: got status code %d, want 400", opt, resp.StatusCode)
+		}
+	}
+	if resp, _ := getArchive(t, repoPath, commitID, vcsclient.ArchiveOptions{Path: "doesntexist"}); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("nonexistent dir: got status code %d, want 404", resp.StatusCode)
+	}
+	if resp, _ := getArchive(t, repoPath, commitID, vcsclient.ArchiveOptions{Pathspecs: []string{"*.c"}}); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unmatched pathspec: got status code %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestServeRepoArchive_git(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	dir, err := ioutil.TempDir("", "vcsstore-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, args := range [][]string{
+		{"init"},
+		{"config", "user.name", "a"},
+		{"config", "user.email", "a@a.com"},
+	} {
+		if out, err := gitCmd(dir, args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %s\n%s", args, err, out)
+		}
+	}
+	for name, data := range map[string]string{
+		"README":       "readme",
+		"src/a.go":     "package a",
+		"src/a.txt":    "a",
+		"src/sub/b.go": "package b",
+	} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, args := range [][]string{{"add", "."}, {"commit", "-m", "x"}} {
+		if out, err := gitCmd(dir, args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %s\n%s", args, err, out)
+		}
+	}
+	out, err := gitCmd(dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitID := vcs.CommitID(strings.TrimSpace(string(out)))
+
+	repoPath := "a.b/c"
+	testHandler.Service = &mockServiceForExistingRepo{
+		t:        t,
+		repoPath: repoPath,
+		repo:     &gitcmd.Repository{Dir: dir},
+	}
+
+	resp, data := getArchive(t, repoPath, commitID, vcsclient.ArchiveOptions{Path: "src", Pathspecs: []string{"*.go", "s*", "*.c"}, Prefix: "p/"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status code %d, want 200", resp.StatusCode)
+	}
+	if cd, want := resp.Header.Get("content-disposition"), "attachment; filename=c-"+string(commitID)+".tar"; cd != want {
+		t.Errorf("got content-disposition %q, want %q", cd, want)
+	}
+	if files, want := tarFiles(t, bytes.NewReader(data)), map[string]string{"p/a.go": "package a", "p/sub/b.go": "package b"}; !reflect.DeepEqual(files, want) {
+		t.Errorf("got tar files %v, want %v", files, want)
+	}
+
+	if resp, _ := getArchive(t, repoPath, commitID, vcsclient.ArchiveOptions{Pathspecs: []string{"*.c"}}); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unmatched pathspec: got status code %d, want 404", resp.StatusCode)
+	}
+}
+
+func gitCmd(dir string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	return cmd
+}
+
+func getArchive(t *testing.T, repoPath string, commitID vcs.CommitID, opt vcsclient.ArchiveOptions) (*http.Response, []byte) {
+	u := testHandler.router.URLTo(vcsclient.RouteRepoArchive, "RepoPath", repoPath, "CommitID", string(commitID))
+	q := u.Query()
+	if opt.Format != "" {
+		q.Set("Format", opt.Format)
+	}
+	if opt.Path != "" {
+		q.Set("Path", opt.Path)
+	}
+	for _, p := range opt.Pathspecs {
+		q.Add("Pathspecs", p)
+	}
+	if opt.Prefix != "" {
+		q.Set("Prefix", opt.Prefix)
+	}
+	u.RawQuery = q.Encode()
+	resp, err := http.Get(server.URL + u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+// tarFiles returns the contents of the regular files in the tar
+// archive, by name.
+func tarFiles(t *testing.T, r io.Reader) map[string]string {
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, _ := ioutil.ReadAll(tr)
+		files[hdr.Name] = string(data)
+	}
+	return files
+}
@@ -51,17 +51,11 @@ func (h *Handler) serveRepoDiff(w http.ResponseWriter, r *http.Request) error {
 func (h *Handler) serveRepoCrossRepoDiff(w http.ResponseWriter, r *http.Request) error {
 	v := mux.Vars(r)
 
-	baseRepo, _, doneBase, err := h.getRepo(r)
+	baseRepo, headRepo, _, done, err := h.getRepoPair(r, "Head")
 	if err != nil {
 		return err
 	}
-	defer doneBase()
-
-	headRepo, _, doneHead, err := h.getRepoLabeled(r, "Head")
-	if err != nil {
-		return err
-	}
-	defer doneHead()
+	defer done()
 
 	var opt vcs.DiffOptions
 	if err := schemaDecoder.Decode(&opt, r.URL.Query()); err != nil {
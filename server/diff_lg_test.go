@@ -95,7 +95,7 @@ func openAndCloneRepo(t *testing.T, c *vcsclient.Client, repoPath string, cloneI
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := repo.(vcsclient.RepositoryCloneUpdater).CloneOrUpdate(cloneInfo); err != nil {
+	if _, err := repo.(vcsclient.RepositoryCloneUpdater).CloneOrUpdate(cloneInfo); err != nil {
 		t.Fatal(err)
 	}
 	return repo
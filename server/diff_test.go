@@ -136,6 +136,49 @@ func TestServeRepoCrossRepoDiff(t *testing.T) {
 	}
 }
 
+func TestServeRepoCrossRepoDiff_sameRepo(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	repoPath := "a.b/c"
+	var called bool
+	mockRepo := vcs_testing.MockRepository{
+		CrossRepoDiff_: func(base vcs.CommitID, headRepo vcs.Repository, head vcs.CommitID, opt *vcs.DiffOptions) (*vcs.Diff, error) {
+			called = true
+			return &vcs.Diff{Raw: "diff"}, nil
+		},
+	}
+	var opens int
+	sm := &mockService{
+		t:        t,
+		repoPath: repoPath,
+		open: func(repoPath string) (interface{}, error) {
+			opens++
+			return mockRepo, nil
+		},
+	}
+	testHandler.Service = sm
+
+	base, head := vcs.CommitID(strings.Repeat("a", 40)), vcs.CommitID(strings.Repeat("b", 40))
+	resp, err := http.Get(server.URL + testHandler.router.URLToRepoCrossRepoDiff(repoPath, base, repoPath, head, nil).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+
+	if !called {
+		t.Errorf("!called")
+	}
+	// The base and head are the same repository, so it must only be
+	// acquired once (acquiring it twice can deadlock).
+	if opens != 1 {
+		t.Errorf("got %d acquires, want 1", opens)
+	}
+}
+
 type mockCrossRepoDiff struct {
 	t *testing.T
 
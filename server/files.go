@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// serveRepoFiles streams a flat listing of the files in the tree at a
+// commit as a JSON array, writing each file as it is listed.
+func (h *Handler) serveRepoFiles(w http.ResponseWriter, r *http.Request) error {
+	repoPath, err := h.getRepoPath(r, "")
+	if err != nil {
+		return err
+	}
+
+	commitID, canon, err := getCommitID(r)
+	if err != nil {
+		return err
+	}
+
+	var opt vcsclient.FileListOptions
+	if err := schemaDecoder.Decode(&opt, r.URL.Query()); err != nil {
+		return &httpError{http.StatusBadRequest, err}
+	}
+	if _, err := path.Match(opt.Glob, ""); err != nil {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("bad glob %q: %s", opt.Glob, err)}
+	}
+
+	type fileLister interface {
+		ListFiles(repoPath string, at vcs.CommitID, opt vcsclient.FileListOptions, fn func(*vcsclient.FileListEntry) error) error
+	}
+	svc, ok := h.Service.(fileLister)
+	if !ok {
+		return &httpError{http.StatusNotImplemented, fmt.Errorf("ListFiles not yet implemented for %T", h.Service)}
+	}
+
+	// The response is started when the first file is listed, so that
+	// errors that occur before then (such as a nonexistent commit) can
+	// still be reported with an HTTP status code. ListFiles holds the
+	// repository's read lock while files are written, so the writes
+	// time out if the client stops reading.
+	sw := &streamWriter{w: w, start: func() {
+		if canon {
+			setLongCache(w)
+		} else {
+			setShortCache(w)
+		}
+		w.Header().Set("content-type", "application/json; charset=utf-8")
+		w.Write([]byte("["))
+	}}
+	defer sw.close()
+	enc := json.NewEncoder(sw)
+	err = svc.ListFiles(repoPath, commitID, opt, func(e *vcsclient.FileListEntry) error {
+		if sw.started {
+			if _, err := sw.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		return enc.Encode(e)
+	})
+	if err != nil {
+		if sw.started {
+			h.Log.Printf("Listing files of %s at %s failed: %s.", repoPath, commitID, err)
+			panic(http.ErrAbortHandler)
+		}
+		if os.IsNotExist(err) {
+			return &httpError{http.StatusNotFound, vcsclient.ErrRepoNotExist}
+		}
+		return err
+	}
+	_, err = sw.Write([]byte("]\n"))
+	return err
+}
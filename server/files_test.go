@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+type mockFileLister struct {
+	mockService
+	files []*vcsclient.FileListEntry
+	err   error
+
+	repoPath string
+	at       vcs.CommitID
+	opt      vcsclient.FileListOptions
+}
+
+func (m *mockFileLister) ListFiles(repoPath string, at vcs.CommitID, opt vcsclient.FileListOptions, fn func(*vcsclient.FileListEntry) error) error {
+	m.repoPath, m.at, m.opt = repoPath, at, opt
+	if m.err != nil {
+		return m.err
+	}
+	for _, f := range m.files {
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestServeRepoFiles(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	commitID := vcs.CommitID(strings.Repeat("a", 40))
+	files := []*vcsclient.FileListEntry{{Path: "a", Mode: 0644, Size: 1}, {Path: "b/c", Mode: 0755, Size: 2}}
+	svc := &mockFileLister{files: files}
+	testHandler.Service = svc
+
+	get := func(query string) *http.Response {
+		u := testHandler.router.URLTo(vcsclient.RouteRepoFiles, "RepoPath", "a.b/c", "CommitID", string(commitID))
+		resp, err := http.Get(server.URL + u.String() + query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := get("?Glob=*.go&Pathspecs=b&Pathspecs=c&N=2&Skip=1")
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+	if want := (vcsclient.FileListOptions{Glob: "*.go", Pathspecs: []string{"b", "c"}, N: 2, Skip: 1}); svc.repoPath != "a.b/c" || svc.at != commitID || !reflect.DeepEqual(svc.opt, want) {
+		t.Errorf("got ListFiles(%q, %q, %+v), want a.b/c, %q, %+v", svc.repoPath, svc.at, svc.opt, commitID, want)
+	}
+	var got []*vcsclient.FileListEntry
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, files) {
+		t.Errorf("got files %s, want %s", asJSON(got), asJSON(files))
+	}
+
+	// An empty listing is still a JSON array.
+	svc.files = nil
+	resp = get("")
+	defer resp.Body.Close()
+	var empty []*vcsclient.FileListEntry
+	if err := json.NewDecoder(resp.Body).Decode(&empty); err != nil || empty == nil || len(empty) != 0 {
+		t.Errorf("got files %v (err %v), want empty array", empty, err)
+	}
+
+	svc.err = vcs.ErrCommitNotFound
+	if resp := get(""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("nonexistent commit: got code %d, want 404", resp.StatusCode)
+	}
+	if resp := get("?Glob=["); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad glob: got code %d, want 400", resp.StatusCode)
+	}
+	svc.err = &vcsclient.UnsupportedError{Capability: vcsclient.CapFileLister, ImplementationType: "hg"}
+	if resp := get(""); resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("unsupported VCS: got code %d, want 501", resp.StatusCode)
+	}
+}
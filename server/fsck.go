@@ -0,0 +1,34 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func (h *Handler) serveRepoFsck(w http.ResponseWriter, r *http.Request) error {
+	repoPath, err := h.getRepoPath(r, "")
+	if err != nil {
+		return err
+	}
+
+	var opt vcsclient.FsckOptions
+	if err := schemaDecoder.Decode(&opt, r.URL.Query()); err != nil {
+		return &httpError{http.StatusBadRequest, err}
+	}
+
+	type fscker interface {
+		Fsck(repoPath string, opt vcsclient.FsckOptions) (*vcsclient.FsckResult, error)
+	}
+	svc, ok := h.Service.(fscker)
+	if !ok {
+		return &httpError{http.StatusNotImplemented, fmt.Errorf("Fsck not yet implemented for %T", h.Service)}
+	}
+
+	result, err := svc.Fsck(repoPath, opt)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, result)
+}
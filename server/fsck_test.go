@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+type mockFscker struct {
+	mockService
+	repoPath string
+	opt      vcsclient.FsckOptions
+}
+
+func (m *mockFscker) Fsck(repoPath string, opt vcsclient.FsckOptions) (*vcsclient.FsckResult, error) {
+	m.repoPath, m.opt = repoPath, opt
+	return &vcsclient.FsckResult{
+		Findings:         []*vcsclient.FsckFinding{{Kind: "missing", ObjectType: "blob", Object: "abcd"}},
+		MarkedForReclone: opt.MarkForReclone,
+	}, nil
+}
+
+func TestServeRepoFsck(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	svc := &mockFscker{}
+	testHandler.Service = svc
+
+	u := testHandler.router.URLTo(vcsclient.RouteRepoFsck, "RepoPath", "a.b/c").String() + "?MarkForReclone=true"
+	resp, err := http.Post(server.URL+u, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+
+	if svc.repoPath != "a.b/c" || !svc.opt.MarkForReclone {
+		t.Errorf("got Fsck(%q, %+v), want a.b/c with MarkForReclone", svc.repoPath, svc.opt)
+	}
+	var result *vcsclient.FsckResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.OK || len(result.Findings) != 1 || !result.MarkedForReclone {
+		t.Errorf("got result %s", asJSON(result))
+	}
+}
@@ -23,6 +23,12 @@ func (h *Handler) serveInfoRefs(w http.ResponseWriter, r *http.Request) error {
 		service = rawService[len("git-"):]
 	}
 
+	unlock, err := h.lockRepo(repoPath, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	t, err := h.GitTransporter.GitTransport(repoPath)
 	if err != nil {
 		return err
@@ -49,6 +55,12 @@ func (h *Handler) serveReceivePack(w http.ResponseWriter, r *http.Request) error
 	var opt git.GitTransportOpt
 	opt.ContentEncoding = r.Header.Get("content-encoding")
 
+	unlock, err := h.lockRepo(repoPath, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	t, err := h.GitTransporter.GitTransport(repoPath)
 	if err != nil {
 		return err
@@ -63,6 +75,12 @@ func (h *Handler) serveUploadPack(w http.ResponseWriter, r *http.Request) error
 		return err
 	}
 
+	unlock, err := h.lockRepo(repoPath, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	t, err := h.GitTransporter.GitTransport(repoPath)
 	if err != nil {
 		return err
@@ -77,6 +95,19 @@ func (h *Handler) serveUploadPack(w http.ResponseWriter, r *http.Request) error
 	return nil
 }
 
+// lockRepo takes the repository's read lock (or its write lock, if
+// exclusive is true), if the service supports locking, and returns a
+// func that releases it.
+func (h *Handler) lockRepo(repoPath string, exclusive bool) (unlock func(), err error) {
+	type repoLocker interface {
+		LockRepo(repoPath string, exclusive bool) (unlock func(), err error)
+	}
+	if svc, ok := h.Service.(repoLocker); ok {
+		return svc.LockRepo(repoPath, exclusive)
+	}
+	return func() {}, nil
+}
+
 // Helpers copied from githttp
 func hdrNocache(w http.ResponseWriter) {
 	w.Header().Set("Expires", "Fri, 01 Jan 1980 00:00:00 GMT")
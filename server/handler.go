@@ -57,8 +57,22 @@ func NewHandler(svc vcsstore.Service, gitTrans git.GitTransporter, parent *mux.R
 	r.Get(git.RouteGitReceivePack).Handler(handler(h.serveReceivePack))
 
 	r.Get(vcsclient.RouteRoot).Handler(handler(h.serveRoot))
+	r.Get(vcsclient.RouteAdminUpdateQueue).Handler(handler(h.serveAdminUpdateQueue))
+	r.Get(vcsclient.RouteAdminBrokenRepos).Handler(handler(h.serveAdminBrokenRepos))
+	r.Get(vcsclient.RouteAdminMigrateLayout).Handler(handler(h.serveAdminMigrateLayout))
+	r.Get(vcsclient.RouteAdminAliases).Handler(handler(h.serveAdminAliases))
+	r.Get(vcsclient.RouteAdminSetAlias).Handler(handler(h.serveAdminSetAlias))
+	r.Get(vcsclient.RouteAdminPools).Handler(handler(h.serveAdminPools))
+	r.Get(vcsclient.RouteAdminGCStatus).Handler(handler(h.serveAdminGCStatus))
+	r.Get(vcsclient.RouteAdminGC).Handler(handler(h.serveAdminGC))
+	r.Get(vcsclient.RouteRepos).Handler(handler(h.serveRepos))
 	r.Get(vcsclient.RouteRepo).Handler(handler(h.serveRepo))
 	r.Get(vcsclient.RouteRepoCreateOrUpdate).Handler(handler(h.serveRepoCreateOrUpdate))
+	r.Get(vcsclient.RouteRepoRemove).Handler(handler(h.serveRepoRemove))
+	r.Get(vcsclient.RouteRepoJob).Handler(handler(h.serveRepoJob))
+	r.Get(vcsclient.RouteRepoProgress).Handler(handler(h.serveRepoProgress))
+	r.Get(vcsclient.RouteRepoFsck).Handler(handler(h.serveRepoFsck))
+	r.Get(vcsclient.RouteRepoStats).Handler(handler(h.serveRepoStats))
 	r.Get(vcsclient.RouteRepoBlameFile).Handler(handler(h.serveRepoBlameFile))
 	r.Get(vcsclient.RouteRepoBranch).Handler(handler(h.serveRepoBranch))
 	r.Get(vcsclient.RouteRepoBranches).Handler(handler(h.serveRepoBranches))
@@ -74,6 +88,10 @@ func NewHandler(svc vcsstore.Service, gitTrans git.GitTransporter, parent *mux.R
 	r.Get(vcsclient.RouteRepoTag).Handler(handler(h.serveRepoTag))
 	r.Get(vcsclient.RouteRepoTags).Handler(handler(h.serveRepoTags))
 	r.Get(vcsclient.RouteRepoTreeEntry).Handler(handler(h.serveRepoTreeEntry))
+	r.Get(vcsclient.RouteRepoTreeEntryBatch).Handler(handler(h.serveRepoTreeEntryBatch))
+	r.Get(vcsclient.RouteRepoRawFile).Handler(handler(h.serveRepoRawFile))
+	r.Get(vcsclient.RouteRepoArchive).Handler(handler(h.serveRepoArchive))
+	r.Get(vcsclient.RouteRepoFiles).Handler(handler(h.serveRepoFiles))
 
 	return h
 }
@@ -93,7 +111,10 @@ type robustHandler struct {
 // robust handler wraps f to handle errors it returns.
 func (h robustHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	innerHandler := func(w http.ResponseWriter, r *http.Request) {
-		err := h.handlerFunc(w, r)
+		redirected, err := h.h.canonicalizeRepoPaths(w, r)
+		if err == nil && !redirected {
+			err = h.handlerFunc(w, r)
+		}
 		if err != nil {
 			c := errorHTTPStatusCode(err)
 			h.h.Log.Printf("HTTP %d error serving %q: %s.", c, r.URL.RequestURI(), err)
@@ -116,12 +137,19 @@ func errorBody(debug bool, err error) string {
 // writeJSON writes a JSON Content-Type header and a JSON-encoded object to the
 // http.ResponseWriter.
 func writeJSON(w http.ResponseWriter, v interface{}) error {
+	return writeJSONWithStatus(w, http.StatusOK, v)
+}
+
+// writeJSONWithStatus is like writeJSON, but it writes the given HTTP
+// status code instead of 200 OK.
+func writeJSONWithStatus(w http.ResponseWriter, statusCode int, v interface{}) error {
 	data, err := json.MarshalIndent(v, "", "  ")
 	if err != nil {
 		return &httpError{http.StatusInternalServerError, err}
 	}
 
 	w.Header().Set("content-type", "application/json; charset=utf-8")
+	w.WriteHeader(statusCode)
 	_, err = w.Write(data)
 	return err
 }
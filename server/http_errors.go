@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
 )
 
 type httpError struct {
@@ -33,6 +34,9 @@ func errorHTTPStatusCode(err error) int {
 	if err, ok := err.(httpStatusCoder); ok {
 		return err.httpStatusCode()
 	}
+	if _, ok := err.(*vcsclient.UnsupportedError); ok {
+		return http.StatusNotImplemented
+	}
 	if os.IsNotExist(err) {
 		return http.StatusNotFound
 	}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sourcegraph/mux"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// jobRunner is implemented by services that can clone or update
+// repositories asynchronously.
+type jobRunner interface {
+	StartCloneOrUpdate(repoPath string, cloneInfo *vcsclient.CloneInfo) (*vcsclient.Job, error)
+	Job(repoPath, id string) (*vcsclient.Job, error)
+}
+
+func (h *Handler) serveRepoStartCloneOrUpdate(w http.ResponseWriter, r *http.Request, cloneInfo *vcsclient.CloneInfo) error {
+	repoPath, err := h.getRepoPath(r, "")
+	if err != nil {
+		return err
+	}
+
+	svc, ok := h.Service.(jobRunner)
+	if !ok {
+		return &httpError{http.StatusNotImplemented, fmt.Errorf("Async clone or update not yet implemented for %T", h.Service)}
+	}
+
+	job, err := svc.StartCloneOrUpdate(repoPath, cloneInfo)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("location", h.router.URLToRepoJob(repoPath, job.ID).String())
+	return writeJSONWithStatus(w, http.StatusAccepted, job)
+}
+
+func (h *Handler) serveRepoJob(w http.ResponseWriter, r *http.Request) error {
+	repoPath, err := h.getRepoPath(r, "")
+	if err != nil {
+		return err
+	}
+
+	svc, ok := h.Service.(jobRunner)
+	if !ok {
+		return &httpError{http.StatusNotImplemented, fmt.Errorf("Jobs not yet implemented for %T", h.Service)}
+	}
+
+	job, err := svc.Job(repoPath, mux.Vars(r)["JobID"])
+	if err != nil {
+		if err == vcsclient.ErrJobNotExist {
+			err = &httpError{http.StatusNotFound, err}
+		}
+		return err
+	}
+
+	return writeJSON(w, job)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+type mockJobRunner struct {
+	mockService
+	startCloneOrUpdate func(repoPath string, cloneInfo *vcsclient.CloneInfo) (*vcsclient.Job, error)
+	job                func(repoPath, id string) (*vcsclient.Job, error)
+}
+
+func (m *mockJobRunner) StartCloneOrUpdate(repoPath string, cloneInfo *vcsclient.CloneInfo) (*vcsclient.Job, error) {
+	return m.startCloneOrUpdate(repoPath, cloneInfo)
+}
+
+func (m *mockJobRunner) Job(repoPath, id string) (*vcsclient.Job, error) {
+	return m.job(repoPath, id)
+}
+
+func TestServeRepoCreateOrUpdate_Async(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	repoPath := "a.b/c"
+	opt := vcsclient.CloneInfo{VCS: "git", CloneURL: "https://a.b/c.git"}
+	var calledStart bool
+	testHandler.Service = &mockJobRunner{
+		mockService: mockService{t: t},
+		startCloneOrUpdate: func(repoPath2 string, cloneInfo *vcsclient.CloneInfo) (*vcsclient.Job, error) {
+			calledStart = true
+			if repoPath2 != repoPath {
+				t.Errorf("got repoPath %q, want %q", repoPath2, repoPath)
+			}
+			if !reflect.DeepEqual(*cloneInfo, opt) {
+				t.Errorf("got cloneInfo %+v, want %+v", cloneInfo, opt)
+			}
+			return &vcsclient.Job{ID: "123", RepoPath: repoPath, State: vcsclient.JobRunning}, nil
+		},
+	}
+
+	body, _ := json.Marshal(opt)
+	resp, err := http.Post(server.URL+testHandler.router.URLToRepo(repoPath).String()+"?Async=true", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if !calledStart {
+		t.Errorf("!calledStart")
+	}
+	if got, want := resp.StatusCode, http.StatusAccepted; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+	if got, want := resp.Header.Get("location"), testHandler.router.URLToRepoJob(repoPath, "123").String(); got != want {
+		t.Errorf("got location %q, want %q", got, want)
+	}
+
+	var job *vcsclient.Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != "123" || job.State != vcsclient.JobRunning {
+		t.Errorf("got job %+v", job)
+	}
+}
+
+func TestServeRepoJob(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	repoPath := "a.b/c"
+	want := &vcsclient.Job{
+		ID:              "123",
+		RepoPath:        repoPath,
+		State:           vcsclient.JobSucceeded,
+		ReceivedObjects: 10,
+		TotalObjects:    10,
+		ReceivedBytes:   1024,
+		Result:          &vcs.UpdateResult{Changes: []vcs.Change{{Op: vcs.NewOp, Branch: "master"}}},
+	}
+	testHandler.Service = &mockJobRunner{
+		mockService: mockService{t: t},
+		job: func(repoPath, id string) (*vcsclient.Job, error) {
+			if id != want.ID {
+				return nil, vcsclient.ErrJobNotExist
+			}
+			return want, nil
+		},
+	}
+
+	resp, err := http.Get(server.URL + testHandler.router.URLToRepoJob(repoPath, "123").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+	var job *vcsclient.Job
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(job, want) {
+		t.Errorf("got job %+v, want %+v", job, want)
+	}
+
+	resp, err = http.Get(server.URL + testHandler.router.URLToRepoJob(repoPath, "456").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusNotFound; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+	}
+}
+
+func TestServeRepoJob_NotImplemented(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	testHandler.Service = &mockService{t: t}
+
+	resp, err := http.Get(server.URL + testHandler.router.URLToRepoJob("a.b/c", "123").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusNotImplemented; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+	}
+}
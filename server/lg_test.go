@@ -118,11 +118,11 @@ func testClone_lg(t *testing.T, repoPath string, opt *vcsclient.CloneInfo, wantC
 
 	if repo, ok := repo.(vcsclient.RepositoryCloneUpdater); ok {
 		// Clones the first time.
-		err := repo.CloneOrUpdate(opt)
+		_, err := repo.CloneOrUpdate(opt)
 		checkErr(t, err, wantCloneErrStr, wantCloneErrHTTPStatus)
 
 		// Updates the second time.
-		err = repo.CloneOrUpdate(opt)
+		_, err = repo.CloneOrUpdate(opt)
 		checkErr(t, err, wantCloneErrStr, wantCloneErrHTTPStatus)
 	} else {
 		t.Fatalf("Remote cloning is not implemented for %T.", repo)
@@ -43,17 +43,11 @@ func (h *Handler) serveRepoMergeBase(w http.ResponseWriter, r *http.Request) err
 func (h *Handler) serveRepoCrossRepoMergeBase(w http.ResponseWriter, r *http.Request) error {
 	v := mux.Vars(r)
 
-	repoA, repoPathA, doneA, err := h.getRepo(r)
+	repoA, repoB, repoPathA, done, err := h.getRepoPair(r, "B")
 	if err != nil {
 		return err
 	}
-	defer doneA()
-
-	repoB, _, doneB, err := h.getRepoLabeled(r, "B")
-	if err != nil {
-		return err
-	}
-	defer doneB()
+	defer done()
 
 	if repoA, ok := repoA.(vcs.CrossRepoMerger); ok {
 		a, b := vcs.CommitID(v["CommitIDA"]), vcs.CommitID(v["CommitIDB"])
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// progressWatcher is implemented by services that report the progress
+// of clones and updates.
+type progressWatcher interface {
+	WatchProgress(repoPath string) (events <-chan *vcsclient.ProgressEvent, stop func())
+}
+
+// sseKeepAliveInterval is how often a comment is sent on idle
+// text/event-stream responses, so that proxies don't time them out.
+var sseKeepAliveInterval = 30 * time.Second
+
+// serveRepoProgress streams the progress events of the next (or
+// currently running) clone or update of the repository as
+// Server-Sent Events. The stream ends after the final event.
+func (h *Handler) serveRepoProgress(w http.ResponseWriter, r *http.Request) error {
+	repoPath, err := h.getRepoPath(r, "")
+	if err != nil {
+		return err
+	}
+
+	svc, ok := h.Service.(progressWatcher)
+	if !ok {
+		return &httpError{http.StatusNotImplemented, fmt.Errorf("Progress not yet implemented for %T", h.Service)}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming responses not supported")
+	}
+	var clientGone <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		clientGone = cn.CloseNotify()
+	}
+
+	events, stop := svc.WatchProgress(repoPath)
+	defer stop()
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev := <-events:
+			data, err := json.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return nil // client is gone
+			}
+			flusher.Flush()
+			if ev.Final() {
+				return nil
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+			flusher.Flush()
+		case <-clientGone:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+type mockProgressWatcher struct {
+	mockService
+	events []*vcsclient.ProgressEvent
+}
+
+func (m *mockProgressWatcher) WatchProgress(repoPath string) (<-chan *vcsclient.ProgressEvent, func()) {
+	ch := make(chan *vcsclient.ProgressEvent, len(m.events))
+	for _, ev := range m.events {
+		ch <- ev
+	}
+	return ch, func() {}
+}
+
+func TestServeRepoProgress(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	testHandler.Service = &mockProgressWatcher{
+		mockService: mockService{t: t},
+		events: []*vcsclient.ProgressEvent{
+			{Type: vcsclient.ProgressPhase, Phase: "receiving"},
+			{Type: vcsclient.ProgressLine, Line: "Receiving objects: 100% (3/3)"},
+			{Type: vcsclient.ProgressSuccess},
+			{Type: vcsclient.ProgressPhase, Phase: "after final event"},
+		},
+	}
+
+	resp, err := http.Get(server.URL + testHandler.router.URLTo(vcsclient.RouteRepoProgress, "RepoPath", "a.b/c").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+	}
+	if got, want := resp.Header.Get("content-type"), "text/event-stream"; got != want {
+		t.Errorf("got content-type %q, want %q", got, want)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `event: phase
+data: {"Type":"phase","Phase":"receiving"}
+
+event: progress
+data: {"Type":"progress","Line":"Receiving objects: 100% (3/3)"}
+
+event: success
+data: {"Type":"success"}
+
+`
+	if string(body) != want {
+		t.Errorf("got body %q, want %q", body, want)
+	}
+}
+
+func TestServeRepoProgress_NotImplemented(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	testHandler.Service = &mockService{t: t}
+
+	resp, err := http.Get(server.URL + testHandler.router.URLTo(vcsclient.RouteRepoProgress, "RepoPath", "a.b/c").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusNotImplemented; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"unicode/utf8"
+
+	"github.com/sourcegraph/mux"
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+)
+
+// serveRepoRawFile serves the raw contents of a file. Unlike
+// serveRepoTreeEntry, it doesn't wrap the contents in JSON, and it
+// supports HTTP Range and conditional requests (using
+// http.ServeContent).
+//
+// Range requests save bandwidth, but not work on the server: the file
+// systems of git repositories (gitFSCmd) read the whole file into
+// memory when it is opened.
+func (h *Handler) serveRepoRawFile(w http.ResponseWriter, r *http.Request) error {
+	v := mux.Vars(r)
+
+	repo, _, done, err := h.getRepo(r)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	commitID, canon, err := getCommitID(r)
+	if err != nil {
+		return err
+	}
+
+	type fileSystem interface {
+		FileSystem(vcs.CommitID) (vfs.FileSystem, error)
+	}
+	repoFS, ok := repo.(fileSystem)
+	if !ok {
+		return &httpError{http.StatusNotImplemented, fmt.Errorf("FileSystem not yet implemented for %T", repo)}
+	}
+	fs, err := repoFS.FileSystem(commitID)
+	if err != nil {
+		return err
+	}
+
+	name := v["Path"]
+	fi, err := fs.Lstat(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &httpError{http.StatusNotFound, err}
+		}
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("%s is not a regular file", name)}
+	}
+
+	f, err := fs.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if canon {
+		// The contents of a path at a full commit ID never change.
+		setLongCache(w)
+		w.Header().Set("etag", fmt.Sprintf(`"%x"`, sha1.Sum([]byte(string(commitID)+":"+name))))
+	} else {
+		setShortCache(w)
+	}
+	// Never serve a sniffed content type (such as text/html), so that
+	// files in repositories can't run scripts in the server's origin.
+	contentType, err := rawContentType(f)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("content-type", contentType)
+	w.Header().Set("x-content-type-options", "nosniff")
+	w.Header().Set("content-security-policy", "sandbox")
+	http.ServeContent(w, r, path.Base(name), fi.ModTime(), f)
+	return nil
+}
+
+// rawContentType returns the content type that the raw contents of f
+// are served with: text/plain if its first 512 bytes (as many as
+// http.DetectContentType considers) are UTF-8 text, and
+// application/octet-stream otherwise. It leaves f at its start.
+func rawContentType(f io.ReadSeeker) (string, error) {
+	var buf [512]byte
+	n, err := io.ReadFull(f, buf[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if !isUTF8Text(buf[:n], n == len(buf)) {
+		return "application/octet-stream", nil
+	}
+	return "text/plain; charset=utf-8", nil
+}
+
+// isUTF8Text returns whether b is valid UTF-8 without NUL bytes. If b
+// is truncated, it may end with an incomplete rune.
+func isUTF8Text(b []byte, truncated bool) bool {
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size == 1 {
+			return truncated && !utf8.FullRune(b)
+		}
+		if r == 0 {
+			return false
+		}
+		b = b[size:]
+	}
+	return true
+}
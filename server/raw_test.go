@@ -0,0 +1,93 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func TestServeRepoRawFile(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	commitID := vcs.CommitID(strings.Repeat("a", 40))
+
+	repoPath := "a.b/c"
+	rm := &mockFileSystem{
+		t:  t,
+		at: commitID,
+		fs: mapFS(map[string]string{
+			"d/myfile.txt": "mydata",
+			"x.html":       "<script>alert(1)</script>",
+			"x.png":        "\x89PNG\r\n\x1a\n\x00\x00",
+		}),
+	}
+	testHandler.Service = &mockServiceForExistingRepo{
+		t:        t,
+		repoPath: repoPath,
+		repo:     rm,
+	}
+
+	get := func(path string, header map[string]string) (*http.Response, string) {
+		u := testHandler.router.URLTo(vcsclient.RouteRepoRawFile, "RepoPath", repoPath, "CommitID", string(commitID), "Path", path)
+		req, _ := http.NewRequest("GET", server.URL+u.String(), nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	resp, body := get("d/myfile.txt", nil)
+	if resp.StatusCode != http.StatusOK || body != "mydata" {
+		t.Fatalf("got status code %d and body %q, want 200 and mydata", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("content-type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("got content-type %q, want text/plain", ct)
+	}
+	if resp.ContentLength != 6 {
+		t.Errorf("got content-length %d, want 6", resp.ContentLength)
+	}
+	if cc := resp.Header.Get("cache-control"); cc != longCacheControl {
+		t.Errorf("got cache-control %q, want %q", cc, longCacheControl)
+	}
+	etag := resp.Header.Get("etag")
+	if etag == "" {
+		t.Fatal("got no etag")
+	}
+
+	if resp, body := get("d/myfile.txt", map[string]string{"range": "bytes=2-"}); resp.StatusCode != http.StatusPartialContent || body != "data" {
+		t.Errorf("range request: got status code %d and body %q, want 206 and data", resp.StatusCode, body)
+	}
+	if resp, _ := get("d/myfile.txt", map[string]string{"if-none-match": etag}); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("conditional request: got status code %d, want 304", resp.StatusCode)
+	}
+	// The content type is never sniffed from the contents or the name.
+	for path, want := range map[string]string{"x.html": "text/plain; charset=utf-8", "x.png": "application/octet-stream"} {
+		resp, _ := get(path, nil)
+		if ct := resp.Header.Get("content-type"); ct != want {
+			t.Errorf("%s: got content-type %q, want %q", path, ct, want)
+		}
+		if csp := resp.Header.Get("content-security-policy"); csp != "sandbox" {
+			t.Errorf("%s: got content-security-policy %q, want sandbox", path, csp)
+		}
+	}
+	if resp, _ := get("d", nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("dir: got status code %d, want 400", resp.StatusCode)
+	}
+	if resp, _ := get("doesntexist", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("nonexistent file: got status code %d, want 404", resp.StatusCode)
+	}
+}
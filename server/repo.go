@@ -6,22 +6,38 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/sourcegraph/mux"
 	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore"
 	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
 )
 
 func (h *Handler) serveRepo(w http.ResponseWriter, r *http.Request) error {
-	repo, _, done, err := h.getRepo(r)
+	repo, repoPath, done, err := h.getRepo(r)
 	if err != nil {
 		return err
 	}
 	defer done()
 
-	return writeJSON(w, struct {
-		ImplementationType string
-	}{fmt.Sprintf("%T", repo)})
+	desc := &vcsclient.RepositoryDescription{
+		ImplementationType: fmt.Sprintf("%T", repo),
+		Capabilities:       repoCapabilities(repo),
+	}
+
+	type metadataGetter interface {
+		Metadata(repoPath string) (*vcsclient.RepositoryMetadata, error)
+	}
+	if svc, ok := h.Service.(metadataGetter); ok {
+		md, err := svc.Metadata(repoPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		desc.Metadata = md
+	}
+
+	return writeJSON(w, desc)
 }
 
 func (h *Handler) serveRepoCreateOrUpdate(w http.ResponseWriter, r *http.Request) error {
@@ -32,37 +48,131 @@ func (h *Handler) serveRepoCreateOrUpdate(w http.ResponseWriter, r *http.Request
 		}
 	}
 
+	if v := r.Header.Get(vcsclient.TimeoutHeader); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return &httpError{http.StatusBadRequest, fmt.Errorf("invalid %s header: %s", vcsclient.TimeoutHeader, err)}
+		}
+		cloneInfo.Timeout = timeout
+	}
+
+	var opt vcsclient.CloneOrUpdateOptions
+	if err := schemaDecoder.Decode(&opt, r.URL.Query()); err != nil {
+		return err
+	}
+	if opt.Async {
+		return h.serveRepoStartCloneOrUpdate(w, r, &cloneInfo)
+	}
+
 	var cloned bool // whether the repo was newly cloned
-	repo, repoPath, _, err := h.getRepo(r)
+	repo, repoPath, done, err := h.getRepo(r)
 	if errorHTTPStatusCode(err) == http.StatusNotFound {
 		cloned = true
-		repo, err = h.Service.Clone(repoPath, &cloneInfo)
+		var handle *vcsstore.RepoHandle
+		handle, err = h.Service.Clone(repoPath, &cloneInfo)
+		if err == nil {
+			repo, done = handle.Repo, handle.Release
+		}
 	}
 	if err != nil {
 		return cloneOrUpdateError(err)
 	}
-	defer h.Service.Close(repoPath)
+	defer done()
 
 	if cloned {
-		w.WriteHeader(http.StatusCreated)
-		return nil
+		return writeJSONWithStatus(w, http.StatusCreated, h.clonedUpdateResult(repo))
 	}
 
-	type updateEverythinger interface {
-		UpdateEverything(opt vcs.RemoteOpts) error
-	}
-	if repo, ok := repo.(updateEverythinger); ok {
-		err := repo.UpdateEverything(cloneInfo.RemoteOpts)
+	if repo, ok := repo.(vcs.RemoteUpdater); ok {
+		var result *vcs.UpdateResult
+		if svc, ok := h.Service.(updater); ok {
+			// Let the service report the update's progress. Release
+			// our handle first so that the update can take the
+			// repository's write lock.
+			done()
+			result, err = svc.Update(repoPath, &cloneInfo)
+		} else {
+			result, err = repo.UpdateEverything(cloneInfo.RemoteOpts)
+		}
 		if err != nil {
 			return cloneOrUpdateError(err)
 		}
 
-		return nil
+		return writeJSON(w, result)
 	}
 	return &httpError{http.StatusNotImplemented, fmt.Errorf("Remote updates not yet implemented for %T", repo)}
 }
 
+// repoCapabilities lists the go-vcs interfaces that repo implements
+// (see the vcsclient.Cap* constants).
+func repoCapabilities(repo interface{}) []string {
+	caps := []string{}
+	if _, ok := repo.(vcs.Blamer); ok {
+		caps = append(caps, vcsclient.CapBlamer)
+	}
+	if _, ok := repo.(vcs.Searcher); ok {
+		caps = append(caps, vcsclient.CapSearcher)
+	}
+	if _, ok := repo.(vcs.Differ); ok {
+		caps = append(caps, vcsclient.CapDiffer)
+	}
+	if _, ok := repo.(vcs.CrossRepoDiffer); ok {
+		caps = append(caps, vcsclient.CapCrossRepoDiffer)
+	}
+	if _, ok := repo.(vcs.Merger); ok {
+		caps = append(caps, vcsclient.CapMerger)
+	}
+	if _, ok := repo.(vcs.CrossRepoMerger); ok {
+		caps = append(caps, vcsclient.CapCrossRepoMerger)
+	}
+	if _, ok := repo.(vcs.RemoteUpdater); ok {
+		caps = append(caps, vcsclient.CapRemoteUpdater)
+	}
+	if _, ok := repo.(vcs.FileLister); ok {
+		caps = append(caps, vcsclient.CapFileLister)
+	}
+	return caps
+}
+
+// updater is implemented by services that update repositories
+// themselves (instead of the handler calling UpdateEverything on the
+// repository directly).
+type updater interface {
+	Update(repoPath string, cloneInfo *vcsclient.CloneInfo) (*vcs.UpdateResult, error)
+}
+
+// clonedUpdateResult returns an UpdateResult for a newly cloned
+// repository, in which every branch is new.
+func (h *Handler) clonedUpdateResult(repo interface{}) *vcs.UpdateResult {
+	result, err := vcsstore.ClonedUpdateResult(repo)
+	if err != nil {
+		// The clone itself succeeded, so don't fail the request.
+		h.Log.Printf("Listing branches of newly cloned repository %T failed: %s.", repo, err)
+	}
+	return result
+}
+
+func (h *Handler) serveRepoRemove(w http.ResponseWriter, r *http.Request) error {
+	repoPath, err := h.getRepoPath(r, "")
+	if err != nil {
+		return err
+	}
+
+	if err := h.Service.Remove(repoPath); err != nil {
+		if os.IsNotExist(err) {
+			err = &httpError{http.StatusNotFound, vcsclient.ErrRepoNotExist}
+		}
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 func cloneOrUpdateError(err error) error {
+	if _, ok := err.(*vcsstore.TimeoutError); ok {
+		return &httpError{http.StatusGatewayTimeout, err}
+	}
 	if err != nil {
 		var c int
 		switch err.Error() {
@@ -98,7 +208,7 @@ func (h *Handler) getRepoLabeled(r *http.Request, label string) (repo interface{
 		return nil, "", nil, err
 	}
 
-	repo, err = h.Service.Open(repoPath)
+	handle, err := h.Service.Acquire(repoPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			err = &httpError{http.StatusNotFound, vcsclient.ErrRepoNotExist}
@@ -106,11 +216,67 @@ func (h *Handler) getRepoLabeled(r *http.Request, label string) (repo interface{
 		return nil, repoPath, nil, err
 	}
 
+	return handle.Repo, repoPath, handle.Release, nil
+}
+
+// getRepoPair gets the main repo in the URL and another one labeled
+// label (such as the head repo for cross-repo diffs). If both are the
+// same repository, a single handle is used for both. Otherwise the
+// repos are acquired in a fixed order (by clone dir), so that requests
+// for the same pair of repos in opposite orders can't deadlock while
+// an update of one of them is waiting for their read locks.
+func (h *Handler) getRepoPair(r *http.Request, label string) (repo, other interface{}, repoPath string, done func(), err error) {
+	repoPath, err = h.getRepoPath(r, "")
+	if err != nil {
+		return nil, nil, "", nil, err
+	}
+	otherPath, err := h.getRepoPath(r, label)
+	if err != nil {
+		return nil, nil, repoPath, nil, err
+	}
+
+	key, otherKey := h.repoLockKey(repoPath), h.repoLockKey(otherPath)
+	if key == otherKey {
+		repo, _, done, err = h.getRepoLabeled(r, "")
+		return repo, repo, repoPath, done, err
+	}
+
+	first, second := "", label
+	if otherKey < key {
+		first, second = label, ""
+	}
+	repo1, _, done1, err := h.getRepoLabeled(r, first)
+	if err != nil {
+		return nil, nil, repoPath, nil, err
+	}
+	repo2, _, done2, err := h.getRepoLabeled(r, second)
+	if err != nil {
+		done1()
+		return nil, nil, repoPath, nil, err
+	}
 	done = func() {
-		h.Service.Close(repoPath)
+		done2()
+		done1()
+	}
+	if first != "" {
+		repo1, repo2 = repo2, repo1
 	}
+	return repo1, repo2, repoPath, done, nil
+}
 
-	return repo, repoPath, done, nil
+// repoLockKey returns the key that getRepoPair orders repos by, which
+// is the same for repo paths that refer to the same repo: its clone
+// dir, if the service reports it, or else its repo path.
+func (h *Handler) repoLockKey(repoPath string) string {
+	type cloneDirer interface {
+		CloneDir(repoPath string) (string, error)
+	}
+	if svc, ok := h.Service.(cloneDirer); ok {
+		if dir, err := svc.CloneDir(repoPath); err == nil {
+			return dir
+		}
+	}
+	return repoPath
 }
 
 func (h *Handler) getRepoPath(r *http.Request, label string) (repoPath string, err error) {
@@ -7,8 +7,10 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	vcs_testing "sourcegraph.com/sourcegraph/go-vcs/vcs/testing"
 	"sourcegraph.com/sourcegraph/vcsstore"
 	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
 )
@@ -40,6 +42,73 @@ func TestServeRepo(t *testing.T) {
 	}
 }
 
+func TestServeRepo_capabilities(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	repoPath := "a.b/c"
+	testHandler.Service = &mockServiceForExistingRepo{
+		t:        t,
+		repoPath: repoPath,
+		repo:     vcs_testing.MockRepository{},
+	}
+
+	resp, err := http.Get(server.URL + testHandler.router.URLToRepo(repoPath).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var desc *vcsclient.RepositoryDescription
+	if err := json.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{vcsclient.CapBlamer, vcsclient.CapDiffer, vcsclient.CapCrossRepoDiffer, vcsclient.CapMerger, vcsclient.CapCrossRepoMerger}
+	if !reflect.DeepEqual(desc.Capabilities, want) {
+		t.Errorf("got capabilities %v, want %v", desc.Capabilities, want)
+	}
+}
+
+type mockMetadataService struct {
+	mockServiceForExistingRepo
+	metadata *vcsclient.RepositoryMetadata
+}
+
+func (m *mockMetadataService) Metadata(repoPath string) (*vcsclient.RepositoryMetadata, error) {
+	return m.metadata, nil
+}
+
+func TestServeRepo_metadata(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	repoPath := "a.b/c"
+	sm := &mockMetadataService{
+		mockServiceForExistingRepo: mockServiceForExistingRepo{t: t, repoPath: repoPath},
+		metadata:                   &vcsclient.RepositoryMetadata{VCS: "git", CloneURL: "https://example.com/c"},
+	}
+	testHandler.Service = sm
+
+	resp, err := http.Get(server.URL + testHandler.router.URLToRepo(repoPath).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+
+	var desc *vcsclient.RepositoryDescription
+	if err := json.NewDecoder(resp.Body).Decode(&desc); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(desc.Metadata, sm.metadata) {
+		t.Errorf("got metadata %+v, want %+v", asJSON(desc.Metadata), asJSON(sm.metadata))
+	}
+}
+
 func TestServeRepo_DoesNotExist(t *testing.T) {
 	setupHandlerTest()
 	defer teardownHandlerTest()
@@ -216,7 +285,8 @@ func TestServeRepoCreateOrUpdate_UpdateExisting_withBody(t *testing.T) {
 
 	repoPath := "a.b/c"
 	opt := vcsclient.CloneInfo{RemoteOpts: vcs.RemoteOpts{SSH: &vcs.SSHConfig{User: "u"}}}
-	rm := &mockUpdateEverythinger{t: t, opt: opt.RemoteOpts}
+	result := &vcs.UpdateResult{Changes: []vcs.Change{{Op: vcs.ForceUpdatedOp, Branch: "b"}}}
+	rm := &mockUpdateEverythinger{t: t, opt: opt.RemoteOpts, result: result}
 	sm := &mockServiceForExistingRepo{
 		t: t,
 
@@ -246,6 +316,146 @@ func TestServeRepoCreateOrUpdate_UpdateExisting_withBody(t *testing.T) {
 		t.Errorf("got code %d, want %d", got, want)
 		logResponseBody(t, resp)
 	}
+
+	var gotResult *vcs.UpdateResult
+	if err := json.NewDecoder(resp.Body).Decode(&gotResult); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotResult, result) {
+		t.Errorf("got result %s, want %s", asJSON(gotResult), asJSON(result))
+	}
+}
+
+func TestServeRepoCreateOrUpdate_timeout(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	repoPath := "a.b/c"
+	opt := vcsclient.CloneInfo{VCS: "git", CloneURL: "https://a.b/c.git"}
+	var calledClone bool
+	sm := &mockService{
+		t: t,
+
+		repoPath: repoPath,
+		opt:      vcsclient.CloneInfo{VCS: opt.VCS, CloneURL: opt.CloneURL, Timeout: 90 * time.Second},
+		open: func(repoPath string) (interface{}, error) {
+			return nil, os.ErrNotExist
+		},
+		clone: func(repoPath string, opt *vcsclient.CloneInfo) (interface{}, error) {
+			calledClone = true
+			return nil, &vcsstore.TimeoutError{Op: "clone", RepoPath: repoPath, Timeout: opt.Timeout}
+		},
+	}
+	testHandler.Service = sm
+
+	body, _ := json.Marshal(opt)
+	req, err := http.NewRequest("POST", server.URL+testHandler.router.URLToRepo(repoPath).String(), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(vcsclient.TimeoutHeader, "90s")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if !calledClone {
+		t.Errorf("!calledClone")
+	}
+	if got, want := resp.StatusCode, http.StatusGatewayTimeout; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+}
+
+func TestServeRepoCreateOrUpdate_invalidTimeout(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	testHandler.Service = &mockService{t: t}
+
+	req, err := http.NewRequest("POST", server.URL+testHandler.router.URLToRepo("a.b/c").String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(vcsclient.TimeoutHeader, "abc")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusBadRequest; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+	}
+}
+
+func TestServeRepoRemove(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	repoPath := "a.b/c"
+	var calledRemove bool
+	sm := &mockService{
+		t: t,
+
+		repoPath: repoPath,
+		remove: func(repoPath string) error {
+			calledRemove = true
+			return nil
+		},
+	}
+	testHandler.Service = sm
+
+	req, err := http.NewRequest("DELETE", server.URL+testHandler.router.URLToRepo(repoPath).String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if !calledRemove {
+		t.Errorf("!calledRemove")
+	}
+	if got, want := resp.StatusCode, http.StatusNoContent; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+}
+
+func TestServeRepoRemove_DoesNotExist(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	repoPath := "a.b/c"
+	sm := &mockService{
+		t: t,
+
+		repoPath: repoPath,
+		remove: func(repoPath string) error {
+			return os.ErrNotExist
+		},
+	}
+	testHandler.Service = sm
+
+	req, err := http.NewRequest("DELETE", server.URL+testHandler.router.URLToRepo(repoPath).String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusNotFound; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
 }
 
 type mockUpdateEverythinger struct {
@@ -255,17 +465,20 @@ type mockUpdateEverythinger struct {
 	opt vcs.RemoteOpts
 
 	// return values
-	err error
+	result *vcs.UpdateResult
+	err    error
 
 	called bool
 }
 
-func (m *mockUpdateEverythinger) UpdateEverything(opt vcs.RemoteOpts) error {
+var _ vcs.RemoteUpdater = (*mockUpdateEverythinger)(nil)
+
+func (m *mockUpdateEverythinger) UpdateEverything(opt vcs.RemoteOpts) (*vcs.UpdateResult, error) {
 	m.called = true
 	if !reflect.DeepEqual(opt, m.opt) {
 		m.t.Errorf("mock: got opt %+v, want %+v", asJSON(opt), asJSON(m.opt))
 	}
-	return m.err
+	return m.result, m.err
 }
 
 type mockServiceForExistingRepo struct {
@@ -283,20 +496,28 @@ type mockServiceForExistingRepo struct {
 
 var _ vcsstore.Service = (*mockServiceForExistingRepo)(nil)
 
-func (m *mockServiceForExistingRepo) Open(repoPath string) (interface{}, error) {
+func (m *mockServiceForExistingRepo) Acquire(repoPath string) (*vcsstore.RepoHandle, error) {
 	if m.repoPath != "" && repoPath != m.repoPath {
 		m.t.Errorf("mock: got repoPath arg %q, want %q", repoPath, m.repoPath)
 	}
 	m.opened = true
-	return m.repo, m.err
+	return mockRepoHandle(m.repo, m.err)
 }
 
-func (m *mockServiceForExistingRepo) Clone(repoPath string, opt *vcsclient.CloneInfo) (interface{}, error) {
+func (m *mockServiceForExistingRepo) Clone(repoPath string, opt *vcsclient.CloneInfo) (*vcsstore.RepoHandle, error) {
 	m.t.Errorf("mock: unexpectedly called Clone for repo that exists (%s)", repoPath)
-	return m.repo, m.err
+	return mockRepoHandle(m.repo, m.err)
 }
 
-func (m *mockServiceForExistingRepo) Close(repoPath string) {}
+func (m *mockServiceForExistingRepo) Remove(repoPath string) error {
+	m.t.Errorf("mock: unexpectedly called Remove (%s)", repoPath)
+	return m.err
+}
+
+func (m *mockServiceForExistingRepo) ListRepositories(opt vcsclient.RepositoryListOptions) ([]*vcsclient.RepositoryInfo, uint, error) {
+	m.t.Errorf("mock: unexpectedly called ListRepositories")
+	return nil, 0, m.err
+}
 
 type mockService struct {
 	t *testing.T
@@ -306,30 +527,49 @@ type mockService struct {
 	opt      vcsclient.CloneInfo
 
 	// mockable methods
-	open  func(repoPath string) (interface{}, error)
-	clone func(repoPath string, opt *vcsclient.CloneInfo) (interface{}, error)
+	open   func(repoPath string) (interface{}, error)
+	clone  func(repoPath string, opt *vcsclient.CloneInfo) (interface{}, error)
+	remove func(repoPath string) error
+	list   func(opt vcsclient.RepositoryListOptions) ([]*vcsclient.RepositoryInfo, uint, error)
 }
 
 var _ vcsstore.Service = (*mockService)(nil)
 
-func (m *mockService) Open(repoPath string) (interface{}, error) {
+func (m *mockService) Acquire(repoPath string) (*vcsstore.RepoHandle, error) {
 	if m.repoPath != "" && repoPath != m.repoPath {
 		m.t.Errorf("mock: got repoPath arg %q, want %q", repoPath, m.repoPath)
 	}
-	return m.open(repoPath)
+	return mockRepoHandle(m.open(repoPath))
 }
 
-func (m *mockService) Clone(repoPath string, opt *vcsclient.CloneInfo) (interface{}, error) {
+func (m *mockService) Clone(repoPath string, opt *vcsclient.CloneInfo) (*vcsstore.RepoHandle, error) {
 	if m.repoPath != "" && repoPath != m.repoPath {
 		m.t.Errorf("mock: got repoPath arg %q, want %q", repoPath, m.repoPath)
 	}
 	if !reflect.DeepEqual(opt, &m.opt) {
 		m.t.Errorf("mock: got opt %+v, want %+v", asJSON(opt), asJSON(m.opt))
 	}
-	return m.clone(repoPath, opt)
+	return mockRepoHandle(m.clone(repoPath, opt))
 }
 
-func (m *mockService) Close(repoPath string) {}
+func (m *mockService) Remove(repoPath string) error {
+	if m.repoPath != "" && repoPath != m.repoPath {
+		m.t.Errorf("mock: got repoPath arg %q, want %q", repoPath, m.repoPath)
+	}
+	return m.remove(repoPath)
+}
+
+func (m *mockService) ListRepositories(opt vcsclient.RepositoryListOptions) ([]*vcsclient.RepositoryInfo, uint, error) {
+	return m.list(opt)
+}
+
+// mockRepoHandle returns a handle to repo, or err if it is non-nil.
+func mockRepoHandle(repo interface{}, err error) (*vcsstore.RepoHandle, error) {
+	if err != nil {
+		return nil, err
+	}
+	return vcsstore.NewRepoHandle(repo, nil), nil
+}
 
 func asJSON(v interface{}) string {
 	b, _ := json.Marshal(v)
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func (h *Handler) serveRepos(w http.ResponseWriter, r *http.Request) error {
+	var opt vcsclient.RepositoryListOptions
+	if err := schemaDecoder.Decode(&opt, r.URL.Query()); err != nil {
+		return err
+	}
+
+	repos, total, err := h.Service.ListRepositories(opt)
+	if err != nil {
+		return err
+	}
+	if repos == nil {
+		repos = []*vcsclient.RepositoryInfo{}
+	}
+
+	w.Header().Set(vcsclient.TotalRepositoriesHeader, strconv.FormatUint(uint64(total), 10))
+
+	return writeJSON(w, repos)
+}
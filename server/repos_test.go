@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func TestServeRepos(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	opt := vcsclient.RepositoryListOptions{Prefix: "a.b/", N: 1}
+	want := []*vcsclient.RepositoryInfo{{RepoPath: "a.b/c", VCS: "git", Size: 123}}
+	var calledList bool
+	sm := &mockService{
+		t: t,
+		list: func(opt2 vcsclient.RepositoryListOptions) ([]*vcsclient.RepositoryInfo, uint, error) {
+			calledList = true
+			if opt2 != opt {
+				t.Errorf("mock: got opt %+v, want %+v", opt2, opt)
+			}
+			return want, 2, nil
+		},
+	}
+	testHandler.Service = sm
+
+	resp, err := http.Get(server.URL + testHandler.router.URLToRepos(opt).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if !calledList {
+		t.Errorf("!calledList")
+	}
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+	if got, want := resp.Header.Get(vcsclient.TotalRepositoriesHeader), "2"; got != want {
+		t.Errorf("got total header %q, want %q", got, want)
+	}
+
+	var repos []*vcsclient.RepositoryInfo
+	if err := json.NewDecoder(resp.Body).Decode(&repos); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range repos {
+		r.LastFetched = r.LastFetched.UTC()
+	}
+	if !reflect.DeepEqual(repos, want) {
+		t.Errorf("got repos %s, want %s", asJSON(repos), asJSON(want))
+	}
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func (h *Handler) serveRepoStats(w http.ResponseWriter, r *http.Request) error {
+	repoPath, err := h.getRepoPath(r, "")
+	if err != nil {
+		return err
+	}
+
+	type statser interface {
+		Stats(repoPath string) (*vcsclient.RepositoryStats, error)
+	}
+	svc, ok := h.Service.(statser)
+	if !ok {
+		return &httpError{http.StatusNotImplemented, fmt.Errorf("Stats not yet implemented for %T", h.Service)}
+	}
+
+	stats, err := svc.Stats(repoPath)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, stats)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+type mockStatser struct {
+	mockService
+	repoPath string
+}
+
+func (m *mockStatser) Stats(repoPath string) (*vcsclient.RepositoryStats, error) {
+	m.repoPath = repoPath
+	return &vcsclient.RepositoryStats{Commits: 3, LargestBlobs: []*vcsclient.BlobSize{{ID: "abcd", Path: "f", Size: 10}}}, nil
+}
+
+func TestServeRepoStats(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	svc := &mockStatser{}
+	testHandler.Service = svc
+
+	resp, err := http.Get(server.URL + testHandler.router.URLTo(vcsclient.RouteRepoStats, "RepoPath", "a.b/c").String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+
+	if svc.repoPath != "a.b/c" {
+		t.Errorf("got Stats(%q), want a.b/c", svc.repoPath)
+	}
+	var stats *vcsclient.RepositoryStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Commits != 3 || len(stats.LargestBlobs) != 1 {
+		t.Errorf("got stats %s", asJSON(stats))
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// streamWriteTimeout is the longest that a single write of a streamed
+// response may block. Handlers that stream responses hold the
+// repository's read lock while they write, so a client that stops
+// reading must not be able to hold it (and block updates to the
+// repository) indefinitely.
+var streamWriteTimeout = time.Minute
+
+// A streamWriter writes a streamed response. It calls start (which
+// sets the response headers) before the first write, so that errors
+// that occur before anything is written can still be reported with an
+// HTTP status code, and it fails writes that block for longer than
+// streamWriteTimeout.
+type streamWriter struct {
+	w       http.ResponseWriter
+	start   func()
+	started bool
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if err := sw.setWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		return 0, err
+	}
+	if !sw.started {
+		sw.start()
+		sw.started = true
+	}
+	return sw.w.Write(p)
+}
+
+// close clears the write deadline, which would otherwise apply to
+// later responses on the same connection.
+func (sw *streamWriter) close() error {
+	return sw.setWriteDeadline(time.Time{})
+}
+
+func (sw *streamWriter) setWriteDeadline(t time.Time) error {
+	if err := http.NewResponseController(sw.w).SetWriteDeadline(t); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStreamWriter_timeout(t *testing.T) {
+	defer func(d time.Duration) { streamWriteTimeout = d }(streamWriteTimeout)
+	streamWriteTimeout = 50 * time.Millisecond
+
+	errc := make(chan error, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &streamWriter{w: w, start: func() {}}
+		defer sw.close()
+		buf := make([]byte, 64<<10)
+		for {
+			if _, err := sw.Write(buf); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	// Request a response, but never read it.
+	c, err := net.Dial("tcp", s.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprint(c, "GET / HTTP/1.1\r\nHost: example.com\r\n\r\n")
+
+	select {
+	case err := <-errc:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("got write error %v, want a timeout", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("write to client that isn't reading didn't time out")
+	}
+}
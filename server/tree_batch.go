@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// serveRepoTreeEntryBatch gets many tree entries (as serveRepoTreeEntry
+// does) in a single request. Errors getting individual entries are
+// reported in their results.
+func (h *Handler) serveRepoTreeEntryBatch(w http.ResponseWriter, r *http.Request) error {
+	var reqs []*vcsclient.TreeEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		return &httpError{http.StatusBadRequest, err}
+	}
+	if len(reqs) > vcsclient.MaxTreeEntryBatch {
+		return &httpError{http.StatusBadRequest, fmt.Errorf("%d tree entries requested (max %d)", len(reqs), vcsclient.MaxTreeEntryBatch)}
+	}
+
+	repo, _, done, err := h.getRepo(r)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	commitID, _, err := getCommitID(r)
+	if err != nil {
+		return err
+	}
+
+	type fileSystem interface {
+		FileSystem(vcs.CommitID) (vfs.FileSystem, error)
+	}
+	repoFS, ok := repo.(fileSystem)
+	if !ok {
+		return &httpError{http.StatusNotImplemented, fmt.Errorf("FileSystem not yet implemented for %T", repo)}
+	}
+	fs, err := repoFS.FileSystem(commitID)
+	if err != nil {
+		return err
+	}
+
+	results := make([]*vcsclient.TreeEntryResult, len(reqs))
+	for i, req := range reqs {
+		if req == nil {
+			return &httpError{http.StatusBadRequest, fmt.Errorf("tree entry request %d is null", i)}
+		}
+		res := &vcsclient.TreeEntryResult{Path: req.Path}
+		results[i] = res
+
+		// Clean the path as the RouteRepoTreeEntry route does.
+		p := path.Clean(strings.TrimPrefix(req.Path, "/"))
+		if p == "" {
+			p = "."
+		}
+		res.File, err = vcsclient.GetFileWithOptions(fs, p, req.Options)
+		if err != nil {
+			res.File = nil
+			switch {
+			case os.IsNotExist(err):
+				res.Error, res.NotExist = "file does not exist", true
+			case h.Debug:
+				res.Error = err.Error()
+			default:
+				h.Log.Printf("Getting tree entry %q (in batch) failed: %s.", req.Path, err)
+				res.Error = http.StatusText(http.StatusInternalServerError)
+			}
+		}
+	}
+	return writeJSON(w, results)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func TestServeRepoTreeEntryBatch(t *testing.T) {
+	setupHandlerTest()
+	defer teardownHandlerTest()
+
+	commitID := vcs.CommitID(strings.Repeat("a", 40))
+
+	repoPath := "a.b/c"
+	testHandler.Service = &mockServiceForExistingRepo{
+		t:        t,
+		repoPath: repoPath,
+		repo: &mockFileSystem{
+			t:  t,
+			at: commitID,
+			fs: mapFS(map[string]string{"a": "line1\nline2\n", "d/b": "b"}),
+		},
+	}
+
+	post := func(reqs interface{}) *http.Response {
+		body, _ := json.Marshal(reqs)
+		u := testHandler.router.URLTo(vcsclient.RouteRepoTreeEntryBatch, "RepoPath", repoPath, "CommitID", string(commitID))
+		resp, err := http.Post(server.URL+u.String(), "application/json", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := post([]*vcsclient.TreeEntryRequest{
+		{Path: "a", Options: vcsclient.GetFileOptions{FileRange: vcsclient.FileRange{StartLine: 2, EndLine: 2}}},
+		{Path: "/d"},
+		{Path: "doesntexist"},
+	})
+	defer resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("got code %d, want %d", got, want)
+		logResponseBody(t, resp)
+	}
+
+	var results []*vcsclient.TreeEntryResult
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if r := results[0]; r.Path != "a" || r.File == nil || string(r.File.Contents) != "line2\n" {
+		t.Errorf("got result %s, want line 2 of a", asJSON(r))
+	}
+	if r := results[1]; r.Path != "/d" || r.File == nil || r.File.Type != vcsclient.DirEntry || len(r.File.Entries) != 1 {
+		t.Errorf("got result %s, want dir d", asJSON(r))
+	}
+	if r := results[2]; r.File != nil || !r.NotExist || r.Error == "" {
+		t.Errorf("got result %s, want a NotExist error", asJSON(r))
+	}
+
+	if resp := post(make([]*vcsclient.TreeEntryRequest, vcsclient.MaxTreeEntryBatch+1)); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("too many entries: got code %d, want 400", resp.StatusCode)
+	}
+}
@@ -15,18 +15,28 @@ import (
 )
 
 type Service interface {
-	// Open opens a repository. If it doesn't exist. an
-	// os.ErrNotExist-satisfying error is returned. If opening succeeds, the
-	// repository is returned.
-	Open(repoPath string) (interface{}, error)
-
-	// Close closes the repository.
-	Close(repoPath string)
+	// Acquire opens a repository (or reuses the instance that is
+	// already open) and returns a handle to it. If it doesn't exist, an
+	// os.ErrNotExist-satisfying error is returned. The caller must
+	// release the handle when it is done with the repository.
+	Acquire(repoPath string) (*RepoHandle, error)
 
 	// Clone clones the repository if a clone doesn't yet exist locally.
-	// Otherwise, it opens the repository. If no errors occur, the repository is
-	// returned.
-	Clone(repoPath string, cloneInfo *vcsclient.CloneInfo) (interface{}, error)
+	// Otherwise, it opens the repository. If no errors occur, a handle
+	// to the repository is returned, which the caller must release.
+	Clone(repoPath string, cloneInfo *vcsclient.CloneInfo) (*RepoHandle, error)
+
+	// Remove removes the local clone of the repository. It waits for
+	// all handles to the repository to be released before removing
+	// it. If the repository doesn't exist, an os.ErrNotExist-satisfying
+	// error is returned.
+	Remove(repoPath string) error
+
+	// ListRepositories lists the repositories stored locally, sorted
+	// by repository path. It also returns the total number of
+	// repositories matching opt.Prefix (the count of which is not
+	// subject to the N/Skip options).
+	ListRepositories(opt vcsclient.RepositoryListOptions) ([]*vcsclient.RepositoryInfo, uint, error)
 }
 
 type Config struct {
@@ -37,15 +47,109 @@ type Config struct {
 	Log *log.Logger
 
 	DebugLog *log.Logger
+
+	// CloneTimeout and UpdateTimeout are the maximum durations of a
+	// clone and an update of a repository, after which the VCS
+	// process is killed and a *TimeoutError is returned. They may be
+	// overridden for a single operation by CloneInfo.Timeout. If
+	// zero, there is no limit.
+	CloneTimeout, UpdateTimeout time.Duration
+
+	// UpdateInterval is how often each stored repository is updated
+	// from its remote in the background. If zero, repositories are
+	// only updated when a client requests it.
+	UpdateInterval time.Duration
+
+	// UpdateMaxBackoff is the maximum delay before retrying a
+	// repository whose background updates keep failing. If zero, 16
+	// times UpdateInterval is used.
+	UpdateMaxBackoff time.Duration
+
+	// UpdateConcurrency is the maximum number of background updates
+	// that run at once. If zero, 4 is used.
+	UpdateConcurrency int
+
+	// MaxStorageBytes is the maximum total size of the repositories in
+	// StorageDir. When it is exceeded, the least recently used
+	// repositories that are not currently open are evicted (removed);
+	// they are cloned again on their next create-or-update. If zero,
+	// there is no limit.
+	MaxStorageBytes int64
+
+	// EvictionInterval is how often the size of StorageDir is checked
+	// against MaxStorageBytes, in addition to after each clone. If
+	// zero, 5 minutes is used.
+	EvictionInterval time.Duration
+
+	// OpenRepoIdleTTL is how long an open repository is kept open
+	// after its last handle is released, so that it can be reused by
+	// later requests. If zero, 1 minute is used. If negative,
+	// repositories are closed as soon as their last handle is released.
+	OpenRepoIdleTTL time.Duration
+
+	// MaxOpenRepos is the maximum number of repositories that are kept
+	// open at once. When it is exceeded, the least recently used
+	// repositories without handles are closed. Repositories with
+	// handles are never closed, so the limit may be exceeded while
+	// they are in use. If zero, there is no limit.
+	MaxOpenRepos int
+
+	// MaintenanceInterval is how often all git repositories are checked
+	// for maintenance tasks (such as gc and writing the commit-graph)
+	// that they need, which are then run (see Maintain). If zero,
+	// maintenance only runs when it is requested.
+	MaintenanceInterval time.Duration
+
+	// MaintenanceMaxLooseObjects and MaintenanceMaxPacks are the
+	// numbers of loose objects and of packs at which a git repository
+	// is gc'd by maintenance. If zero, 6700 and 50 (git's defaults for
+	// automatic gc) are used.
+	MaintenanceMaxLooseObjects int
+	MaintenanceMaxPacks        int
+
+	// OrphanedTempDirAge, if set, is the age after which the
+	// temporary dirs of clones and removals found in StorageDir at
+	// startup are considered orphaned (left behind by a process that
+	// died) and are removed. It must be longer than any clone takes
+	// (see CloneTimeout), since the dirs of clones that are still
+	// running in other processes sharing StorageDir must be kept. If
+	// zero, temporary dirs are never removed at startup.
+	OrphanedTempDirAge time.Duration
+
+	// QuarantineDir, if set, is where orphaned temporary dirs (see
+	// OrphanedTempDirAge) are moved to (instead of being removed), so
+	// that they can be inspected. It should not be inside StorageDir.
+	QuarantineDir string
+
+	// PathRules configure how the paths of repositories requested from
+	// the HTTP API are normalized (see CanonicalRepoPath).
+	PathRules PathRules
+
+	// SkipStartupRecovery disables the cleanup of orphaned temporary
+	// dirs and the integrity check of repositories that run in the
+	// background at startup.
+	SkipStartupRecovery bool
 }
 
 // CloneDir validates vcsType and cloneURL. If they are valid, cloneDir returns
 // the local directory that the repository should be cloned to (which it may
 // already exist at). If invalid, cloneDir returns a non-nil error.
+//
+// The clone dir depends on StorageDir's layout (see Layout). While
+// StorageDir is being migrated to a new layout, the clone dir of a
+// repository that hasn't been moved yet is in the old layout.
 func (c *Config) CloneDir(repoPath string) (string, error) {
-	return filepath.Join(c.StorageDir, EncodeRepositoryPath(repoPath)), nil
+	sl, err := readStorageLayout(c.StorageDir)
+	if err != nil {
+		return "", err
+	}
+	return sl.cloneDir(c.StorageDir, repoPath)
 }
 
+// NewService creates a service that stores repositories as configured
+// by c. The service runs background goroutines (to update, evict, and
+// maintain repositories, and to close idle ones), which its Close
+// method stops; the returned Service implements io.Closer.
 func NewService(c *Config) Service {
 	if c == nil {
 		c = &Config{
@@ -54,135 +158,267 @@ func NewService(c *Config) Service {
 			DebugLog:   log.New(ioutil.Discard, "", 0),
 		}
 	}
-	return &service{
-		Config:    *c,
-		repoMu:    make(map[repoKey]*sync.RWMutex),
-		repos:     map[repoKey]interface{}{},
-		repoUsers: map[repoKey]int{},
+	s := &service{
+		Config:     *c,
+		repoMu:     make(map[repoKey]*fairRWMutex),
+		repos:      map[repoKey]*openRepo{},
+		lastAccess: map[repoKey]time.Time{},
+		jobs:       map[string]*job{},
+
+		persistedAccess: map[repoKey]time.Time{},
+		fetches:         map[fetchKey]*fetchCall{},
+		lastFetched:     map[repoKey]time.Time{},
+		poolJoins:       map[string]int{},
+		maintenance:     map[string]*MaintenanceStatus{},
+		stats:           map[repoKey]*memoizedStats{},
+		closed:          make(chan struct{}),
+	}
+	s.repoClosed = sync.NewCond(&s.repoMuMu)
+	s.loadAliases()
+	if !c.SkipStartupRecovery {
+		s.recovered = make(chan struct{})
+		go s.recoverStorage()
 	}
+	if c.UpdateInterval > 0 {
+		s.scheduler = newUpdateScheduler(c)
+		s.scheduler.listRepos = s.listRepoPaths
+		s.scheduler.update = s.update
+		s.scheduler.lastRead = s.lastRead
+		go s.scheduler.run()
+	}
+	if c.MaxStorageBytes > 0 {
+		s.evictNeeded = make(chan struct{}, 1)
+		go s.runEvictor()
+	}
+	if s.openRepoIdleTTL() > 0 {
+		go s.runOpenRepoReaper()
+	}
+	if c.MaintenanceInterval > 0 {
+		go s.runMaintainer()
+	}
+	return s
+}
+
+// Close stops the service's background goroutines. It doesn't wait for
+// work that they have already started (such as a running update) to
+// finish, and it doesn't close repositories that are open.
+func (s *service) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		if s.scheduler != nil {
+			s.scheduler.stop()
+		}
+	})
+	return nil
 }
 
 type service struct {
 	Config
 
-	// repoMu prevents more than one goroutine from simultaneously
-	// cloning the same repository.
-	repoMu map[repoKey]*sync.RWMutex
+	// repoMu holds each repo's lock. Handles returned by Acquire and
+	// Clone hold the read lock, and cloning, updating, and removing a
+	// repo take the write lock.
+	repoMu map[repoKey]*fairRWMutex
+
+	// repos holds all repos that are open, along with their number of
+	// unreleased handles. Repos without handles are closed after
+	// OpenRepoIdleTTL, or sooner if there are more than MaxOpenRepos.
+	repos map[repoKey]*openRepo
 
-	// repo and repoUsers holds all repos that have been opened and not yet
-	// closed. When the count goes to 0, the repo can be freed. It is
-	// protected by repoMuMu.
-	repos     map[repoKey]interface{}
-	repoUsers map[repoKey]int
+	// repoClosed is signaled when a handle to a repo is released. Its
+	// locker is repoMuMu.
+	repoClosed *sync.Cond
 
-	// repoMuMu synchronizes access to repoMu, repo, and repoUsers.
+	// lastAccess holds the time that each repo was last acquired by a
+	// caller of Acquire, and persistedAccess holds the time that was
+	// last persisted in each repo's metadata.
+	lastAccess      map[repoKey]time.Time
+	persistedAccess map[repoKey]time.Time
+
+	// repoMuMu synchronizes access to repoMu, repos, lastAccess, and
+	// persistedAccess.
 	repoMuMu sync.RWMutex
+
+	// scheduler updates repos in the background (or is nil if
+	// UpdateInterval is zero).
+	scheduler *updateScheduler
+
+	// closed is closed by Close to stop the background goroutines.
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	// evictNeeded is sent to when a clone may have caused StorageDir
+	// to exceed MaxStorageBytes (or is nil if there is no limit).
+	evictNeeded chan struct{}
+
+	// progress broadcasts the progress of clones and updates.
+	progress progressHub
+
+	// jobs holds asynchronous clone-or-update jobs by ID. It is
+	// protected by jobsMu.
+	jobs   map[string]*job
+	jobsMu sync.Mutex
+
+	// fetches holds the in-progress fetches of each repo (see
+	// fetchKey), and
+	// lastFetched holds the time that each repo was last successfully
+	// cloned or updated. They are protected by fetchesMu.
+	fetches     map[fetchKey]*fetchCall
+	lastFetched map[repoKey]time.Time
+	fetchesMu   sync.Mutex
+
+	// metadataMu serializes reads and writes of repos' metadata files.
+	metadataMu sync.Mutex
+
+	// broken holds the repos that failed the integrity check at
+	// startup, added as the check finds them. It is protected by
+	// brokenMu. recovered is closed when
+	// the check is done (or is nil if SkipStartupRecovery is set).
+	broken    []*BrokenRepository
+	brokenMu  sync.Mutex
+	recovered chan struct{}
+
+	// migrateMu serializes layout migrations.
+	migrateMu sync.Mutex
+
+	// aliases maps each alias to its canonical repository path. It is
+	// protected by aliasesMu.
+	aliases   map[string]string
+	aliasesMu sync.RWMutex
+
+	// poolMu serializes changes to the object pools of fork networks
+	// (see ObjectPool). poolJoins holds the number of forks that are
+	// being cloned to borrow from each pool (by pool dir), which keeps
+	// the pool from being removed or having refs deleted meanwhile. It
+	// is protected by poolMu.
+	poolMu    sync.Mutex
+	poolJoins map[string]int
+
+	// maintenance holds the status of each git repo (by repo path) as
+	// last checked by the maintainer. It is protected by
+	// maintenanceMu.
+	maintenance   map[string]*MaintenanceStatus
+	maintenanceMu sync.Mutex
+
+	// stats memoizes the stats of each repo along with the version of
+	// the repo they were computed for. It is protected by statsMu.
+	stats   map[repoKey]*memoizedStats
+	statsMu sync.Mutex
 }
 
 type repoKey struct {
 	cloneDir string
 }
 
-func (s *service) Open(repoPath string) (interface{}, error) {
-	cloneDir, err := s.CloneDir(repoPath)
-	if err != nil {
-		return nil, err
-	}
-	return s.open(cloneDir)
-}
-
-func (s *service) open(cloneDir string) (interface{}, error) {
-	key := repoKey{cloneDir}
-	vcsType, err := vcsTypeFromDir(cloneDir)
-	if err != nil {
-		return nil, err
-	}
-
-	// Quick check if another goroutine has already opened (and not
-	// yet closed) the repo. Use that instance if so.
-	s.repoMuMu.Lock()
-	if repo := s.repos[key]; repo != nil {
-		s.repoMuMu.Unlock()
-		return repo, nil
-	}
-	s.repoMuMu.Unlock()
-
-	if fi, err := os.Stat(cloneDir); err != nil {
-		return nil, err
-	} else if !fi.Mode().IsDir() {
-		return nil, fmt.Errorf("clone path %q is not a directory", cloneDir)
-	}
-	repo, err := vcs.Open(vcsType, cloneDir)
-	if err != nil {
-		return nil, err
-	}
-
+// recordAccess records that the repo was just read. It returns
+// whether the time of the access should also be persisted in the
+// repo's metadata, which is done at most once per
+// metadataAccessResolution.
+func (s *service) recordAccess(key repoKey) (persist bool) {
 	s.repoMuMu.Lock()
 	defer s.repoMuMu.Unlock()
-	s.repoUsers[key]++
-	if repo := s.repos[key]; repo != nil {
-		// Another goroutine raced us to open this repo. Use ours, not
-		// theirs, so that there is only 1 instance of this repo in
-		// use at a time.
-		return repo, nil
+	now := time.Now()
+	s.lastAccess[key] = now
+	if now.Sub(s.persistedAccess[key]) >= metadataAccessResolution {
+		s.persistedAccess[key] = now
+		return true
 	}
-	// Otherwise, tell other goroutines to use the repo we just opened.
-	s.repos[key] = repo
-
-	return repo, nil
+	return false
 }
 
-func (s *service) Close(repoPath string) {
+// lastRead returns the time that the repo was last acquired by a
+// caller of Acquire, or the zero time if it hasn't been opened.
+func (s *service) lastRead(repoPath string) time.Time {
 	cloneDir, err := s.CloneDir(repoPath)
 	if err != nil {
-		panic(err)
-	}
-	s.repoMuMu.Lock()
-	defer s.repoMuMu.Unlock()
-	key := repoKey{cloneDir}
-	s.repoUsers[key]--
-	if s.repoUsers[key] == 0 {
-		delete(s.repoUsers, key)
-		delete(s.repos, key)
+		return time.Time{}
 	}
+	s.repoMuMu.RLock()
+	defer s.repoMuMu.RUnlock()
+	return s.lastAccess[repoKey{cloneDir}]
 }
 
-func (s *service) Clone(repoPath string, cloneInfo *vcsclient.CloneInfo) (interface{}, error) {
+func (s *service) Clone(repoPath string, cloneInfo *vcsclient.CloneInfo) (*RepoHandle, error) {
+	return s.clone(repoPath, cloneInfo, nil)
+}
+
+// clone is like Clone, but it also calls progress (if non-nil) with
+// the progress of the clone as reported by the VCS.
+func (s *service) clone(repoPath string, cloneInfo *vcsclient.CloneInfo, progress func(cloneProgress)) (h *RepoHandle, err error) {
 	cloneDir, err := s.CloneDir(repoPath)
 	if err != nil {
 		return nil, err
 	}
 
-	// See if the clone directory exists and return immediately (without
-	// locking) if so.
-	if r, err := s.open(repoPath); !os.IsNotExist(err) {
+	// See if the clone directory exists and return immediately (with
+	// only the read lock) if so.
+	if h, err := s.acquireShared(cloneDir); !os.IsNotExist(err) {
 		if err == nil {
 			s.debugLogf("Clone(%s): repository already exists at %s", repoPath, cloneDir)
 		} else {
 			s.debugLogf("Clone(%s): opening existing repository at %s failed: %s", repoPath, cloneDir, err)
 		}
-		return r, err
+		return h, err
+	}
+
+	// A git fork borrows the objects it shares with its parent from
+	// the fork network's object pool, and only stores its own. The
+	// pool is joined before the repo's write lock is taken, since
+	// poolMu must not be acquired while holding a repo lock (pool
+	// operations lock the pool's members while holding poolMu).
+	var poolDir string
+	if cloneInfo.ForkParent != "" && cloneInfo.VCS == "git" && cloneInfo.ForkParent != repoPath {
+		poolDir, err = s.joinForkNetwork(s.CanonicalRepoPath(cloneInfo.ForkParent))
+		if err != nil {
+			s.Log.Printf("Cloning %s without sharing objects with its fork parent %s: %s.", repoPath, cloneInfo.ForkParent, err)
+		} else {
+			defer s.releasePool(poolDir)
+		}
 	}
 
 	// The local clone directory doesn't exist, so we need to clone the repository.
-	mu := s.Mutex(repoKey{cloneDir})
+	key := repoKey{cloneDir}
+	mu := s.Mutex(key)
 	mu.Lock()
-	defer mu.Unlock()
+	locked := true
+	defer func() {
+		if locked {
+			mu.Unlock()
+		}
+	}()
+
+	// shared returns a handle to the repo that holds the read lock,
+	// which the write lock is downgraded to, so that the repo can't be
+	// modified between cloning and the caller reading it.
+	shared := func() (*RepoHandle, error) {
+		h, err := s.acquire(cloneDir)
+		if err != nil {
+			return nil, err
+		}
+		mu.Downgrade()
+		locked = false
+		return withUnlock(h, mu.RUnlock), nil
+	}
 
 	// Check again after obtaining the lock, so we don't clone multiple times.
-	if r, err := s.open(cloneDir); !os.IsNotExist(err) {
+	if _, err := vcsTypeFromDir(cloneDir); !os.IsNotExist(err) {
 		if err == nil {
 			s.debugLogf("Clone(%s): after obtaining clone lock, repository already exists at %s", repoPath, cloneDir)
-		} else {
-			s.debugLogf("Clone(%s): after obtaining clone lock, opening existing repository at %s failed: %s", repoPath, cloneDir, err)
+			return shared()
 		}
-		return r, err
+		s.debugLogf("Clone(%s): after obtaining clone lock, opening existing repository at %s failed: %s", repoPath, cloneDir, err)
+		return nil, err
 	}
 
 	start := time.Now()
 	msg := fmt.Sprintf("%s to %s", repoPath, cloneDir)
 	s.Log.Print("Cloning ", msg, "...")
 
+	// Report the clone's progress to watchers (and to the caller's
+	// progress func).
+	defer func() { s.progress.publishDone(repoPath, nil, err) }()
+	report := s.progress.reporter(repoPath, progress)
+
 	// "Atomically" clone the repository. First, clone it to a temporary sibling
 	// directory. Once the clone is complete, "atomically"
 	// rename it to the intended cloneDir.
@@ -202,12 +438,58 @@ func (s *service) Clone(repoPath string, cloneInfo *vcsclient.CloneInfo) (interf
 	s.debugLogf("Clone(%s, %s): cloning to temporary sibling dir %s", repoPath, cloneTmpDir)
 	defer os.RemoveAll(cloneTmpDir)
 
-	cloneOpt := vcs.CloneOpt{Bare: true, Mirror: true, RemoteOpts: cloneInfo.RemoteOpts}
-	_, err = vcs.Clone(cloneInfo.VCS, cloneInfo.CloneURL, cloneTmpDir, cloneOpt)
-	if err != nil {
+	timeout := cloneInfo.Timeout
+	if timeout == 0 {
+		timeout = s.CloneTimeout
+	}
+	cancel, stopTimer := timeoutCanceler(timeout)
+	defer stopTimer()
+	if err := cloneRepo(cloneInfo, cloneTmpDir, poolDir, report, cancel); err != nil {
+		if err == errCanceled {
+			s.Log.Print("Cloning ", msg, " timed out after ", timeout)
+			err = &TimeoutError{Op: "clone", RepoPath: repoPath, Timeout: timeout}
+		}
 		return nil, err
 	}
+	if poolDir != "" {
+		// The write lock is released while the new clone joins the
+		// pool (see above). Nobody else uses the clone's temporary
+		// dir, but the repo may be cloned by another caller meanwhile,
+		// so check again after reacquiring the lock.
+		mu.Unlock()
+		s.poolMu.Lock()
+		err := s.addPoolMember(poolDir, repoPath, cloneTmpDir)
+		s.poolMu.Unlock()
+		mu.Lock()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := vcsTypeFromDir(cloneDir); !os.IsNotExist(err) {
+			if err == nil {
+				s.debugLogf("Clone(%s): after joining object pool, repository already exists at %s", repoPath, cloneDir)
+				return shared()
+			}
+			return nil, err
+		}
+	}
+	now := time.Now()
+	md := &vcsclient.RepositoryMetadata{
+		VCS:           cloneInfo.VCS,
+		CloneURL:      redactCloneURL(cloneInfo.CloneURL),
+		Created:       now,
+		LastFetched:   now,
+		FetchDuration: now.Sub(start),
+		LastAccessed:  now,
+	}
+	if poolDir != "" {
+		md.ForkParent = cloneInfo.ForkParent
+	}
+	if err := writeMetadata(cloneTmpDir, md); err != nil {
+		// The clone itself succeeded, so don't fail it.
+		s.Log.Printf("Writing metadata of %s failed: %s.", repoPath, err)
+	}
 	s.debugLogf("Clone(%s, %s): cloned to temporary sibling dir %s; now renaming to intended clone dir %s", cloneInfo.VCS, cloneInfo.CloneURL, cloneTmpDir, cloneDir)
+	report(cloneProgress{Phase: "renaming"})
 
 	if err := os.Rename(cloneTmpDir, cloneDir); err != nil {
 		s.debugLogf("Clone(%s, %s): Rename(%s -> %s) failed: %s", cloneInfo.VCS, cloneInfo.CloneURL, cloneTmpDir, cloneDir)
@@ -218,17 +500,200 @@ func (s *service) Clone(repoPath string, cloneInfo *vcsclient.CloneInfo) (interf
 		s.Log.Print("Finished cloning ", msg, " in ", time.Since(start))
 	}()
 
-	return s.open(cloneDir)
+	s.recordAccess(key)
+	s.recordFetch(key)
+	s.scheduleEviction()
+
+	return shared()
+}
+
+// Update updates the repository from its default remote, using the
+// remote options and timeout (if any) in cloneInfo. It doesn't count
+// as a read of the repository (for eviction and update scheduling).
+// It waits for current readers of the repository to finish, and
+// blocks new readers until it is done.
+//
+// Concurrent updates of the same repository share a single fetch (see
+// sharedFetch).
+func (s *service) Update(repoPath string, cloneInfo *vcsclient.CloneInfo) (*vcs.UpdateResult, error) {
+	return s.sharedFetch(repoPath, cloneInfo, nil)
+}
+
+// fetch is like Update, but it also calls progress (if non-nil) with
+// the progress of the update as reported by the VCS.
+func (s *service) fetch(repoPath string, cloneInfo *vcsclient.CloneInfo, progress func(cloneProgress)) (*vcs.UpdateResult, error) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	mu := s.Mutex(repoKey{cloneDir})
+	mu.Lock()
+	defer mu.Unlock()
+
+	// A repository that an integrity check found to be broken is
+	// cloned again instead of being updated.
+	if md, err := readMetadata(cloneDir); err == nil && md.NeedsReclone {
+		return s.reclone(repoPath, cloneDir, md, cloneInfo, progress)
+	}
+
+	h, err := s.acquire(cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Release()
+
+	start := time.Now()
+	result, err := s.updateRepo(repoPath, h.Repo, cloneInfo, progress)
+	s.recordFetchMetadata(cloneDir, cloneInfo, start, err)
+	return result, err
+}
+
+// updateRepo updates an opened repository from its default remote and
+// reports the update's progress to watchers (and to progress, if
+// non-nil).
+func (s *service) updateRepo(repoPath string, repo interface{}, cloneInfo *vcsclient.CloneInfo, progress func(cloneProgress)) (result *vcs.UpdateResult, err error) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	vcsType, err := vcsTypeFromDir(cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	branchRepo, ok := repo.(branchLister)
+	if !ok {
+		return nil, fmt.Errorf("remote updates not yet implemented for %T", repo)
+	}
+
+	s.progress.publishPhase(repoPath, "updating")
+	defer func() { s.progress.publishDone(repoPath, result, err) }()
+
+	// Determine the changes by comparing the branches before and
+	// after fetching.
+	before, err := branchHeads(branchRepo)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := cloneInfo.Timeout
+	if timeout == 0 {
+		timeout = s.UpdateTimeout
+	}
+	cancel, stopTimer := timeoutCanceler(timeout)
+	defer stopTimer()
+	if err := fetchRepo(vcsType, cloneDir, cloneInfo.RemoteOpts, s.progress.reporter(repoPath, progress), cancel); err != nil {
+		if err == errCanceled {
+			s.Log.Print("Updating ", repoPath, " timed out after ", timeout)
+			err = &TimeoutError{Op: "update", RepoPath: repoPath, Timeout: timeout}
+		}
+		return nil, err
+	}
+
+	after, err := branchHeads(branchRepo)
+	if err != nil {
+		return nil, err
+	}
+	return diffBranchHeads(repo, before, after), nil
+}
+
+func (s *service) Remove(repoPath string) error {
+	_, err := s.remove(repoPath, true)
+	return err
+}
+
+// remove removes the repo's clone dir. If wait is true, it waits for
+// all handles to the repo to be released first. Otherwise, it leaves
+// a repo that has handles in place and returns false.
+func (s *service) remove(repoPath string, wait bool) (removed bool, err error) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return false, err
+	}
+	key := repoKey{cloneDir}
+
+	// Leave the repo's object pool (if any) after releasing its lock,
+	// since leaving reads the pool's other members.
+	var poolDir string
+	defer func() {
+		if removed && poolDir != "" {
+			s.leavePool(poolDir, repoPath)
+		}
+	}()
+
+	// Wait for (or, if wait is false, skip the repository if there
+	// are) readers, and prevent the repository from being cloned or
+	// updated while we remove it.
+	mu := s.Mutex(key)
+	if wait {
+		mu.Lock()
+	} else if !mu.TryLock() {
+		return false, nil
+	}
+	defer mu.Unlock()
+
+	vcsType, err := vcsTypeFromDir(cloneDir)
+	if err != nil {
+		return false, err
+	}
+	if vcsType == "git" {
+		if poolDir, err = s.repoPool(cloneDir); err != nil {
+			return false, err
+		}
+	}
+
+	// "Atomically" remove the repository by first renaming it into a
+	// temporary sibling directory, so that nobody observes a
+	// partially removed repository at cloneDir.
+	rmTmpDir, err := ioutil.TempDir(filepath.Dir(cloneDir), "_tmp_rm_"+filepath.Base(cloneDir)+"-")
+	if err != nil {
+		return false, err
+	}
+	defer os.RemoveAll(rmTmpDir)
+
+	s.repoMuMu.Lock()
+	for s.repoUsers(key) > 0 {
+		if !wait {
+			s.repoMuMu.Unlock()
+			return false, nil
+		}
+		// Wait for all handles to the repository to be released.
+		s.debugLogf("Remove(%s): waiting for %d handles to repository to be released", repoPath, s.repoUsers(key))
+		s.repoClosed.Wait()
+	}
+	var closed *openRepo
+	err = os.Rename(cloneDir, filepath.Join(rmTmpDir, filepath.Base(cloneDir)))
+	if err == nil {
+		closed = s.repos[key]
+		delete(s.repos, key)
+		delete(s.lastAccess, key)
+		delete(s.persistedAccess, key)
+	}
+	s.repoMuMu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if closed != nil {
+		closeRepo(closed.repo)
+	}
+	s.forgetFetch(key)
+	s.forgetMaintenance(repoPath)
+	s.forgetStats(key)
+
+	s.Log.Print("Removed ", repoPath, " at ", cloneDir)
+	return true, nil
 }
 
-func (s *service) Mutex(key repoKey) *sync.RWMutex {
+func (s *service) Mutex(key repoKey) *fairRWMutex {
+	key = s.lockKey(key)
+
 	s.repoMuMu.Lock()
 	defer s.repoMuMu.Unlock()
 
 	if mu, ok := s.repoMu[key]; ok {
 		return mu
 	}
-	s.repoMu[key] = &sync.RWMutex{}
+	s.repoMu[key] = newFairRWMutex()
 	return s.repoMu[key]
 }
 
@@ -0,0 +1,312 @@
+package vcsstore
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "sourcegraph.com/sourcegraph/go-vcs/vcs/git"
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// newTestService creates a service whose StorageDir is a new
+// temporary directory. The caller should remove the directory when
+// done.
+func newTestService(t *testing.T) (*service, string) {
+	storageDir, err := ioutil.TempDir("", "vcsstore-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewService(&Config{
+		StorageDir: storageDir,
+		Log:        log.New(ioutil.Discard, "", 0),
+	}).(*service), storageDir
+}
+
+// initBareGitRepo creates a bare git repository at the given
+// repository path in the service's StorageDir.
+func initBareGitRepo(t *testing.T, s *service, repoPath string) string {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(cloneDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("git", "init", "--bare")
+	cmd.Dir = cloneDir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git init --bare failed: %s\n%s", err, out)
+	}
+	return cloneDir
+}
+
+// initRemoteGitRepo creates a git repository in a new temporary
+// directory with a single commit on branch b. The caller should
+// remove the directory when done.
+func initRemoteGitRepo(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "vcsstore-test-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init"},
+		{"checkout", "-b", "b"},
+		{"-c", "user.name=a", "-c", "user.email=a@a.com", "commit", "--allow-empty", "-m", "x"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v failed: %s\n%s", args, err, out)
+		}
+	}
+	return dir
+}
+
+func TestService_Close(t *testing.T) {
+	storageDir, err := ioutil.TempDir("", "vcsstore-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+
+	s := NewService(&Config{
+		StorageDir:          storageDir,
+		Log:                 log.New(ioutil.Discard, "", 0),
+		UpdateInterval:      time.Millisecond,
+		MaxStorageBytes:     1,
+		EvictionInterval:    time.Millisecond,
+		MaintenanceInterval: time.Millisecond,
+	}).(*service)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Closing again is a no-op.
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.scheduler.stopped:
+	default:
+		t.Error("update scheduler was not stopped")
+	}
+}
+
+func TestService_ListRepositories(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	for _, repoPath := range []string{"a.com/x/y", "a.com/x/z", "b.com/q", "a.com/x-y"} {
+		initBareGitRepo(t, s, repoPath)
+	}
+	// Temporary clone dirs and non-repo dirs should be ignored.
+	if err := os.MkdirAll(filepath.Join(storageDir, "a.com/x/_tmp_w-123/objects"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(storageDir, "c.com/empty"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opt       vcsclient.RepositoryListOptions
+		wantPaths []string
+		wantTotal uint
+	}{
+		{
+			opt:       vcsclient.RepositoryListOptions{},
+			wantPaths: []string{"a.com/x-y", "a.com/x/y", "a.com/x/z", "b.com/q"},
+			wantTotal: 4,
+		},
+		{
+			opt:       vcsclient.RepositoryListOptions{Prefix: "a.com/x/"},
+			wantPaths: []string{"a.com/x/y", "a.com/x/z"},
+			wantTotal: 2,
+		},
+		{
+			opt:       vcsclient.RepositoryListOptions{Prefix: "a.com/x"},
+			wantPaths: []string{"a.com/x-y", "a.com/x/y", "a.com/x/z"},
+			wantTotal: 3,
+		},
+		{
+			opt:       vcsclient.RepositoryListOptions{N: 2, Skip: 1},
+			wantPaths: []string{"a.com/x/y", "a.com/x/z"},
+			wantTotal: 4,
+		},
+		{
+			opt:       vcsclient.RepositoryListOptions{Skip: 10},
+			wantPaths: nil,
+			wantTotal: 4,
+		},
+	}
+	for _, test := range tests {
+		repos, total, err := s.ListRepositories(test.opt)
+		if err != nil {
+			t.Errorf("%+v: ListRepositories: %s", test.opt, err)
+			continue
+		}
+		var paths []string
+		for _, repo := range repos {
+			paths = append(paths, repo.RepoPath)
+			if repo.VCS != "git" {
+				t.Errorf("%s: got VCS %q, want %q", repo.RepoPath, repo.VCS, "git")
+			}
+			if repo.Size == 0 {
+				t.Errorf("%s: got Size == 0", repo.RepoPath)
+			}
+			if repo.LastFetched.IsZero() {
+				t.Errorf("%s: got zero LastFetched", repo.RepoPath)
+			}
+		}
+		if !reflect.DeepEqual(paths, test.wantPaths) {
+			t.Errorf("%+v: got repos %v, want %v", test.opt, paths, test.wantPaths)
+		}
+		if total != test.wantTotal {
+			t.Errorf("%+v: got total %d, want %d", test.opt, total, test.wantTotal)
+		}
+	}
+}
+
+func TestService_Remove(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	repoPath := "a.com/x/y"
+	cloneDir := initBareGitRepo(t, s, repoPath)
+
+	// Removal should wait until the handle is released.
+	h, err := s.Acquire(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := make(chan error)
+	go func() {
+		removed <- s.Remove(repoPath)
+	}()
+	select {
+	case err := <-removed:
+		t.Fatalf("Remove returned (err == %v) before handle was released", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	h.Release()
+	if err := <-removed; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(cloneDir); !os.IsNotExist(err) {
+		t.Errorf("got Stat(cloneDir) err == %v, want os.IsNotExist", err)
+	}
+	if _, err := s.Acquire(repoPath); !os.IsNotExist(err) {
+		t.Errorf("got Acquire err == %v, want os.IsNotExist", err)
+	}
+	tmpDirs, _ := filepath.Glob(filepath.Join(filepath.Dir(cloneDir), "_tmp_*"))
+	if len(tmpDirs) != 0 {
+		t.Errorf("got leftover temporary dirs %v", tmpDirs)
+	}
+
+	if err := s.Remove(repoPath); !os.IsNotExist(err) {
+		t.Errorf("got second Remove err == %v, want os.IsNotExist", err)
+	}
+}
+
+func TestService_evictLRU(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	var sizes []int64
+	repoPaths := []string{"a.com/x", "a.com/y", "a.com/z"}
+	for _, repoPath := range repoPaths {
+		size, err := dirSize(initBareGitRepo(t, s, repoPath))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, size)
+	}
+
+	// Make a.com/x the most recently used and a.com/z the least.
+	now := time.Now()
+	for i, repoPath := range repoPaths {
+		cloneDir, _ := s.CloneDir(repoPath)
+		s.lastAccess[repoKey{cloneDir}] = now.Add(time.Duration(-i) * time.Hour)
+	}
+	// a.com/z is the least recently used, but it's open, so it can't
+	// be evicted.
+	h, err := s.acquire(mustCloneDir(t, s, "a.com/z"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Release()
+
+	// Require evicting 1 repo.
+	s.MaxStorageBytes = sizes[0] + sizes[1] + sizes[2] - 1
+	before := evictions.Value()
+	if err := s.evictLRU(); err != nil {
+		t.Fatal(err)
+	}
+	if got := evictions.Value() - before; got != 1 {
+		t.Errorf("got %d evictions, want 1", got)
+	}
+
+	for repoPath, wantExists := range map[string]bool{"a.com/x": true, "a.com/y": false, "a.com/z": true} {
+		_, err := os.Stat(mustCloneDir(t, s, repoPath))
+		if exists := err == nil; exists != wantExists {
+			t.Errorf("%s: got exists == %v, want %v", repoPath, exists, wantExists)
+		}
+	}
+}
+
+func TestService_Clone_progress(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+	remoteDir := initRemoteGitRepo(t)
+	defer os.RemoveAll(remoteDir)
+
+	events, stop := s.WatchProgress("a.com/x")
+	defer stop()
+
+	// Use a file:// URL so that git uses the pack protocol (and
+	// reports progress) instead of copying the objects.
+	h, err := s.Clone("a.com/x", &vcsclient.CloneInfo{VCS: "git", CloneURL: "file://" + remoteDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+
+	var phases []string
+	var lines int
+	for ev := range events {
+		switch ev.Type {
+		case vcsclient.ProgressPhase:
+			phases = append(phases, ev.Phase)
+		case vcsclient.ProgressLine:
+			lines++
+		case vcsclient.ProgressError:
+			t.Fatalf("got error event: %s", ev.Error)
+		}
+		if ev.Final() {
+			break
+		}
+	}
+	if lines == 0 {
+		t.Error("got no progress lines")
+	}
+	if len(phases) < 2 || phases[len(phases)-2] != "receiving" || phases[len(phases)-1] != "renaming" {
+		t.Errorf("got phases %v, want ..., receiving, renaming", phases)
+	}
+}
+
+func mustCloneDir(t *testing.T, s *service, repoPath string) string {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cloneDir
+}
@@ -0,0 +1,286 @@
+package vcsstore
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+// numLargestBlobs is the number of largest blobs listed in a
+// repository's stats.
+const numLargestBlobs = 10
+
+// memoizedStats are the stats of a repository and the version of the
+// repository (its ref tips and object counts) that they were computed
+// for. done is closed when they have been computed, so that concurrent
+// callers can wait for the same computation.
+type memoizedStats struct {
+	version string
+	done    chan struct{}
+	stats   *vcsclient.RepositoryStats
+	err     error
+}
+
+// Stats returns the stats of the repository at repoPath. Computing
+// stats walks the whole repository, so they are memoized until its
+// refs, its HEAD, or its objects (e.g., after maintenance) change,
+// and concurrent callers share a single computation. It holds the
+// repository's read lock while computing them.
+func (s *service) Stats(repoPath string) (*vcsclient.RepositoryStats, error) {
+	cloneDir, err := s.CloneDir(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key := repoKey{cloneDir}
+	mu := s.Mutex(key)
+	mu.RLock()
+	defer mu.RUnlock()
+
+	vcsType, err := vcsTypeFromDir(cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	if vcsType != "git" {
+		return nil, fmt.Errorf("stats not yet implemented for VCS %q", vcsType)
+	}
+
+	refs, head, err := gitRefTips(cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := gitCountObjects(cloneDir)
+	if err != nil {
+		return nil, err
+	}
+	version := fmt.Sprintf("%x", sha1.Sum([]byte(refs+"HEAD "+head+"\n"+fmt.Sprint(counts))))
+
+	s.statsMu.Lock()
+	if m := s.stats[key]; m != nil && m.version == version {
+		s.statsMu.Unlock()
+		<-m.done
+		return m.stats, m.err
+	}
+	m := &memoizedStats{version: version, done: make(chan struct{})}
+	s.stats[key] = m
+	s.statsMu.Unlock()
+
+	defer close(m.done)
+	start := time.Now()
+	m.stats, m.err = gitStats(cloneDir, refs, head, counts)
+	if m.err != nil {
+		// Don't memoize errors.
+		s.statsMu.Lock()
+		if s.stats[key] == m {
+			delete(s.stats, key)
+		}
+		s.statsMu.Unlock()
+		return nil, m.err
+	}
+	s.debugLogf("Stats(%s): computed in %s", repoPath, time.Since(start))
+	return m.stats, nil
+}
+
+// forgetStats forgets the memoized stats of the repo (e.g., because it
+// was removed).
+func (s *service) forgetStats(key repoKey) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	delete(s.stats, key)
+}
+
+// gitRefTips returns the refs of the git repository in dir (one
+// "<object> <refname>" line per ref) and the ref that its HEAD points
+// to (or "" if HEAD is detached).
+func gitRefTips(dir string) (refs, head string, err error) {
+	cmd := exec.Command("git", "for-each-ref", "--format=%(objectname) %(refname)")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("git for-each-ref failed: %s", err)
+	}
+
+	cmd = exec.Command("git", "symbolic-ref", "-q", "HEAD")
+	cmd.Dir = dir
+	headOut, err := cmd.Output()
+	if _, detached := err.(*exec.ExitError); err != nil && !detached {
+		return "", "", err
+	}
+	return string(out), strings.TrimSpace(string(headOut)), nil
+}
+
+// gitStats computes the stats of the git repository in dir, whose refs
+// and HEAD are as returned by gitRefTips and whose object counts are
+// as returned by gitCountObjects.
+func gitStats(dir, refs, head string, counts map[string]int) (*vcsclient.RepositoryStats, error) {
+	stats := &vcsclient.RepositoryStats{Computed: time.Now()}
+
+	var err error
+	if stats.Size, err = dirSize(dir); err != nil {
+		return nil, err
+	}
+	stats.Packs = counts["packs"]
+	stats.Objects = counts["count"] + counts["in-pack"]
+
+	var hasHead bool
+	for _, line := range strings.Split(strings.TrimSpace(refs), "\n") {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch name := parts[1]; {
+		case strings.HasPrefix(name, "refs/heads/"):
+			stats.Refs.Branches++
+		case strings.HasPrefix(name, "refs/tags/"):
+			stats.Refs.Tags++
+		default:
+			stats.Refs.Other++
+		}
+		hasHead = hasHead || (head != "" && parts[1] == head)
+	}
+
+	if hasHead && strings.HasPrefix(head, "refs/heads/") {
+		stats.DefaultBranch = strings.TrimPrefix(head, "refs/heads/")
+		out, err := gitCmdOutput(dir, "rev-list", "--count", head)
+		if err != nil {
+			return nil, err
+		}
+		if stats.Commits, err = strconv.Atoi(out); err != nil {
+			return nil, fmt.Errorf("git rev-list: bad count %q", out)
+		}
+
+		// The committer dates of the root commits (there may be more
+		// than one) and of the head commit.
+		out, err = gitCmdOutput(dir, "log", "--max-parents=0", "--format=%ct", head)
+		if err != nil {
+			return nil, err
+		}
+		for _, ct := range strings.Fields(out) {
+			if t, err := parseUnixTime(ct); err == nil && (stats.FirstCommit.IsZero() || t.Before(stats.FirstCommit)) {
+				stats.FirstCommit = t
+			}
+		}
+		out, err = gitCmdOutput(dir, "log", "-1", "--format=%ct", head)
+		if err != nil {
+			return nil, err
+		}
+		if stats.LastCommit, err = parseUnixTime(out); err != nil {
+			return nil, fmt.Errorf("git log: bad commit date %q", out)
+		}
+	}
+
+	if stats.LargestBlobs, err = gitLargestBlobs(dir, numLargestBlobs); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// gitLargestBlobs returns the n largest blobs that are reachable from
+// the refs of the git repository in dir, largest first.
+func gitLargestBlobs(dir string, n int) ([]*vcsclient.BlobSize, error) {
+	// Pipe the reachable objects (and the paths at which they were
+	// found) into git cat-file to get their types and sizes. The pipe
+	// is only held open by the two processes, so if either of them
+	// exits early, the other one doesn't block on it.
+	revList := exec.Command("git", "rev-list", "--objects", "--all")
+	revList.Dir = dir
+	catFile := exec.Command("git", "cat-file", "--batch-check=%(objecttype) %(objectname) %(objectsize) %(rest)")
+	catFile.Dir = dir
+
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	revList.Stdout = pw
+	catFile.Stdin = pr
+	out, err := catFile.StdoutPipe()
+	if err != nil {
+		pr.Close()
+		pw.Close()
+		return nil, err
+	}
+
+	err = revList.Start()
+	if err == nil {
+		if err = catFile.Start(); err != nil {
+			revList.Process.Kill()
+			revList.Wait()
+		}
+	}
+	pr.Close()
+	pw.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := []*vcsclient.BlobSize{}
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), " ", 4)
+		if len(parts) < 3 || parts[0] != "blob" {
+			continue
+		}
+		size, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		if len(blobs) == n && size <= blobs[n-1].Size {
+			continue
+		}
+		b := &vcsclient.BlobSize{ID: parts[1], Size: size}
+		if len(parts) == 4 {
+			b.Path = parts[3]
+		}
+		i := sort.Search(len(blobs), func(i int) bool { return blobs[i].Size < size })
+		blobs = append(blobs, nil)
+		copy(blobs[i+1:], blobs[i:])
+		blobs[i] = b
+		if len(blobs) > n {
+			blobs = blobs[:n]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		revList.Process.Kill()
+		catFile.Process.Kill()
+		revList.Wait()
+		catFile.Wait()
+		return nil, err
+	}
+
+	catFileErr := catFile.Wait()
+	if err := revList.Wait(); err != nil {
+		return nil, fmt.Errorf("git rev-list failed: %s", err)
+	}
+	if catFileErr != nil {
+		return nil, fmt.Errorf("git cat-file failed: %s", catFileErr)
+	}
+	return blobs, nil
+}
+
+// gitCmdOutput runs git with args in dir and returns its trimmed
+// output.
+func gitCmdOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %s", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+func parseUnixTime(s string) (time.Time, error) {
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
@@ -0,0 +1,98 @@
+package vcsstore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"sourcegraph.com/sourcegraph/vcsstore/vcsclient"
+)
+
+func TestService_Stats(t *testing.T) {
+	s, storageDir := newTestService(t)
+	defer os.RemoveAll(storageDir)
+	defer s.Close()
+
+	remote := initRemoteGitRepo(t)
+	defer os.RemoveAll(remote)
+	for name, size := range map[string]int{"big": 3000, "small": 10} {
+		if err := ioutil.WriteFile(filepath.Join(remote, name), []byte(strings.Repeat("x", size)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gitOutput(t, remote, "add", ".")
+	gitOutput(t, remote, "-c", "user.name=a", "-c", "user.email=a@a.com", "commit", "-m", "y")
+	gitOutput(t, remote, "tag", "v1")
+
+	h, err := s.Clone("a.com/r", &vcsclient.CloneInfo{VCS: "git", CloneURL: remote})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Release()
+
+	stats, err := s.Stats("a.com/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Size == 0 || stats.Objects == 0 {
+		t.Errorf("got size %d and %d objects, want nonzero", stats.Size, stats.Objects)
+	}
+	if want := (vcsclient.RefCounts{Branches: 1, Tags: 1}); stats.Refs != want {
+		t.Errorf("got ref counts %+v, want %+v", stats.Refs, want)
+	}
+	if stats.DefaultBranch != "b" || stats.Commits != 2 {
+		t.Errorf("got %d commits on default branch %q, want 2 on b", stats.Commits, stats.DefaultBranch)
+	}
+	if stats.FirstCommit.IsZero() || stats.LastCommit.Before(stats.FirstCommit) {
+		t.Errorf("got first commit %s and last commit %s", stats.FirstCommit, stats.LastCommit)
+	}
+	if len(stats.LargestBlobs) != 2 {
+		t.Fatalf("got largest blobs %s, want 2", asJSON(stats.LargestBlobs))
+	}
+	if b := stats.LargestBlobs[0]; b.Path != "big" || b.Size != 3000 {
+		t.Errorf("got largest blob %+v, want big (3000 bytes)", b)
+	}
+
+	// Stats are memoized until the refs change.
+	if stats2, err := s.Stats("a.com/r"); err != nil || stats2 != stats {
+		t.Errorf("got stats %+v (err %v), want memoized stats", stats2, err)
+	}
+	gitOutput(t, mustCloneDir(t, s, "a.com/r"), "update-ref", "refs/tags/v2", "HEAD")
+	stats2, err := s.Stats("a.com/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats2 == stats || stats2.Refs.Tags != 2 {
+		t.Errorf("got ref counts %+v after adding a tag, want 2 tags", stats2.Refs)
+	}
+
+	// ... and until its objects change (e.g., by gc).
+	if _, err := s.Maintain(MaintenanceOptions{RepoPath: "a.com/r", Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	stats3, err := s.Stats("a.com/r")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats3 == stats2 || stats3.Packs != 1 {
+		t.Errorf("got %d packs after gc, want 1", stats3.Packs)
+	}
+
+	// Concurrent callers share a single computation.
+	gitOutput(t, mustCloneDir(t, s, "a.com/r"), "update-ref", "refs/tags/v3", "HEAD")
+	results := make(chan *vcsclient.RepositoryStats, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			stats, err := s.Stats("a.com/r")
+			if err != nil {
+				t.Error(err)
+			}
+			results <- stats
+		}()
+	}
+	if r1, r2 := <-results, <-results; r1 != r2 || r1 == nil || r1.Refs.Tags != 3 {
+		t.Errorf("got stats %+v and %+v, want the same stats with 3 tags", r1, r2)
+	}
+}
@@ -0,0 +1,68 @@
+package vcsclient
+
+import (
+	"io"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+)
+
+// Archive formats.
+const (
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// ArchiveOptions specifies options for getting an archive of a tree.
+type ArchiveOptions struct {
+	// Format is the archive format: ArchiveTar, ArchiveTarGz, or
+	// ArchiveZip. If empty, ArchiveTar is used.
+	Format string `url:",omitempty"`
+
+	// Path is the subdirectory to archive. If empty, the whole tree is
+	// archived. Names in the archive are relative to Path.
+	Path string `url:",omitempty"`
+
+	// Pathspecs limits the archive to the files that match at least one
+	// of them. A pathspec matches a file if it matches (with
+	// path.Match) the file's path (relative to Path) or one of the
+	// file's parent directories. If no files match, the archive isn't
+	// created (and the server responds with HTTP 404).
+	Pathspecs []string `url:",omitempty"`
+
+	// Prefix is prepended to the names in the archive (e.g., "myrepo/").
+	Prefix string `url:",omitempty"`
+}
+
+// A RepositoryArchiver is a repository whose trees can be downloaded
+// as archives.
+type RepositoryArchiver interface {
+	// Archive returns an archive of the tree at the commit. The
+	// archive is streamed from the server as it is read; the caller
+	// must close it.
+	Archive(at vcs.CommitID, opt *ArchiveOptions) (io.ReadCloser, error)
+}
+
+var _ RepositoryArchiver = (*repository)(nil)
+
+func (r *repository) Archive(at vcs.CommitID, opt *ArchiveOptions) (io.ReadCloser, error) {
+	url, err := r.url(RouteRepoArchive, map[string]string{"CommitID": string(at)}, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := r.client.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckResponse(resp, false); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
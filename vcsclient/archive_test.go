@@ -0,0 +1,43 @@
+package vcsclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestRepository_Archive(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	var called bool
+	mux.HandleFunc(urlPath(t, RouteRepoArchive, repo, map[string]string{"CommitID": "abcd"}), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		testMethod(t, r, "GET")
+		testFormValues(t, r, values{"Format": "zip", "Path": "d", "Pathspecs": "*.go"})
+
+		w.Write([]byte("archive"))
+	})
+
+	rc, err := repo.Archive("abcd", &ArchiveOptions{Format: ArchiveZip, Path: "d", Pathspecs: []string{"*.go"}})
+	if err != nil {
+		t.Fatalf("Repository.Archive returned error: %v", err)
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !called {
+		t.Fatal("!called")
+	}
+
+	if want := "archive"; string(data) != want {
+		t.Errorf("Repository.Archive returned %q, want %q", data, want)
+	}
+}
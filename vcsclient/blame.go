@@ -3,6 +3,10 @@ package vcsclient
 import "sourcegraph.com/sourcegraph/go-vcs/vcs"
 
 func (r *repository) BlameFile(path string, opt *vcs.BlameOptions) ([]*vcs.Hunk, error) {
+	if err := r.checkCapability(CapBlamer); err != nil {
+		return nil, err
+	}
+
 	url, err := r.url(RouteRepoBlameFile, map[string]string{"Path": path}, opt)
 	if err != nil {
 		return nil, err
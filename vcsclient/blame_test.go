@@ -40,3 +40,36 @@ func TestRepository_BlameFile(t *testing.T) {
 		t.Errorf("Repository.BlameFile returned %+v, want %+v", hunks, want)
 	}
 }
+
+func TestRepository_BlameFile_unsupported(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	var describes int
+	mux.HandleFunc(urlPath(t, RouteRepo, repo, nil), func(w http.ResponseWriter, r *http.Request) {
+		describes++
+		writeJSON(w, &RepositoryDescription{ImplementationType: "x", Capabilities: []string{CapSearcher}})
+	})
+	mux.HandleFunc(urlPath(t, RouteRepoBlameFile, repo, map[string]string{"RepoPath": repoPath, "Path": "f"}), func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unexpectedly called BlameFile on server")
+	})
+
+	for i := 0; i < 2; i++ {
+		_, err := repo.BlameFile("f", nil)
+		if _, ok := err.(*UnsupportedError); !ok {
+			t.Errorf("got error %v, want *UnsupportedError", err)
+		}
+		if !IsUnsupported(err) {
+			t.Error("!IsUnsupported(err)")
+		}
+	}
+
+	// The capabilities should only be fetched once.
+	if describes != 1 {
+		t.Errorf("got %d describes, want 1", describes)
+	}
+}
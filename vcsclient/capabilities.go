@@ -0,0 +1,72 @@
+package vcsclient
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Capabilities that a repository on the server may have. Each is the
+// name of a go-vcs interface that the server's implementation of the
+// repository implements.
+const (
+	CapBlamer          = "vcs.Blamer"
+	CapSearcher        = "vcs.Searcher"
+	CapDiffer          = "vcs.Differ"
+	CapCrossRepoDiffer = "vcs.CrossRepoDiffer"
+	CapMerger          = "vcs.Merger"
+	CapCrossRepoMerger = "vcs.CrossRepoMerger"
+	CapRemoteUpdater   = "vcs.RemoteUpdater"
+	CapFileLister      = "vcs.FileLister"
+)
+
+// An UnsupportedError is returned by a repository's methods when the
+// server's implementation of the repository lacks the capability
+// needed.
+type UnsupportedError struct {
+	Capability         string // the missing capability (e.g., CapBlamer)
+	ImplementationType string // the server's implementation of the repository
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s not supported by repository (implementation type %s)", e.Capability, e.ImplementationType)
+}
+
+// IsUnsupported returns whether err indicates that an operation isn't
+// supported by the repository, either because the client knew that
+// it lacks the capability (an *UnsupportedError) or because the
+// server said so (HTTP 501).
+func IsUnsupported(err error) bool {
+	if _, ok := err.(*UnsupportedError); ok {
+		return true
+	}
+	return IsHTTPErrorCode(err, http.StatusNotImplemented)
+}
+
+// checkCapability returns an *UnsupportedError if the repository is
+// known to lack capability. The repository's capabilities are fetched
+// from the server the first time they're needed. If they can't be
+// fetched (or the server doesn't report them), nil is returned, and
+// the server is left to reject unsupported operations.
+func (r *repository) checkCapability(capability string) error {
+	r.capsMu.Lock()
+	desc := r.desc
+	r.capsMu.Unlock()
+
+	if desc == nil {
+		var err error
+		desc, err = r.Describe()
+		if err != nil {
+			return nil
+		}
+	}
+	if desc.Capabilities == nil {
+		return nil
+	}
+
+	for _, c := range desc.Capabilities {
+		if c == capability {
+			return nil
+		}
+	}
+	return &UnsupportedError{Capability: capability, ImplementationType: desc.ImplementationType}
+}
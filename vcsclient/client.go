@@ -10,7 +10,9 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 
+	muxpkg "github.com/sourcegraph/mux"
 	"sourcegraph.com/sourcegraph/go-vcs/vcs"
 	"sourcegraph.com/sourcegraph/vcsstore/git"
 )
@@ -28,6 +30,11 @@ type Client struct {
 	// User agent used for HTTP requests to the vcsstore API.
 	UserAgent string
 
+	// FileCache, if set, caches the trees and file contents read
+	// through the FileSystems of the client's repositories at
+	// canonical commit IDs.
+	FileCache *FileCache
+
 	// HTTP client used to communicate with the vcsstore API.
 	httpClient *http.Client
 
@@ -70,6 +77,36 @@ func (c *Client) GitTransport(repoPath string) (git.GitTransport, error) {
 	return &gitTransport{client: c, repoPath: repoPath}, nil
 }
 
+// url generates the URL to the named vcsstore API endpoint, using the
+// specified route variables and query options.
+func (c *Client) url(routeName string, routeVars map[string]string, opt interface{}) (*url.URL, error) {
+	route := (*muxpkg.Router)(router).Get(routeName)
+	if route == nil {
+		return nil, fmt.Errorf("no API route named %q", routeName)
+	}
+
+	routeVarsList := make([]string, 0, 2*len(routeVars))
+	for name, val := range routeVars {
+		routeVarsList = append(routeVarsList, name, val)
+	}
+	url, err := route.URL(routeVarsList...)
+	if err != nil {
+		return nil, err
+	}
+
+	// make the route URL path relative to BaseURL by trimming the leading "/"
+	url.Path = strings.TrimPrefix(url.Path, "/")
+
+	if opt != nil {
+		err = addOptions(url, opt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return url, nil
+}
+
 // NewRequest creates an API request. A relative URL can be provided in urlStr,
 // in which case it is resolved relative to the BaseURL of the Client. Relative
 // URLs should always be specified without a preceding slash. If specified, the
@@ -143,17 +180,39 @@ func (c *Client) Do(req *http.Request, v interface{}) (*http.Response, error) {
 }
 
 // doIgnoringRedirects sends an API request and returns the HTTP response. If
-// it encounters an HTTP redirect, it does not follow it.
+// it encounters an HTTP redirect, it does not follow it, unless it redirects
+// to the canonical path of the request's repository, which the server
+// reports in the CanonicalRepoPathHeader header (and only when the
+// repository's own path, not that of another repository in the request,
+// was canonicalized).
 func (c *Client) doIgnoringRedirects(req *http.Request) (*http.Response, error) {
-	resp, err := c.ignoreRedirectsHTTPClient.Do(req)
-	if err != nil && !isIgnoredRedirectErr(err) {
-		return nil, err
-	}
-	defer resp.Body.Close()
+	for redirects := 0; ; redirects++ {
+		resp, err := c.ignoreRedirectsHTTPClient.Do(req)
+		if err != nil && !isIgnoredRedirectErr(err) {
+			return nil, err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusMovedPermanently && resp.Header.Get(CanonicalRepoPathHeader) != "" && redirects < maxCanonicalRedirects {
+			loc, err := resp.Location()
+			if err != nil {
+				return nil, err
+			}
+			req, err = c.NewRequest(req.Method, loc.String(), nil)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
 
-	return resp, CheckResponse(resp, true)
+		return resp, CheckResponse(resp, true)
+	}
 }
 
+// maxCanonicalRedirects is the maximum number of redirects to canonical
+// repository paths that doIgnoringRedirects follows.
+const maxCanonicalRedirects = 5
+
 var errIgnoredRedirect = errors.New("not following redirect")
 
 func isIgnoredRedirectErr(err error) bool {
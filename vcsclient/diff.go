@@ -12,6 +12,10 @@ var (
 )
 
 func (r *repository) Diff(base, head vcs.CommitID, opt *vcs.DiffOptions) (*vcs.Diff, error) {
+	if err := r.checkCapability(CapDiffer); err != nil {
+		return nil, err
+	}
+
 	url, err := r.url(RouteRepoDiff, map[string]string{"Base": string(base), "Head": string(head)}, opt)
 	if err != nil {
 		return nil, err
@@ -31,6 +35,10 @@ func (r *repository) Diff(base, head vcs.CommitID, opt *vcs.DiffOptions) (*vcs.D
 }
 
 func (r *repository) CrossRepoDiff(base vcs.CommitID, headRepo vcs.Repository, head vcs.CommitID, opt *vcs.DiffOptions) (*vcs.Diff, error) {
+	if err := r.checkCapability(CapCrossRepoDiffer); err != nil {
+		return nil, err
+	}
+
 	// Only support cross-repo diffing for repos that we know how to
 	// introspect.
 	headRepo2, ok := headRepo.(*repository)
@@ -0,0 +1,327 @@
+package vcsclient
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/godoc/vfs"
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+)
+
+// A FileCache caches the trees and file contents of repositories at
+// canonical (40-character) commit IDs, which never change. Set
+// Client.FileCache to make the FileSystems of the client's
+// repositories use it; it is shared by all of them.
+//
+// A cached FileSystem fetches the tree at its commit once (with a
+// single file listing request) and answers Stat, Lstat, and ReadDir
+// from it (concurrent fetches of the same tree are made once, and a
+// tree too large for the cache is kept by the FileSystem that fetched
+// it). The entries it returns have no modification times. The
+// contents of opened files are kept in a least-recently-used cache
+// whose total size is bounded.
+//
+// Repositories that can't list their files (see CapFileLister) are
+// not cached.
+type FileCache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     *list.List // of *fileCacheItem, most recently used first
+	items   map[fileCacheKey]*list.Element
+	fetches map[fileCacheKey]*treeFetch // in-flight tree fetches
+}
+
+// NewFileCache creates a cache that holds up to maxBytes (roughly) of
+// trees and file contents. Files larger than a quarter of maxBytes
+// are read from the server each time they're opened.
+func NewFileCache(maxBytes int64) *FileCache {
+	return &FileCache{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    map[fileCacheKey]*list.Element{},
+		fetches:  map[fileCacheKey]*treeFetch{},
+	}
+}
+
+// fileCacheKey identifies a tree (if path is empty) or the contents
+// of a file in the cache.
+type fileCacheKey struct {
+	repoPath string
+	at       vcs.CommitID
+	path     string
+}
+
+type fileCacheItem struct {
+	key   fileCacheKey
+	value interface{} // *cachedTree or []byte
+	size  int64
+}
+
+func (c *FileCache) get(key fileCacheKey) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*fileCacheItem).value, true
+}
+
+func (c *FileCache) add(key fileCacheKey, value interface{}, size int64) {
+	if size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.items[key] = c.lru.PushFront(&fileCacheItem{key: key, value: value, size: size})
+	c.size += size
+	for c.size > c.maxBytes {
+		item := c.lru.Remove(c.lru.Back()).(*fileCacheItem)
+		delete(c.items, item.key)
+		c.size -= item.size
+	}
+}
+
+// A treeFetch is an in-flight fetch of a tree. Its t and err are set
+// before done is closed.
+type treeFetch struct {
+	done chan struct{}
+	t    *cachedTree
+	err  error
+}
+
+// errTreeFetchAborted is returned to the callers waiting for a tree
+// fetch that panicked.
+var errTreeFetchAborted = errors.New("tree fetch aborted")
+
+// tree returns the tree of repo at the commit, fetching it if it isn't
+// cached. Concurrent fetches of the same tree are coalesced into one.
+// If the repository can't list its files, errNoCachedTree is returned.
+func (c *FileCache) tree(repo *repository, at vcs.CommitID) (*cachedTree, error) {
+	key := fileCacheKey{repoPath: repo.repoPath, at: at}
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		c.lru.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*fileCacheItem).value.(*cachedTree), nil
+	}
+	if f, ok := c.fetches[key]; ok {
+		c.mu.Unlock()
+		<-f.done
+		return f.t, f.err
+	}
+	f := &treeFetch{done: make(chan struct{}), err: errTreeFetchAborted}
+	c.fetches[key] = f
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.fetches, key)
+		c.mu.Unlock()
+		close(f.done)
+	}()
+
+	files, err := repo.ListFileEntries(at, nil)
+	if err != nil {
+		if IsUnsupported(err) {
+			err = errNoCachedTree
+		}
+		f.err = err
+		return nil, err
+	}
+	t, size := newCachedTree(files)
+	c.add(key, t, size)
+	f.t, f.err = t, nil
+	return t, nil
+}
+
+// A cachedTree is the tree of a repository at a commit.
+type cachedTree struct {
+	entries map[string]os.FileInfo   // by clean path
+	dirs    map[string][]os.FileInfo // by clean dir path, sorted by name
+}
+
+// newCachedTree creates a tree from the files listed in it. It returns
+// the tree and its approximate size in bytes.
+func newCachedTree(files []*FileListEntry) (*cachedTree, int64) {
+	t := &cachedTree{
+		entries: map[string]os.FileInfo{".": &fileInfo{name: ".", mode: os.ModeDir}},
+		dirs:    map[string][]os.FileInfo{".": nil},
+	}
+	var size int64
+	for _, f := range files {
+		t.add(f.Path, &fileInfo{name: pathpkg.Base(f.Path), mode: f.Mode, size: f.Size})
+		size += int64(len(f.Path)) + 100 // rough overhead of the maps and fileInfo
+	}
+	for _, fis := range t.dirs {
+		sort.Sort(fileInfosByName(fis))
+	}
+	return t, size
+}
+
+// add adds the entry (and its parent dirs, if they don't exist).
+func (t *cachedTree) add(path string, fi os.FileInfo) {
+	t.entries[path] = fi
+	if fi.IsDir() {
+		t.dirs[path] = nil
+	}
+	dir := pathpkg.Dir(path)
+	if _, ok := t.entries[dir]; !ok {
+		t.add(dir, &fileInfo{name: pathpkg.Base(dir), mode: os.ModeDir})
+	}
+	t.dirs[dir] = append(t.dirs[dir], fi)
+}
+
+type fileInfosByName []os.FileInfo
+
+func (v fileInfosByName) Len() int           { return len(v) }
+func (v fileInfosByName) Less(i, j int) bool { return v[i].Name() < v[j].Name() }
+func (v fileInfosByName) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+// cachedFS is a repositoryFS that uses a FileCache. Its Get, GetMany,
+// and GetFileWithOptions methods are not cached.
+type cachedFS struct {
+	*repositoryFS
+	cache *FileCache
+
+	mu sync.Mutex
+	t  *cachedTree // the tree, once fetched (even if the cache didn't keep it)
+}
+
+var _ FileSystem = (*cachedFS)(nil)
+
+// errNoCachedTree is returned by cachedFS.tree if the repository can't
+// list its files.
+var errNoCachedTree = errors.New("tree can't be cached")
+
+// tree returns the tree at fs's commit, fetching it if it isn't
+// cached. fs keeps the tree once it's fetched, so a tree that is too
+// large for the cache is only fetched once per FileSystem.
+func (fs *cachedFS) tree() (*cachedTree, error) {
+	fs.mu.Lock()
+	t := fs.t
+	fs.mu.Unlock()
+	if t != nil {
+		return t, nil
+	}
+
+	t, err := fs.cache.tree(fs.repo, fs.at)
+	if err != nil {
+		return nil, err
+	}
+	fs.mu.Lock()
+	fs.t = t
+	fs.mu.Unlock()
+	return t, nil
+}
+
+// isCanonCommitID returns whether at is a full 40-character commit ID.
+func isCanonCommitID(at vcs.CommitID) bool {
+	return len(at) == 40 && strings.IndexFunc(string(at), func(c rune) bool {
+		return !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f'))
+	}) == -1
+}
+
+// cleanPath cleans path as the RouteRepoTreeEntry route does.
+func cleanPath(path string) string {
+	path = pathpkg.Clean(strings.TrimPrefix(path, "/"))
+	if path == "" {
+		return "."
+	}
+	return path
+}
+
+func (fs *cachedFS) Lstat(path string) (os.FileInfo, error) {
+	t, err := fs.tree()
+	if err == errNoCachedTree {
+		return fs.repositoryFS.Lstat(path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	fi, ok := t.entries[cleanPath(path)]
+	if !ok {
+		return nil, &os.PathError{Op: "lstat", Path: path, Err: os.ErrNotExist}
+	}
+	return fi, nil
+}
+
+func (fs *cachedFS) Stat(path string) (os.FileInfo, error) {
+	// TODO(sqs): follow symlinks (as Stat specification requires)
+	return fs.Lstat(path)
+}
+
+func (fs *cachedFS) ReadDir(path string) ([]os.FileInfo, error) {
+	t, err := fs.tree()
+	if err == errNoCachedTree {
+		return fs.repositoryFS.ReadDir(path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	path = cleanPath(path)
+	fis, ok := t.dirs[path]
+	if !ok {
+		if _, exists := t.entries[path]; exists {
+			return nil, &os.PathError{Op: "readdir", Path: path, Err: errors.New("not a directory")}
+		}
+		return nil, &os.PathError{Op: "readdir", Path: path, Err: os.ErrNotExist}
+	}
+	return append([]os.FileInfo(nil), fis...), nil
+}
+
+func (fs *cachedFS) Open(name string) (vfs.ReadSeekCloser, error) {
+	fi, err := fs.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, &os.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+
+	key := fileCacheKey{repoPath: fs.repo.repoPath, at: fs.at, path: cleanPath(name)}
+	if data, ok := fs.cache.get(key); ok {
+		return nopCloser{bytes.NewReader(data.([]byte))}, nil
+	}
+
+	f, err := fs.openRawFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() > fs.cache.maxBytes/4 {
+		// Too large to cache; stream it.
+		return f, nil
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	fs.cache.add(key, data, int64(len(data)))
+	return nopCloser{bytes.NewReader(data)}, nil
+}
+
+func (fs *cachedFS) String() string {
+	return fmt.Sprintf("repository %s commit %s (client, cached)", fs.repo.repoPath, fs.at)
+}
+
+type nopCloser struct {
+	*bytes.Reader
+}
+
+func (nc nopCloser) Close() error { return nil }
@@ -0,0 +1,180 @@
+package vcsclient
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+)
+
+func TestRepository_FileSystem_cached(t *testing.T) {
+	setup()
+	defer teardown()
+	vcsclient.FileCache = NewFileCache(1000)
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+	commitID := vcs.CommitID(strings.Repeat("a", 40))
+
+	var listed, opened int
+	mux.HandleFunc(urlPath(t, RouteRepoFiles, repo, map[string]string{"CommitID": string(commitID)}), func(w http.ResponseWriter, r *http.Request) {
+		listed++
+		writeJSON(w, []*FileListEntry{{Path: "a", Mode: 0644, Size: 3}, {Path: "d/b", Mode: 0755, Size: 1}})
+	})
+	mux.HandleFunc(urlPath(t, RouteRepoRawFile, repo, map[string]string{"CommitID": string(commitID), "Path": "a"}), func(w http.ResponseWriter, r *http.Request) {
+		opened++
+		http.ServeContent(w, r, "a", time.Time{}, bytes.NewReader([]byte("abc")))
+	})
+
+	for i := 0; i < 2; i++ {
+		fs, err := repo.FileSystem(commitID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi, err := fs.Stat("/d/b"); err != nil || fi.Name() != "b" || fi.Mode() != 0755 || fi.Size() != 1 {
+			t.Errorf("got Stat(d/b) == %+v (err %v), want b", fi, err)
+		}
+		if fi, err := fs.Lstat("d"); err != nil || !fi.IsDir() {
+			t.Errorf("got Lstat(d) == %+v (err %v), want dir", fi, err)
+		}
+		if _, err := fs.Stat("doesntexist"); !os.IsNotExist(err) {
+			t.Errorf("got Stat(doesntexist) err == %v, want os.IsNotExist", err)
+		}
+		fis, err := fs.ReadDir(".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fis) != 2 || fis[0].Name() != "a" || fis[1].Name() != "d" {
+			t.Errorf("got ReadDir(.) == %+v, want a and d", fis)
+		}
+
+		f, err := fs.Open("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "abc" {
+			t.Errorf("got contents %q, want abc", data)
+		}
+	}
+
+	// The tree and the file were fetched once and shared by both
+	// FileSystems.
+	if listed != 1 || opened != 1 {
+		t.Errorf("got %d tree and %d file fetches, want 1 each", listed, opened)
+	}
+
+	// Non-canonical commit IDs aren't cached.
+	if fs, _ := repo.FileSystem("abcd"); fs == nil {
+		t.Error("got nil FileSystem")
+	} else if _, ok := fs.(*repositoryFS); !ok {
+		t.Errorf("got FileSystem %T for non-canonical commit ID, want uncached *repositoryFS", fs)
+	}
+}
+
+func TestRepository_FileSystem_cachedLargeTree(t *testing.T) {
+	setup()
+	defer teardown()
+	vcsclient.FileCache = NewFileCache(100) // too small for the tree
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+	commitID := vcs.CommitID(strings.Repeat("a", 40))
+
+	var listed int
+	mux.HandleFunc(urlPath(t, RouteRepoFiles, repo, map[string]string{"CommitID": string(commitID)}), func(w http.ResponseWriter, r *http.Request) {
+		listed++
+		writeJSON(w, []*FileListEntry{{Path: "a", Mode: 0644, Size: 3}, {Path: "d/b", Mode: 0755, Size: 1}})
+	})
+
+	fs, err := repo.FileSystem(commitID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := fs.Stat("a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if listed != 1 {
+		t.Errorf("got %d tree fetches, want 1", listed)
+	}
+}
+
+func TestRepository_FileSystem_cachedConcurrent(t *testing.T) {
+	setup()
+	defer teardown()
+	vcsclient.FileCache = NewFileCache(1000)
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+	commitID := vcs.CommitID(strings.Repeat("a", 40))
+
+	var (
+		mu      sync.Mutex
+		listed  int
+		release = make(chan struct{})
+	)
+	mux.HandleFunc(urlPath(t, RouteRepoFiles, repo, map[string]string{"CommitID": string(commitID)}), func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		listed++
+		mu.Unlock()
+		<-release
+		writeJSON(w, []*FileListEntry{{Path: "a", Mode: 0644, Size: 3}})
+	})
+
+	const n = 5
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fs, err := repo.FileSystem(commitID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := fs.Stat("a"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond) // let the Stat calls start
+	close(release)
+	wg.Wait()
+
+	if listed != 1 {
+		t.Errorf("got %d tree fetches for %d concurrent Stat calls, want 1", listed, n)
+	}
+}
+
+func TestFileCache_evict(t *testing.T) {
+	c := NewFileCache(10)
+	key := func(path string) fileCacheKey { return fileCacheKey{repoPath: "r", at: "c", path: path} }
+
+	c.add(key("a"), []byte("aaaa"), 4)
+	c.add(key("b"), []byte("bbbb"), 4)
+	c.get(key("a")) // a is now more recently used than b
+	c.add(key("c"), []byte("cccc"), 4)
+	c.add(key("d"), []byte("too large to cache"), 18)
+
+	for path, want := range map[string]bool{"a": true, "b": false, "c": true, "d": false} {
+		if _, ok := c.get(key(path)); ok != want {
+			t.Errorf("%s: got cached == %v, want %v", path, ok, want)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package vcsclient
+
+import (
+	"os"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+)
+
+// FileListOptions specifies options for listing the files in a tree.
+type FileListOptions struct {
+	// Pathspecs limits the listing to the files that are at or beneath
+	// one of these paths (e.g., "cmd" or "cmd/main.go").
+	Pathspecs []string `url:",omitempty"`
+
+	// Glob limits the listing to the files whose paths match this
+	// pattern (with path.Match). If it contains no slash, it is matched
+	// against the files' base names instead (e.g., "*.go" matches Go
+	// files in all directories).
+	Glob string `url:",omitempty"`
+
+	N    uint `url:",omitempty"` // limit the number of returned files to this many (0 means no limit)
+	Skip uint `url:",omitempty"` // skip this many files at the beginning
+}
+
+// A FileListEntry is a file in a listing of a tree.
+type FileListEntry struct {
+	// Path is the file's path, relative to the root of the tree.
+	Path string
+
+	// Mode is the file's mode (0644 or 0755 for regular files,
+	// os.ModeSymlink for symlinks, and vcs.ModeSubmodule for
+	// submodules).
+	Mode os.FileMode
+
+	// Size is the size of the file in bytes (or of the symlink's
+	// destination, for symlinks).
+	Size int64
+}
+
+// A RepositoryFileEntryLister is a repository whose files can be
+// listed with their modes and sizes.
+type RepositoryFileEntryLister interface {
+	// ListFileEntries returns the files in the tree at the commit,
+	// sorted by path. The server streams the listing, so large trees
+	// don't need to be buffered in full; to page through them, use
+	// opt.N and opt.Skip. Fewer than opt.N files are returned only at
+	// the end of the listing.
+	ListFileEntries(at vcs.CommitID, opt *FileListOptions) ([]*FileListEntry, error)
+}
+
+var _ RepositoryFileEntryLister = (*repository)(nil)
+var _ vcs.FileLister = (*repository)(nil)
+
+func (r *repository) ListFileEntries(at vcs.CommitID, opt *FileListOptions) ([]*FileListEntry, error) {
+	if err := r.checkCapability(CapFileLister); err != nil {
+		return nil, err
+	}
+
+	url, err := r.url(RouteRepoFiles, map[string]string{"CommitID": string(at)}, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := r.client.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []*FileListEntry
+	if _, err := r.client.Do(req, &files); err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// ListFiles implements vcs.FileLister.
+func (r *repository) ListFiles(at vcs.CommitID) ([]string, error) {
+	files, err := r.ListFileEntries(at, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+	}
+	return paths, nil
+}
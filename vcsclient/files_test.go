@@ -0,0 +1,65 @@
+package vcsclient
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRepository_ListFileEntries(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	want := []*FileListEntry{{Path: "a", Mode: 0644, Size: 1}, {Path: "b/c", Mode: 0755, Size: 2}}
+
+	var called bool
+	mux.HandleFunc(urlPath(t, RouteRepoFiles, repo, map[string]string{"CommitID": "abcd"}), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		testMethod(t, r, "GET")
+		testFormValues(t, r, values{"Glob": "*.go", "N": "2"})
+
+		writeJSON(w, want)
+	})
+
+	files, err := repo.ListFileEntries("abcd", &FileListOptions{Glob: "*.go", N: 2})
+	if err != nil {
+		t.Errorf("Repository.ListFileEntries returned error: %v", err)
+	}
+
+	if !called {
+		t.Fatal("!called")
+	}
+
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Repository.ListFileEntries returned %+v, want %+v", files, want)
+	}
+}
+
+func TestRepository_ListFiles(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	mux.HandleFunc(urlPath(t, RouteRepoFiles, repo, map[string]string{"CommitID": "abcd"}), func(w http.ResponseWriter, r *http.Request) {
+		testMethod(t, r, "GET")
+		testFormValues(t, r, values{})
+
+		writeJSON(w, []*FileListEntry{{Path: "a"}, {Path: "b/c"}})
+	})
+
+	paths, err := repo.ListFiles("abcd")
+	if err != nil {
+		t.Errorf("Repository.ListFiles returned error: %v", err)
+	}
+
+	if want := []string{"a", "b/c"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("Repository.ListFiles returned %v, want %v", paths, want)
+	}
+}
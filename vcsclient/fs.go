@@ -1,9 +1,7 @@
 package vcsclient
 
 import (
-	"bytes"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
@@ -21,6 +19,7 @@ import (
 type FileSystem interface {
 	vfs.FileSystem
 	Get(path string) (*TreeEntry, error)
+	GetMany(reqs []*TreeEntryRequest) ([]*TreeEntryResult, error)
 }
 
 type repositoryFS struct {
@@ -30,13 +29,10 @@ type repositoryFS struct {
 
 var _ FileSystem = &repositoryFS{}
 
+// Open opens the named file. Its contents are streamed from the
+// server as they are read, instead of being fetched all at once.
 func (fs *repositoryFS) Open(name string) (vfs.ReadSeekCloser, error) {
-	e, err := fs.Get(name)
-	if err != nil {
-		return nil, err
-	}
-
-	return nopCloser{bytes.NewReader(e.Contents)}, nil
+	return fs.openRawFile(name)
 }
 
 func (fs *repositoryFS) Lstat(path string) (os.FileInfo, error) {
@@ -273,9 +269,3 @@ func (fs *repositoryFS) url(path string, opt interface{}) (*url.URL, error) {
 		"Path":     path,
 	}, opt)
 }
-
-type nopCloser struct {
-	io.ReadSeeker
-}
-
-func (nc nopCloser) Close() error { return nil }
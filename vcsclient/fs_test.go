@@ -2,11 +2,13 @@ package vcsclient
 
 import (
 	"bytes"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"golang.org/x/tools/godoc/vfs/mapfs"
 	"sourcegraph.com/sqs/pbtypes"
@@ -19,17 +21,14 @@ func TestRepository_FileSystem_Open(t *testing.T) {
 	repoPath := "a.b/c"
 	repo_, _ := vcsclient.Repository(repoPath)
 	repo := repo_.(*repository)
-	want := []byte("c")
-	entry := &TreeEntry{
-		Contents: want,
-	}
+	want := []byte("mydata")
 
-	var called bool
-	mux.HandleFunc(urlPath(t, RouteRepoTreeEntry, repo, map[string]string{"CommitID": "abcd", "Path": "f"}), func(w http.ResponseWriter, r *http.Request) {
-		called = true
+	var requests []string
+	mux.HandleFunc(urlPath(t, RouteRepoRawFile, repo, map[string]string{"CommitID": "abcd", "Path": "f"}), func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("range"))
 		testMethod(t, r, "GET")
 
-		writeJSON(w, entry)
+		http.ServeContent(w, r, "f", time.Time{}, bytes.NewReader(want))
 	})
 
 	fs, err := repo.FileSystem("abcd")
@@ -40,20 +39,32 @@ func TestRepository_FileSystem_Open(t *testing.T) {
 
 	f, err := fs.Open("f")
 	if err != nil {
-		t.Errorf("FileSystem.Open returned error: %v", err)
+		t.Fatalf("FileSystem.Open returned error: %v", err)
 	}
+	defer f.Close()
 
 	data, err := ioutil.ReadAll(f)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("FileSystem.Open returned data %q, want %q", data, want)
+	}
 
-	if !called {
-		t.Fatal("!called")
+	// Seeking makes the next read request the rest of the file.
+	if off, err := f.Seek(-4, io.SeekEnd); err != nil || off != 2 {
+		t.Fatalf("Seek returned %d (err %v), want 2", off, err)
+	}
+	data, err = ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "data"; string(data) != want {
+		t.Errorf("after Seek, read %q, want %q", data, want)
 	}
 
-	if !bytes.Equal(data, want) {
-		t.Errorf("FileSystem.Open returned data %+v, want %+v", data, want)
+	if want := []string{"", "bytes=2-"}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("got requests with ranges %q, want %q", requests, want)
 	}
 }
 
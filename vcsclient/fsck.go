@@ -0,0 +1,85 @@
+package vcsclient
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// FsckOptions specifies options for checking the integrity of a
+// repository on the server.
+type FsckOptions struct {
+	// MarkForReclone, if true, marks the repository to be cloned again
+	// if the check finds problems. The repository is then re-cloned
+	// from its recorded clone URL (or from the clone URL of the
+	// request, if it has one) by its next update, instead of being
+	// updated.
+	MarkForReclone bool `url:",omitempty"`
+}
+
+// An FsckResult is the result of an integrity check of a repository.
+type FsckResult struct {
+	// OK is whether the check found no problems.
+	OK bool
+
+	// Findings are the problems that the check found.
+	Findings []*FsckFinding
+
+	// MarkedForReclone is whether the repository was marked to be
+	// cloned again (see FsckOptions.MarkForReclone).
+	MarkedForReclone bool `json:",omitempty"`
+
+	// Duration is how long the check took.
+	Duration time.Duration
+}
+
+// An FsckFinding is a problem found by an integrity check.
+type FsckFinding struct {
+	// Kind is the kind of problem: "missing" (an object is missing),
+	// "broken-link" (an object refers to a missing or invalid object),
+	// "damaged" (the VCS reported damaged data), "warning", or "error"
+	// (any other error reported by the VCS).
+	Kind string
+
+	// ObjectType and Object identify the missing or damaged object, if
+	// the VCS reported one (e.g., "blob" and its SHA-1 in git).
+	ObjectType string `json:",omitempty"`
+	Object     string `json:",omitempty"`
+
+	// Message is the VCS's description of the problem.
+	Message string
+}
+
+// A RepositoryFscker is a repository whose integrity can be checked on
+// the server.
+type RepositoryFscker interface {
+	// Fsck instructs the server to check the integrity of its clone of
+	// the repository (with git fsck or hg verify). Problems are
+	// reported in the result, not as an error.
+	Fsck(opt *FsckOptions) (*FsckResult, error)
+}
+
+var _ RepositoryFscker = (*repository)(nil)
+
+func (r *repository) Fsck(opt *FsckOptions) (*FsckResult, error) {
+	url, err := r.url(RouteRepoFsck, nil, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := r.client.NewRequest("POST", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *FsckResult
+	resp, err := r.client.Do(req, &result)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fsck: HTTP error %d", resp.StatusCode)
+	}
+
+	return result, nil
+}
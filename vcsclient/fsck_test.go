@@ -0,0 +1,43 @@
+package vcsclient
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRepository_Fsck(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	want := &FsckResult{
+		Findings:         []*FsckFinding{{Kind: "missing", ObjectType: "blob", Object: "abcd", Message: "missing blob abcd"}},
+		MarkedForReclone: true,
+	}
+
+	var called bool
+	mux.HandleFunc(urlPath(t, RouteRepoFsck, repo, nil), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		testMethod(t, r, "POST")
+		testFormValues(t, r, values{"MarkForReclone": "true"})
+
+		writeJSON(w, want)
+	})
+
+	result, err := repo.Fsck(&FsckOptions{MarkForReclone: true})
+	if err != nil {
+		t.Errorf("Repository.Fsck returned error: %v", err)
+	}
+
+	if !called {
+		t.Fatal("!called")
+	}
+
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Repository.Fsck returned %+v, want %+v", result, want)
+	}
+}
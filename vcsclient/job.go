@@ -0,0 +1,163 @@
+package vcsclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+)
+
+// ErrJobNotExist is returned when a job ID is not known to the
+// server (or the job finished long enough ago that it was forgotten).
+var ErrJobNotExist = errors.New("job does not exist")
+
+// JobState is the state of an asynchronous clone-or-update job.
+type JobState string
+
+const (
+	JobRunning   JobState = "running"
+	JobSucceeded JobState = "succeeded"
+	JobFailed    JobState = "failed"
+)
+
+// A Job is an asynchronous clone-or-update of a repository on the
+// server.
+type Job struct {
+	// ID identifies the job among the repository's jobs.
+	ID string
+
+	RepoPath string
+
+	State JobState
+
+	// Phase is the current phase of the clone or update as reported
+	// by the VCS (e.g., "counting", "compressing", "receiving", or
+	// "resolving"), or empty if unknown.
+	Phase string `json:",omitempty"`
+
+	// ReceivedObjects and TotalObjects are the number of objects
+	// received so far and the total number of objects to receive, as
+	// reported by git. They are zero for other VCSs.
+	ReceivedObjects, TotalObjects int64
+
+	// ReceivedBytes is the number of bytes received so far, as
+	// reported by git. It is approximate.
+	ReceivedBytes int64
+
+	// Started and Finished are when the job started and finished. If
+	// the job is still running, Finished is the zero time.
+	Started, Finished time.Time
+
+	// Duration is how long the job has been running for (or took, if
+	// it's finished).
+	Duration time.Duration
+
+	// Error is the error message of a failed job.
+	Error string `json:",omitempty"`
+
+	// TimedOut is whether the job failed because it timed out.
+	TimedOut bool `json:",omitempty"`
+
+	// Result is the result of a successful job. See
+	// RepositoryCloneUpdater.CloneOrUpdate for details.
+	Result *vcs.UpdateResult `json:",omitempty"`
+}
+
+// Done returns whether the job finished (successfully or not).
+func (j *Job) Done() bool { return j.State == JobSucceeded || j.State == JobFailed }
+
+// CloneOrUpdateOptions specifies options for cloning or updating a
+// repository.
+type CloneOrUpdateOptions struct {
+	// Async, if true, makes the server start the clone or update in
+	// the background and respond immediately with a Job.
+	Async bool `url:",omitempty"`
+}
+
+// A RepositoryCloneStarter is a repository that can be cloned or
+// updated asynchronously on the server.
+type RepositoryCloneStarter interface {
+	// StartClone is like RepositoryCloneUpdater.CloneOrUpdate, except
+	// that it returns as soon as the server has started the clone or
+	// update. If a job is already running for the repository, that
+	// job is returned instead of a new one being started.
+	StartClone(cloneInfo *CloneInfo) (*Job, error)
+
+	// Job gets the current state of a job.
+	Job(id string) (*Job, error)
+
+	// WaitJob polls the server until the job is finished. If the job
+	// failed, the job and a non-nil error describing the failure are
+	// returned (ErrTimeout if the job timed out).
+	WaitJob(id string) (*Job, error)
+}
+
+var _ RepositoryCloneStarter = (*repository)(nil)
+
+// JobPollInterval is how often WaitJob polls the server for the
+// state of a job.
+var JobPollInterval = time.Second
+
+func (r *repository) StartClone(cloneInfo *CloneInfo) (*Job, error) {
+	url, err := r.url(RouteRepoCreateOrUpdate, nil, CloneOrUpdateOptions{Async: true})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := r.client.NewRequest("POST", url.String(), cloneInfo)
+	if err != nil {
+		return nil, err
+	}
+	setTimeoutHeader(req, cloneInfo)
+
+	var job *Job
+	resp, err := r.client.Do(req, &job)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusAccepted {
+		return nil, fmt.Errorf("StartClone: HTTP error %d", resp.StatusCode)
+	}
+
+	return job, nil
+}
+
+func (r *repository) Job(id string) (*Job, error) {
+	url, err := r.url(RouteRepoJob, map[string]string{"JobID": id}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := r.client.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var job *Job
+	if _, err := r.client.Do(req, &job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+func (r *repository) WaitJob(id string) (*Job, error) {
+	for {
+		job, err := r.Job(id)
+		if err != nil {
+			return nil, err
+		}
+		if job.Done() {
+			if job.TimedOut {
+				return job, ErrTimeout
+			}
+			if job.State == JobFailed {
+				return job, fmt.Errorf("job %s failed: %s", id, job.Error)
+			}
+			return job, nil
+		}
+		time.Sleep(JobPollInterval)
+	}
+}
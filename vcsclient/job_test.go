@@ -0,0 +1,114 @@
+package vcsclient
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+)
+
+func TestRepository_StartClone(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	opt := &CloneInfo{VCS: "git", CloneURL: "git://a.b/c"}
+	want := &Job{ID: "123", RepoPath: repoPath, State: JobRunning}
+
+	var called bool
+	mux.HandleFunc(urlPath(t, RouteRepo, repo, nil), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		testMethod(t, r, "POST")
+		testFormValues(t, r, values{"Async": "true"})
+
+		body, _ := json.Marshal(opt)
+		testBody(t, r, string(body)+"\n")
+
+		w.WriteHeader(http.StatusAccepted)
+		writeJSON(w, want)
+	})
+
+	job, err := repo.StartClone(opt)
+	if err != nil {
+		t.Errorf("Repository.StartClone returned error: %v", err)
+	}
+
+	if !called {
+		t.Fatal("!called")
+	}
+
+	if !reflect.DeepEqual(job, want) {
+		t.Errorf("Repository.StartClone returned %+v, want %+v", job, want)
+	}
+}
+
+func TestRepository_WaitJob(t *testing.T) {
+	setup()
+	defer teardown()
+
+	defer func(d time.Duration) { JobPollInterval = d }(JobPollInterval)
+	JobPollInterval = time.Millisecond
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	want := &Job{
+		ID:       "123",
+		RepoPath: repoPath,
+		State:    JobSucceeded,
+		Result:   &vcs.UpdateResult{Changes: []vcs.Change{{Op: vcs.NewOp, Branch: "master"}}},
+	}
+
+	var calls int
+	mux.HandleFunc(urlPath(t, RouteRepoJob, repo, map[string]string{"JobID": "123"}), func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		testMethod(t, r, "GET")
+
+		if calls < 3 {
+			writeJSON(w, &Job{ID: "123", RepoPath: repoPath, State: JobRunning, ReceivedObjects: int64(calls)})
+			return
+		}
+		writeJSON(w, want)
+	})
+
+	job, err := repo.WaitJob("123")
+	if err != nil {
+		t.Errorf("Repository.WaitJob returned error: %v", err)
+	}
+
+	if calls != 3 {
+		t.Errorf("got %d calls, want 3", calls)
+	}
+
+	if !reflect.DeepEqual(job, want) {
+		t.Errorf("Repository.WaitJob returned %+v, want %+v", job, want)
+	}
+}
+
+func TestRepository_WaitJob_failed(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	mux.HandleFunc(urlPath(t, RouteRepoJob, repo, map[string]string{"JobID": "123"}), func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &Job{ID: "123", RepoPath: repoPath, State: JobFailed, Error: "x"})
+	})
+
+	job, err := repo.WaitJob("123")
+	if err == nil {
+		t.Error("Repository.WaitJob returned nil error for failed job")
+	}
+	if job == nil || job.Error != "x" {
+		t.Errorf("Repository.WaitJob returned job %+v, want failed job", job)
+	}
+}
@@ -12,6 +12,10 @@ var (
 )
 
 func (r *repository) MergeBase(a, b vcs.CommitID) (vcs.CommitID, error) {
+	if err := r.checkCapability(CapMerger); err != nil {
+		return "", err
+	}
+
 	url, err := r.url(RouteRepoMergeBase, map[string]string{"CommitIDA": string(a), "CommitIDB": string(b)}, nil)
 	if err != nil {
 		return "", err
@@ -31,6 +35,10 @@ func (r *repository) MergeBase(a, b vcs.CommitID) (vcs.CommitID, error) {
 }
 
 func (r *repository) CrossRepoMergeBase(a vcs.CommitID, repoB vcs.Repository, b vcs.CommitID) (vcs.CommitID, error) {
+	if err := r.checkCapability(CapCrossRepoMerger); err != nil {
+		return "", err
+	}
+
 	// Only support cross-repo ops for repos that we know how to
 	// introspect.
 	repoB2, ok := repoB.(*repository)
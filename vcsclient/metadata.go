@@ -0,0 +1,87 @@
+package vcsclient
+
+import "time"
+
+// RepositoryMetadata is what the server records about a repository
+// that it stores.
+type RepositoryMetadata struct {
+	// VCS is the type of VCS (e.g., "git").
+	VCS string
+
+	// CloneURL is the remote URL that the repository was cloned from,
+	// without any password it contained.
+	CloneURL string `json:",omitempty"`
+
+	// Created is when the repository was cloned.
+	Created time.Time
+
+	// LastFetched is when the repository was last successfully cloned
+	// or updated from its remote, and FetchDuration is how long that
+	// took.
+	LastFetched   time.Time
+	FetchDuration time.Duration
+
+	// LastAccessed is approximately when the repository was last read.
+	LastAccessed time.Time
+
+	// LastError is the error message of the last update, if it failed,
+	// and LastErrorTime is when it failed.
+	LastError     string    `json:",omitempty"`
+	LastErrorTime time.Time `json:",omitempty"`
+
+	// ForkParent is the path of the repository whose fork network's
+	// object pool the repository borrows objects from, if any.
+	ForkParent string `json:",omitempty"`
+
+	// NeedsReclone is whether an integrity check found problems and
+	// marked the repository to be cloned again by its next update.
+	NeedsReclone bool `json:",omitempty"`
+}
+
+// A RepositoryDescription describes a repository that is stored on
+// the server. It is the response to a GET of the repository.
+type RepositoryDescription struct {
+	// ImplementationType is the Go type of the server's implementation
+	// of the repository (e.g., "*gitcmd.Repository").
+	ImplementationType string
+
+	// Metadata is what the server has recorded about the repository,
+	// or nil if it hasn't recorded anything.
+	Metadata *RepositoryMetadata `json:",omitempty"`
+
+	// Capabilities lists the go-vcs interfaces that the server's
+	// implementation of the repository implements (see the Cap*
+	// constants). It is nil if the server doesn't report them.
+	Capabilities []string
+}
+
+// A RepositoryDescriber is a repository whose description can be
+// retrieved from the server.
+type RepositoryDescriber interface {
+	Describe() (*RepositoryDescription, error)
+}
+
+var _ RepositoryDescriber = (*repository)(nil)
+
+func (r *repository) Describe() (*RepositoryDescription, error) {
+	url, err := r.url(RouteRepo, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := r.client.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var desc *RepositoryDescription
+	if _, err := r.client.Do(req, &desc); err != nil {
+		return nil, err
+	}
+
+	r.capsMu.Lock()
+	r.desc = desc
+	r.capsMu.Unlock()
+
+	return desc, nil
+}
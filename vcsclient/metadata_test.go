@@ -0,0 +1,49 @@
+package vcsclient
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRepository_Describe(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	want := &RepositoryDescription{
+		ImplementationType: "*gitcmd.Repository",
+		Metadata: &RepositoryMetadata{
+			VCS:          "git",
+			CloneURL:     "https://example.com/a",
+			Created:      time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC),
+			LastFetched:  time.Date(2015, 1, 3, 3, 4, 5, 0, time.UTC),
+			LastAccessed: time.Date(2015, 1, 4, 3, 4, 5, 0, time.UTC),
+		},
+	}
+
+	var called bool
+	mux.HandleFunc(urlPath(t, RouteRepo, repo, nil), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		testMethod(t, r, "GET")
+
+		writeJSON(w, want)
+	})
+
+	desc, err := repo.Describe()
+	if err != nil {
+		t.Errorf("Repository.Describe returned error: %v", err)
+	}
+
+	if !called {
+		t.Fatal("!called")
+	}
+
+	if !reflect.DeepEqual(desc, want) {
+		t.Errorf("Repository.Describe returned %+v, want %+v", desc, want)
+	}
+}
@@ -0,0 +1,132 @@
+package vcsclient
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"sourcegraph.com/sourcegraph/go-vcs/vcs"
+)
+
+// ProgressEventType is the type of a ProgressEvent.
+type ProgressEventType string
+
+const (
+	// ProgressPhase events are sent when a clone or update enters a
+	// new phase (e.g., "counting", "compressing", "receiving",
+	// "resolving", or "renaming" for clones, and "updating" for
+	// updates).
+	ProgressPhase ProgressEventType = "phase"
+
+	// ProgressLine events contain a line of progress output from the
+	// VCS.
+	ProgressLine ProgressEventType = "progress"
+
+	// ProgressSuccess and ProgressError events are sent when a clone
+	// or update finishes.
+	ProgressSuccess ProgressEventType = "success"
+	ProgressError   ProgressEventType = "error"
+)
+
+// A ProgressEvent describes the progress of a clone or update of a
+// repository on the server.
+type ProgressEvent struct {
+	Type ProgressEventType
+
+	Phase string `json:",omitempty"` // the new phase (for phase events)
+	Line  string `json:",omitempty"` // the progress line (for progress events)
+	Error string `json:",omitempty"` // the error message (for error events)
+
+	// Result is the result of a successful update (for success
+	// events of updates only).
+	Result *vcs.UpdateResult `json:",omitempty"`
+}
+
+// Final returns whether the event is the last event of a clone or
+// update.
+func (e *ProgressEvent) Final() bool { return e.Type == ProgressSuccess || e.Type == ProgressError }
+
+// A RepositoryProgressWatcher is a repository whose clone and update
+// progress can be watched.
+type RepositoryProgressWatcher interface {
+	// WatchProgress streams the events of the next (or currently
+	// running) clone or update of the repository on the server. The
+	// events channel is closed after the final (success or error)
+	// event, or after stop is called.
+	WatchProgress() (events <-chan *ProgressEvent, stop func(), err error)
+}
+
+var _ RepositoryProgressWatcher = (*repository)(nil)
+
+func (r *repository) WatchProgress() (<-chan *ProgressEvent, func(), error) {
+	url, err := r.url(RouteRepoProgress, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req, err := r.client.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("accept", "text/event-stream")
+
+	resp, err := r.client.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := CheckResponse(resp, false); err != nil {
+		resp.Body.Close()
+		return nil, nil, err
+	}
+
+	events := make(chan *ProgressEvent)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		readServerSentEvents(bufio.NewScanner(resp.Body), func(data string) bool {
+			var ev *ProgressEvent
+			if err := json.Unmarshal([]byte(data), &ev); err != nil || ev == nil {
+				return true
+			}
+			select {
+			case events <- ev:
+				return !ev.Final()
+			case <-stopped:
+				return false
+			}
+		})
+	}()
+
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			close(stopped)
+			resp.Body.Close()
+		})
+	}
+	return events, stop, nil
+}
+
+// readServerSentEvents calls fn with the data of each event in a
+// text/event-stream, until fn returns false or the stream ends.
+func readServerSentEvents(s *bufio.Scanner, fn func(data string) bool) {
+	var data []string
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case line == "":
+			if len(data) > 0 {
+				if !fn(strings.Join(data, "\n")) {
+					return
+				}
+				data = nil
+			}
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// Ignore comments (keepalives) and the event name, which is
+		// also included in the data.
+	}
+}
@@ -0,0 +1,52 @@
+package vcsclient
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestRepository_WatchProgress(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	var called bool
+	mux.HandleFunc(urlPath(t, RouteRepoProgress, repo, nil), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		testMethod(t, r, "GET")
+
+		w.Header().Set("content-type", "text/event-stream")
+		fmt.Fprint(w, ": keepalive\n\n")
+		fmt.Fprint(w, "event: phase\ndata: {\"Type\":\"phase\",\"Phase\":\"receiving\"}\n\n")
+		fmt.Fprint(w, "event: error\ndata: {\"Type\":\"error\",\"Error\":\"x\"}\n\n")
+		fmt.Fprint(w, "event: phase\ndata: {\"Type\":\"phase\",\"Phase\":\"after final event\"}\n\n")
+	})
+
+	events, stop, err := repo.WatchProgress()
+	if err != nil {
+		t.Fatalf("Repository.WatchProgress returned error: %v", err)
+	}
+	defer stop()
+
+	var got []*ProgressEvent
+	for ev := range events {
+		got = append(got, ev)
+	}
+
+	if !called {
+		t.Fatal("!called")
+	}
+
+	want := []*ProgressEvent{
+		{Type: ProgressPhase, Phase: "receiving"},
+		{Type: ProgressError, Error: "x"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Repository.WatchProgress returned events %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,145 @@
+package vcsclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// A rawFile is a file in a repositoryFS whose contents are read
+// lazily from RouteRepoRawFile. The response body is read as the file
+// is read; seeking makes the next read request the rest of the file
+// from the new offset (with an HTTP Range request).
+type rawFile struct {
+	fs   *repositoryFS
+	name string
+
+	size int64 // -1 if the server didn't report it
+	off  int64
+	body io.ReadCloser
+}
+
+// openRawFile opens the named file and starts reading its contents.
+func (fs *repositoryFS) openRawFile(name string) (*rawFile, error) {
+	f := &rawFile{fs: fs, name: name, size: -1}
+	if err := f.request(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// request requests the file's contents starting at f.off.
+func (f *rawFile) request() error {
+	url, err := f.fs.repo.url(RouteRepoRawFile, map[string]string{
+		"CommitID": string(f.fs.at),
+		"Path":     f.name,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	req, err := f.fs.repo.client.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return err
+	}
+	if f.off > 0 {
+		req.Header.Set("range", fmt.Sprintf("bytes=%d-", f.off))
+	}
+
+	resp, err := f.fs.repo.client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Reading at or past the end of the file.
+		resp.Body.Close()
+		f.body = ioutil.NopCloser(strings.NewReader(""))
+		return nil
+	case http.StatusPartialContent:
+		if size, ok := contentRangeSize(resp.Header.Get("content-range")); ok {
+			f.size = size
+		}
+	case http.StatusOK:
+		if resp.ContentLength >= 0 {
+			f.size = resp.ContentLength
+		}
+		if f.off > 0 {
+			// The server ignored the Range header.
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, f.off); err != nil && err != io.EOF {
+				resp.Body.Close()
+				return err
+			}
+		}
+	default:
+		defer resp.Body.Close()
+		return CheckResponse(resp, false)
+	}
+	f.body = resp.Body
+	return nil
+}
+
+// contentRangeSize returns the complete length given in a
+// Content-Range header (e.g., "bytes 10-19/123").
+func contentRangeSize(contentRange string) (int64, bool) {
+	i := strings.LastIndex(contentRange, "/")
+	if i == -1 {
+		return 0, false
+	}
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	return size, err == nil
+}
+
+func (f *rawFile) Read(p []byte) (int, error) {
+	if f.size >= 0 && f.off >= f.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		if err := f.request(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.body.Read(p)
+	f.off += int64(n)
+	return n, err
+}
+
+func (f *rawFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		if f.size < 0 {
+			return 0, errors.New("rawFile.Seek: file size is unknown")
+		}
+		offset += f.size
+	default:
+		return 0, errors.New("rawFile.Seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("rawFile.Seek: negative position")
+	}
+	if offset != f.off {
+		f.closeBody()
+		f.off = offset
+	}
+	return offset, nil
+}
+
+func (f *rawFile) Close() error {
+	return f.closeBody()
+}
+
+func (f *rawFile) closeBody() error {
+	if f.body == nil {
+		return nil
+	}
+	err := f.body.Close()
+	f.body = nil
+	return err
+}
@@ -7,7 +7,8 @@ import (
 	"net/url"
 	"reflect"
 	"strconv"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/go-querystring/query"
 	muxpkg "github.com/sourcegraph/mux"
@@ -33,6 +34,12 @@ func IsRepoNotExist(err error) bool {
 type repository struct {
 	client   *Client
 	repoPath string
+
+	// desc is the repository's description (which lists its
+	// capabilities), fetched by the first call to Describe. It is
+	// protected by capsMu.
+	desc   *RepositoryDescription
+	capsMu sync.Mutex
 }
 
 var _ vcs.Repository = (*repository)(nil)
@@ -42,9 +49,26 @@ type RepositoryCloneUpdater interface {
 	// it is available to the client via the API if it doesn't yet
 	// exist, or update it from its default remote. The call blocks
 	// until cloning finishes or fails.
-	CloneOrUpdate(cloneInfo *CloneInfo) error
+	//
+	// The returned result lists the branches that were changed. If the
+	// repository was newly cloned, every branch is listed as new. If
+	// the server's VCS implementation doesn't report the results of
+	// updates, the result is nil.
+	CloneOrUpdate(cloneInfo *CloneInfo) (*vcs.UpdateResult, error)
+}
+
+// A RepositoryRemover is a repository that can be removed from the
+// server.
+type RepositoryRemover interface {
+	// Remove instructs the server to remove its clone of the
+	// repository. The call blocks until all of the server's current
+	// users of the repository have finished with it and it has been
+	// removed.
+	Remove() error
 }
 
+var _ RepositoryRemover = (*repository)(nil)
+
 // CloneInfo is the information needed to clone a repository.
 type CloneInfo struct {
 	// VCS is the type of VCS (e.g., "git")
@@ -55,33 +79,98 @@ type CloneInfo struct {
 
 	// Additional options
 	vcs.RemoteOpts
+
+	// Timeout, if nonzero, overrides the server's default clone or
+	// update timeout. It is sent in the TimeoutHeader HTTP header.
+	Timeout time.Duration `json:"-"`
+
+	// FreshWithinSeconds, if nonzero, makes an update of an existing
+	// repository do nothing (and report no changes) if the repository
+	// was successfully cloned or updated within this many seconds.
+	FreshWithinSeconds int `json:",omitempty"`
+
+	// ForkParent, if set, is the path of a repository on the server
+	// that the repository is a fork of. When a git repository is
+	// cloned, it borrows the objects it shares with ForkParent from
+	// the server's object pool for their fork network instead of
+	// storing its own copies. It is ignored if ForkParent hasn't been
+	// cloned on the server.
+	ForkParent string `json:",omitempty"`
 }
 
+// TimeoutHeader is the name of the HTTP header that contains the
+// timeout (formatted as a Go duration string, such as "90s") of a
+// clone or update.
+const TimeoutHeader = "x-vcsstore-timeout"
+
+// ErrTimeout is returned when a clone or update timed out on the
+// server.
+var ErrTimeout = errors.New("clone or update timed out")
+
+// IsTimeout returns whether err indicates that a clone or update
+// timed out on the server.
+func IsTimeout(err error) bool {
+	return err == ErrTimeout || IsHTTPErrorCode(err, http.StatusGatewayTimeout)
+}
+
+// CanonicalRepoPathHeader is the name of the HTTP header that contains
+// the canonical path of the requested repository, if the request used
+// another path for it (such as an alias or a path that isn't
+// normalized). GET requests for such paths are redirected (with 301
+// Moved Permanently) to the canonical path. It isn't set if only the
+// path of another repository in the request (such as the head
+// repository of a cross-repo diff) was canonicalized; such requests
+// are served at the canonical paths without a redirect.
+const CanonicalRepoPathHeader = "x-vcsstore-canonical-repo-path"
+
 func (r *repository) Close() error {
 	// ignored, because each remote call is stateless
 	return nil
 }
 
-func (r *repository) CloneOrUpdate(cloneInfo *CloneInfo) error {
+func (r *repository) CloneOrUpdate(cloneInfo *CloneInfo) (*vcs.UpdateResult, error) {
 	url, err := r.url(RouteRepo, nil, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req, err := r.client.NewRequest("POST", url.String(), cloneInfo)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	setTimeoutHeader(req, cloneInfo)
 
-	resp, err := r.client.Do(req, nil)
+	var result *vcs.UpdateResult
+	resp, err := r.client.Do(req, &result)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if c := resp.StatusCode; c != http.StatusOK && c != http.StatusCreated {
-		return fmt.Errorf("CloneOrUpdate: HTTP error %d", c)
+		return nil, fmt.Errorf("CloneOrUpdate: HTTP error %d", c)
 	}
 
-	return nil
+	return result, nil
+}
+
+func setTimeoutHeader(req *http.Request, cloneInfo *CloneInfo) {
+	if cloneInfo != nil && cloneInfo.Timeout != 0 {
+		req.Header.Set(TimeoutHeader, cloneInfo.Timeout.String())
+	}
+}
+
+func (r *repository) Remove() error {
+	url, err := r.url(RouteRepoRemove, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	req, err := r.client.NewRequest("DELETE", url.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.client.Do(req, nil)
+	return err
 }
 
 func (r *repository) ResolveBranch(name string) (vcs.CommitID, error) {
@@ -268,49 +357,31 @@ func (r *repository) Committers(opt vcs.CommittersOptions) ([]*vcs.Committer, er
 // FileSystem returns a vfs.FileSystem that accesses the repository tree. The
 // returned interface also satisfies vcsclient.FileSystem, which has an
 // additional Get method that is useful for fetching all information about an
-// entry in the tree.
+// entry in the tree. If the client has a FileCache and at is a canonical
+// commit ID, the returned FileSystem uses the cache.
 func (r *repository) FileSystem(at vcs.CommitID) (vfs.FileSystem, error) {
-	return &repositoryFS{
+	fs := &repositoryFS{
 		at:   at,
 		repo: r,
-	}, nil
+	}
+	if r.client.FileCache != nil && isCanonCommitID(at) {
+		return &cachedFS{repositoryFS: fs, cache: r.client.FileCache}, nil
+	}
+	return fs, nil
 }
 
 // router used to generate URLs for the vcsstore API.
 var router = NewRouter(nil)
 
-// url generates the URL to the named vcsstore API endpoint, using the
-// specified route variables and query options.
+// url generates the URL to the named vcsstore API endpoint for this
+// repository, using the specified route variables and query options.
 func (r *repository) url(routeName string, routeVars map[string]string, opt interface{}) (*url.URL, error) {
-	route := (*muxpkg.Router)(router).Get(routeName)
-	if route == nil {
-		return nil, fmt.Errorf("no API route named %q", route)
-	}
-
-	routeVarsList := make([]string, 2*len(routeVars))
-	i := 0
+	vars := make(map[string]string, len(routeVars)+1)
 	for name, val := range routeVars {
-		routeVarsList[i*2] = name
-		routeVarsList[i*2+1] = val
-		i++
-	}
-	routeVarsList = append(routeVarsList, "RepoPath", r.repoPath)
-	url, err := route.URL(routeVarsList...)
-	if err != nil {
-		return nil, err
+		vars[name] = val
 	}
-
-	// make the route URL path relative to BaseURL by trimming the leading "/"
-	url.Path = strings.TrimPrefix(url.Path, "/")
-
-	if opt != nil {
-		err = addOptions(url, opt)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return url, nil
+	vars["RepoPath"] = r.repoPath
+	return r.client.url(routeName, vars, opt)
 }
 
 // addOptions adds the parameters in opt as URL query parameters to u. opt
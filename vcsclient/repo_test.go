@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"reflect"
 	"testing"
+	"time"
 
 	"sourcegraph.com/sourcegraph/go-vcs/vcs"
 )
@@ -23,6 +24,10 @@ func TestRepository_CloneOrUpdate(t *testing.T) {
 		CloneURL:   cloneURL,
 		RemoteOpts: vcs.RemoteOpts{SSH: &vcs.SSHConfig{PrivateKey: []byte("abc")}},
 	}
+	want := &vcs.UpdateResult{Changes: []vcs.Change{
+		{Op: vcs.FFUpdatedOp, Branch: "master"},
+		{Op: vcs.DeletedOp, Branch: "old"},
+	}}
 
 	var called bool
 	mux.HandleFunc(urlPath(t, RouteRepo, repo, nil), func(w http.ResponseWriter, r *http.Request) {
@@ -31,9 +36,11 @@ func TestRepository_CloneOrUpdate(t *testing.T) {
 
 		body, _ := json.Marshal(opt)
 		testBody(t, r, string(body)+"\n")
+
+		writeJSON(w, want)
 	})
 
-	err := repo.CloneOrUpdate(opt)
+	result, err := repo.CloneOrUpdate(opt)
 	if err != nil {
 		t.Errorf("Repository.CloneOrUpdate returned error: %v", err)
 	}
@@ -41,6 +48,65 @@ func TestRepository_CloneOrUpdate(t *testing.T) {
 	if !called {
 		t.Fatal("!called")
 	}
+
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Repository.CloneOrUpdate returned %+v, want %+v", result, want)
+	}
+}
+
+func TestRepository_CloneOrUpdate_timeout(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	var called bool
+	mux.HandleFunc(urlPath(t, RouteRepo, repo, nil), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		testMethod(t, r, "POST")
+		if got, want := r.Header.Get(TimeoutHeader), "1m30s"; got != want {
+			t.Errorf("got %s header %q, want %q", TimeoutHeader, got, want)
+		}
+
+		http.Error(w, "timed out", http.StatusGatewayTimeout)
+	})
+
+	_, err := repo.CloneOrUpdate(&CloneInfo{VCS: "git", CloneURL: "git://a.b/c", Timeout: 90 * time.Second})
+	if !IsTimeout(err) {
+		t.Errorf("Repository.CloneOrUpdate returned error %v, want timeout error", err)
+	}
+
+	if !called {
+		t.Fatal("!called")
+	}
+}
+
+func TestRepository_Remove(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	var called bool
+	mux.HandleFunc(urlPath(t, RouteRepoRemove, repo, nil), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		testMethod(t, r, "DELETE")
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	err := repo.Remove()
+	if err != nil {
+		t.Errorf("Repository.Remove returned error: %v", err)
+	}
+
+	if !called {
+		t.Fatal("!called")
+	}
 }
 
 func TestRepository_ResolveBranch(t *testing.T) {
@@ -272,3 +338,29 @@ func TestRepository_GetCommit(t *testing.T) {
 		t.Errorf("Repository.GetCommit returned %+v, want %+v", commit, want)
 	}
 }
+
+func TestRepository_ResolveBranch_canonicalRedirect(t *testing.T) {
+	setup()
+	defer teardown()
+
+	oldRepo_, _ := vcsclient.Repository("a.b/Old")
+	oldRepo := oldRepo_.(*repository)
+	newRepo_, _ := vcsclient.Repository("a.b/new")
+	newRepo := newRepo_.(*repository)
+
+	mux.HandleFunc(urlPath(t, RouteRepoBranch, oldRepo, map[string]string{"Branch": "mybranch"}), func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(CanonicalRepoPathHeader, "a.b/new")
+		http.Redirect(w, r, urlPath(t, RouteRepoBranch, newRepo, map[string]string{"Branch": "mybranch"}), http.StatusMovedPermanently)
+	})
+	mux.HandleFunc(urlPath(t, RouteRepoBranch, newRepo, map[string]string{"Branch": "mybranch"}), func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, urlPath(t, RouteRepoCommit, newRepo, map[string]string{"CommitID": "abcd"}), http.StatusFound)
+	})
+
+	commitID, err := oldRepo.ResolveBranch("mybranch")
+	if err != nil {
+		t.Fatalf("Repository.ResolveBranch returned error: %v", err)
+	}
+	if want := vcs.CommitID("abcd"); commitID != want {
+		t.Errorf("Repository.ResolveBranch returned %+v, want %+v", commitID, want)
+	}
+}
@@ -0,0 +1,70 @@
+package vcsclient
+
+import (
+	"strconv"
+	"time"
+)
+
+// RepositoryInfo describes a repository that is stored on the server.
+type RepositoryInfo struct {
+	// RepoPath is the path that identifies the repository (e.g.,
+	// "github.com/foo/bar").
+	RepoPath string
+
+	// VCS is the type of VCS (e.g., "git").
+	VCS string
+
+	// Size is the total size of the repository's files on disk, in
+	// bytes.
+	Size int64
+
+	// LastFetched is when the repository was last cloned or updated
+	// from its remote, as recorded in the repository's metadata (or,
+	// if it has none, as determined from the VCS's on-disk metadata).
+	LastFetched time.Time
+}
+
+// RepositoryListOptions specifies options for listing the
+// repositories stored on the server.
+type RepositoryListOptions struct {
+	// Prefix, if set, only lists repositories whose path begins with
+	// this string.
+	Prefix string `url:",omitempty"`
+
+	N    uint `url:",omitempty"` // limit the number of returned repositories to this many (0 means no limit)
+	Skip uint `url:",omitempty"` // skip this many repositories at the beginning
+}
+
+// TotalRepositoriesHeader is the name of the HTTP header that
+// contains the total number of repositories in a call to
+// ListRepositories.
+const TotalRepositoriesHeader = "x-vcsstore-total-repos"
+
+// ListRepositories lists the repositories stored on the server,
+// sorted by repository path. It also returns the total number of
+// repositories matching opt.Prefix (the count of which is not subject
+// to the N/Skip options).
+func (c *Client) ListRepositories(opt RepositoryListOptions) ([]*RepositoryInfo, uint, error) {
+	url, err := c.url(RouteRepos, nil, opt)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := c.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var repos []*RepositoryInfo
+	resp, err := c.Do(req, &repos)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := strconv.ParseUint(resp.Header.Get(TotalRepositoriesHeader), 10, 64)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return repos, uint(total), nil
+}
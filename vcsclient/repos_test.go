@@ -0,0 +1,48 @@
+package vcsclient
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClient_ListRepositories(t *testing.T) {
+	setup()
+	defer teardown()
+
+	opt := RepositoryListOptions{Prefix: "a.b/", N: 1, Skip: 1}
+	want := []*RepositoryInfo{
+		{RepoPath: "a.b/d", VCS: "git", Size: 123, LastFetched: time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}
+
+	var called bool
+	url, _ := vcsclient.url(RouteRepos, nil, nil)
+	mux.HandleFunc("/"+url.Path, func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		testMethod(t, r, "GET")
+		testFormValues(t, r, values{"Prefix": "a.b/", "N": "1", "Skip": "1"})
+
+		w.Header().Set(TotalRepositoriesHeader, "2")
+		writeJSON(w, want)
+	})
+
+	repos, total, err := vcsclient.ListRepositories(opt)
+	if err != nil {
+		t.Errorf("Client.ListRepositories returned error: %v", err)
+	}
+
+	if !called {
+		t.Fatal("!called")
+	}
+
+	for _, r := range repos {
+		normalizeTime(&r.LastFetched)
+	}
+	if !reflect.DeepEqual(repos, want) {
+		t.Errorf("Client.ListRepositories returned %+v, want %+v", repos, want)
+	}
+	if want := uint(2); total != want {
+		t.Errorf("Client.ListRepositories returned total %d, want %d", total, want)
+	}
+}
@@ -14,7 +14,16 @@ import (
 
 const (
 	// Route names
+	RouteAdminAliases           = "vcs:admin.aliases"
+	RouteAdminBrokenRepos       = "vcs:admin.broken-repos"
+	RouteAdminGC                = "vcs:admin.gc"
+	RouteAdminGCStatus          = "vcs:admin.gc-status"
+	RouteAdminMigrateLayout     = "vcs:admin.migrate-layout"
+	RouteAdminPools             = "vcs:admin.pools"
+	RouteAdminSetAlias          = "vcs:admin.set-alias"
+	RouteAdminUpdateQueue       = "vcs:admin.update-queue"
 	RouteRepo                   = "vcs:repo"
+	RouteRepoArchive            = "vcs:repo.archive"
 	RouteRepoBlameFile          = "vcs:repo.blame-file"
 	RouteRepoBranch             = "vcs:repo.branch"
 	RouteRepoBranches           = "vcs:repo.branches"
@@ -23,14 +32,23 @@ const (
 	RouteRepoCommitters         = "vcs:repo.committers"
 	RouteRepoCreateOrUpdate     = "vcs:repo.create-or-update"
 	RouteRepoDiff               = "vcs:repo.diff"
+	RouteRepoFiles              = "vcs:repo.files"
+	RouteRepoFsck               = "vcs:repo.fsck"
+	RouteRepoJob                = "vcs:repo.job"
 	RouteRepoCrossRepoDiff      = "vcs:repo.cross-repo-diff"
 	RouteRepoMergeBase          = "vcs:repo.merge-base"
+	RouteRepoProgress           = "vcs:repo.progress"
+	RouteRepoRawFile            = "vcs:repo.raw-file"
 	RouteRepoCrossRepoMergeBase = "vcs:repo.cross-repo-merge-base"
+	RouteRepoRemove             = "vcs:repo.remove"
 	RouteRepoRevision           = "vcs:repo.rev"
 	RouteRepoSearch             = "vcs:repo.search"
+	RouteRepoStats              = "vcs:repo.stats"
 	RouteRepoTag                = "vcs:repo.tag"
 	RouteRepoTags               = "vcs:repo.tags"
 	RouteRepoTreeEntry          = "vcs:repo.tree-entry"
+	RouteRepoTreeEntryBatch     = "vcs:repo.tree-entry-batch"
+	RouteRepos                  = "vcs:repos"
 	RouteRoot                   = "vcs:root"
 )
 
@@ -44,12 +62,22 @@ func NewRouter(parent *muxpkg.Router) *Router {
 	}
 
 	parent.Path("/").Methods("GET").Name(RouteRoot)
+	parent.Path("/.repos").Methods("GET").Name(RouteRepos)
+	parent.Path("/.admin/update-queue").Methods("GET").Name(RouteAdminUpdateQueue)
+	parent.Path("/.admin/broken-repos").Methods("GET").Name(RouteAdminBrokenRepos)
+	parent.Path("/.admin/migrate-layout").Methods("POST").Name(RouteAdminMigrateLayout)
+	parent.Path("/.admin/aliases").Methods("GET").Name(RouteAdminAliases)
+	parent.Path("/.admin/aliases").Methods("POST").Name(RouteAdminSetAlias)
+	parent.Path("/.admin/pools").Methods("GET").Name(RouteAdminPools)
+	parent.Path("/.admin/gc").Methods("GET").Name(RouteAdminGCStatus)
+	parent.Path("/.admin/gc").Methods("POST").Name(RouteAdminGC)
 
 	const repoURIPattern = "(?:[^./][^/]*)(?:/[^./][^/]*)*"
 
 	repoPath := "/{RepoPath:" + repoURIPattern + "}"
 	parent.Path(repoPath).Methods("GET").Name(RouteRepo)
 	parent.Path(repoPath).Methods("POST").Name(RouteRepoCreateOrUpdate)
+	parent.Path(repoPath).Methods("DELETE").Name(RouteRepoRemove)
 
 	repo := parent.PathPrefix(repoPath).Subrouter()
 
@@ -57,6 +85,10 @@ func NewRouter(parent *muxpkg.Router) *Router {
 	repoGit := repo.PathPrefix("/.git").Subrouter()
 	git.NewRouter(repoGit)
 
+	repo.Path("/.jobs/{JobID}").Methods("GET").Name(RouteRepoJob)
+	repo.Path("/.progress").Methods("GET").Name(RouteRepoProgress)
+	repo.Path("/.fsck").Methods("POST").Name(RouteRepoFsck)
+	repo.Path("/.stats").Methods("GET").Name(RouteRepoStats)
 	repo.Path("/.blame/{Path:.+}").Methods("GET").Name(RouteRepoBlameFile)
 	repo.Path("/.diff/{Base}..{Head}").Methods("GET").Name(RouteRepoDiff)
 	repo.Path("/.cross-repo-diff/{Base}..{HeadRepoPath:" + repoURIPattern + "}:{Head}").Methods("GET").Name(RouteRepoCrossRepoDiff)
@@ -93,15 +125,33 @@ func NewRouter(parent *muxpkg.Router) *Router {
 		return vars
 	}
 	commit.Path("/tree{Path:(?:/.*)*}").Methods("GET").PostMatchFunc(cleanTreeVars).BuildVarsFunc(prepareTreeVars).Name(RouteRepoTreeEntry)
+	commit.Path("/raw{Path:(?:/.*)*}").Methods("GET").PostMatchFunc(cleanTreeVars).BuildVarsFunc(prepareTreeVars).Name(RouteRepoRawFile)
+	commit.Path("/tree-batch").Methods("POST").Name(RouteRepoTreeEntryBatch)
+	commit.Path("/files").Methods("GET").Name(RouteRepoFiles)
+	commit.Path("/archive").Methods("GET").Name(RouteRepoArchive)
 	commit.Path("/search").Methods("GET").Name(RouteRepoSearch)
 
 	return (*Router)(parent)
 }
 
+func (r *Router) URLToRepos(opt RepositoryListOptions) *url.URL {
+	u := r.URLTo(RouteRepos)
+	q, err := query.Values(opt)
+	if err != nil {
+		panic(err.Error())
+	}
+	u.RawQuery = q.Encode()
+	return u
+}
+
 func (r *Router) URLToRepo(repoPath string) *url.URL {
 	return r.URLTo(RouteRepo, "RepoPath", repoPath)
 }
 
+func (r *Router) URLToRepoJob(repoPath, jobID string) *url.URL {
+	return r.URLTo(RouteRepoJob, "RepoPath", repoPath, "JobID", jobID)
+}
+
 func (r *Router) URLToRepoBlameFile(repoPath string, path string, opt *vcs.BlameOptions) *url.URL {
 	u := r.URLTo(RouteRepoBlameFile, "RepoPath", repoPath, "Path", path)
 	if opt != nil {
@@ -31,6 +31,30 @@ func TestMatch(t *testing.T) {
 			wantRouteName: RouteRoot,
 		},
 
+		// Repos
+		{
+			path:          "/.repos",
+			wantRouteName: RouteRepos,
+		},
+
+		// Admin
+		{
+			path:          "/.admin/broken-repos",
+			wantRouteName: RouteAdminBrokenRepos,
+		},
+		{
+			path:          "/.admin/aliases",
+			wantRouteName: RouteAdminAliases,
+		},
+		{
+			path:          "/.admin/pools",
+			wantRouteName: RouteAdminPools,
+		},
+		{
+			path:          "/.admin/gc",
+			wantRouteName: RouteAdminGCStatus,
+		},
+
 		// Repo
 		{
 			path:          "/" + encodedRepoPath,
@@ -42,6 +66,21 @@ func TestMatch(t *testing.T) {
 			wantRouteName: RouteRepo,
 			wantVars:      map[string]string{"RepoPath": "myrepo"},
 		},
+		{
+			path:          "/" + encodedRepoPath + "/.jobs/123abc",
+			wantRouteName: RouteRepoJob,
+			wantVars:      map[string]string{"RepoPath": repoPath, "JobID": "123abc"},
+		},
+		{
+			path:          "/" + encodedRepoPath + "/.progress",
+			wantRouteName: RouteRepoProgress,
+			wantVars:      map[string]string{"RepoPath": repoPath},
+		},
+		{
+			path:          "/" + encodedRepoPath + "/.stats",
+			wantRouteName: RouteRepoStats,
+			wantVars:      map[string]string{"RepoPath": repoPath},
+		},
 
 		// Repo revisions
 		{
@@ -111,6 +150,27 @@ func TestMatch(t *testing.T) {
 			wantPath:      "/" + encodedRepoPath + "/.commits/mycommitid/tree/a/b",
 		},
 
+		// Repo archive
+		{
+			path:          "/" + encodedRepoPath + "/.commits/mycommitid/archive",
+			wantRouteName: RouteRepoArchive,
+			wantVars:      map[string]string{"RepoPath": repoPath, "CommitID": "mycommitid"},
+		},
+
+		// Repo files
+		{
+			path:          "/" + encodedRepoPath + "/.commits/mycommitid/files",
+			wantRouteName: RouteRepoFiles,
+			wantVars:      map[string]string{"RepoPath": repoPath, "CommitID": "mycommitid"},
+		},
+
+		// Repo raw file
+		{
+			path:          "/" + encodedRepoPath + "/.commits/mycommitid/raw/a/b",
+			wantRouteName: RouteRepoRawFile,
+			wantVars:      map[string]string{"RepoPath": repoPath, "CommitID": "mycommitid", "Path": "a/b"},
+		},
+
 		// Diff
 		{
 			path:          "/" + encodedRepoPath + "/.diff/a..b",
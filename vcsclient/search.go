@@ -3,6 +3,10 @@ package vcsclient
 import "sourcegraph.com/sourcegraph/go-vcs/vcs"
 
 func (r *repository) Search(at vcs.CommitID, opt vcs.SearchOptions) ([]*vcs.SearchResult, error) {
+	if err := r.checkCapability(CapSearcher); err != nil {
+		return nil, err
+	}
+
 	url, err := r.url(RouteRepoSearch, map[string]string{"CommitID": string(at)}, opt)
 	if err != nil {
 		return nil, err
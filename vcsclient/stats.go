@@ -0,0 +1,93 @@
+package vcsclient
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// RepositoryStats describes the size and history of a repository on
+// the server.
+type RepositoryStats struct {
+	// Size is the size in bytes of the repository's directory on disk
+	// (not counting objects borrowed from an object pool).
+	Size int64
+
+	// Packs and Objects are the numbers of packs and of objects (loose
+	// and packed) in the repository.
+	Packs   int
+	Objects int
+
+	// Refs holds the numbers of refs in the repository, by kind.
+	Refs RefCounts
+
+	// DefaultBranch is the repository's default branch (if any), and
+	// Commits is the number of commits reachable from it.
+	DefaultBranch string `json:",omitempty"`
+	Commits       int
+
+	// FirstCommit and LastCommit are the committer dates of the
+	// oldest root commit and of the head commit of the default branch.
+	FirstCommit time.Time `json:",omitempty"`
+	LastCommit  time.Time `json:",omitempty"`
+
+	// LargestBlobs are the largest blobs reachable from any ref,
+	// largest first.
+	LargestBlobs []*BlobSize
+
+	// Computed is when the stats were computed. Stats are recomputed
+	// only when the repository's refs change, so they may be older than
+	// the last update of the repository.
+	Computed time.Time
+}
+
+// RefCounts holds the numbers of refs in a repository, by kind.
+type RefCounts struct {
+	Branches int
+	Tags     int
+	Other    int
+}
+
+// A BlobSize is a blob and its size.
+type BlobSize struct {
+	ID string
+
+	// Path is a path at which the blob appears in some commit.
+	Path string `json:",omitempty"`
+
+	// Size is the size of the blob in bytes.
+	Size int64
+}
+
+// A RepositoryStatser is a repository whose stats can be computed on
+// the server.
+type RepositoryStatser interface {
+	// Stats returns the repository's stats. The server memoizes stats
+	// until the repository's refs change, so they are cheap to poll.
+	Stats() (*RepositoryStats, error)
+}
+
+var _ RepositoryStatser = (*repository)(nil)
+
+func (r *repository) Stats() (*RepositoryStats, error) {
+	url, err := r.url(RouteRepoStats, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := r.client.NewRequest("GET", url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats *RepositoryStats
+	resp, err := r.client.Do(req, &stats)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Stats: HTTP error %d", resp.StatusCode)
+	}
+
+	return stats, nil
+}
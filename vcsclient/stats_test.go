@@ -0,0 +1,48 @@
+package vcsclient
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRepository_Stats(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	want := &RepositoryStats{
+		Size:          1234,
+		Refs:          RefCounts{Branches: 2, Tags: 1},
+		DefaultBranch: "master",
+		Commits:       5,
+		FirstCommit:   time.Unix(1, 0).UTC(),
+		LastCommit:    time.Unix(2, 0).UTC(),
+		LargestBlobs:  []*BlobSize{{ID: "abcd", Path: "f", Size: 10}},
+	}
+
+	var called bool
+	mux.HandleFunc(urlPath(t, RouteRepoStats, repo, nil), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		testMethod(t, r, "GET")
+
+		writeJSON(w, want)
+	})
+
+	stats, err := repo.Stats()
+	if err != nil {
+		t.Errorf("Repository.Stats returned error: %v", err)
+	}
+
+	if !called {
+		t.Fatal("!called")
+	}
+
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("Repository.Stats returned %+v, want %+v", stats, want)
+	}
+}
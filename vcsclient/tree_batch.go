@@ -0,0 +1,75 @@
+package vcsclient
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// MaxTreeEntryBatch is the maximum number of tree entries that can be
+// requested in a single GetMany call.
+const MaxTreeEntryBatch = 1000
+
+// A TreeEntryRequest requests a tree entry (in a GetMany call).
+type TreeEntryRequest struct {
+	Path string
+
+	// Options specifies the range of the file to return, etc. (as for
+	// GetFileWithOptions).
+	Options GetFileOptions
+}
+
+// A TreeEntryResult is the result of one of the TreeEntryRequests of a
+// GetMany call. Exactly one of File and Error is set.
+type TreeEntryResult struct {
+	Path string
+
+	File *FileWithRange `json:",omitempty"`
+
+	// Error describes why the entry couldn't be gotten, and NotExist
+	// is whether it was because the path doesn't exist.
+	Error    string `json:",omitempty"`
+	NotExist bool   `json:",omitempty"`
+}
+
+// Err returns the error getting the entry, or nil if it was gotten. If
+// the path doesn't exist, the error satisfies os.IsNotExist.
+func (r *TreeEntryResult) Err() error {
+	switch {
+	case r.NotExist:
+		return &os.PathError{Op: "get", Path: r.Path, Err: os.ErrNotExist}
+	case r.Error != "":
+		return errors.New(r.Error)
+	}
+	return nil
+}
+
+// GetMany gets the tree entries in reqs in a single request. The
+// results are in the same order as reqs. Errors getting individual
+// entries are reported in their results (see TreeEntryResult.Err);
+// err is only non-nil if the request as a whole failed.
+func (fs *repositoryFS) GetMany(reqs []*TreeEntryRequest) ([]*TreeEntryResult, error) {
+	if len(reqs) > MaxTreeEntryBatch {
+		return nil, fmt.Errorf("GetMany: %d tree entries requested (max %d)", len(reqs), MaxTreeEntryBatch)
+	}
+
+	url, err := fs.repo.url(RouteRepoTreeEntryBatch, map[string]string{"CommitID": string(fs.at)}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := fs.repo.client.NewRequest("POST", url.String(), reqs)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []*TreeEntryResult
+	if _, err := fs.repo.client.Do(req, &results); err != nil {
+		return nil, err
+	}
+	if len(results) != len(reqs) {
+		return nil, fmt.Errorf("GetMany: got %d results for %d tree entries", len(results), len(reqs))
+	}
+
+	return results, nil
+}
@@ -0,0 +1,59 @@
+package vcsclient
+
+import (
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestRepository_FileSystem_GetMany(t *testing.T) {
+	setup()
+	defer teardown()
+
+	repoPath := "a.b/c"
+	repo_, _ := vcsclient.Repository(repoPath)
+	repo := repo_.(*repository)
+
+	reqs := []*TreeEntryRequest{
+		{Path: "f", Options: GetFileOptions{FileRange: FileRange{StartLine: 1, EndLine: 2}}},
+		{Path: "g"},
+	}
+	want := []*TreeEntryResult{
+		{Path: "f", File: &FileWithRange{TreeEntry: &TreeEntry{Name: "f", Contents: []byte("x")}, FileRange: FileRange{StartLine: 1, EndLine: 2}}},
+		{Path: "g", Error: "file does not exist", NotExist: true},
+	}
+
+	var called bool
+	mux.HandleFunc(urlPath(t, RouteRepoTreeEntryBatch, repo, map[string]string{"CommitID": "abcd"}), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		testMethod(t, r, "POST")
+		testBody(t, r, `[{"Path":"f","Options":{"StartLine":1,"EndLine":2}},{"Path":"g","Options":{}}]`+"\n")
+
+		writeJSON(w, want)
+	})
+
+	fs, err := repo.FileSystem("abcd")
+	if err != nil {
+		t.Fatalf("Repository.FileSystem returned error: %v", err)
+	}
+
+	results, err := fs.(FileSystem).GetMany(reqs)
+	if err != nil {
+		t.Errorf("FileSystem.GetMany returned error: %v", err)
+	}
+
+	if !called {
+		t.Fatal("!called")
+	}
+
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("FileSystem.GetMany returned %+v, want %+v", results, want)
+	}
+	if err := results[0].Err(); err != nil {
+		t.Errorf("got error %v for f, want nil", err)
+	}
+	if err := results[1].Err(); !os.IsNotExist(err) {
+		t.Errorf("got error %v for g, want os.IsNotExist", err)
+	}
+}